@@ -0,0 +1,185 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrQueueFull is returned by AsyncPublisher.Publish when a message was dropped
+// because the queue stayed full for AsyncConfig.BlockTimeout.
+var ErrQueueFull = errors.New("publish queue full")
+
+type AsyncConfig struct {
+	// QueueSize is how many messages can wait to be written
+	QueueSize int
+	// A batch is written once it has BatchSize messages or its first message
+	// has waited BatchTimeout
+	BatchSize    int
+	BatchTimeout time.Duration
+	// WriteTimeout bounds each batch write
+	WriteTimeout time.Duration
+	// BlockTimeout is how long Publish waits for room in a full queue before it
+	// drops the message. Zero drops it right away.
+	BlockTimeout time.Duration
+	// OnDelivery, if set, is called from the writing goroutine with every
+	// message once it is written, or with the error it failed with.
+	OnDelivery func(msg Message, err error)
+}
+
+// AsyncStats counts the messages of an AsyncPublisher.
+type AsyncStats struct {
+	// Queued is how many messages are waiting to be written
+	Queued  int64
+	Sent    uint64
+	Failed  uint64
+	Dropped uint64
+	Batches uint64
+}
+
+// AsyncPublisher queues messages and writes them to a broker in batches from a
+// single goroutine, in the order they were queued.
+type AsyncPublisher struct {
+	broker Broker
+	cfg    AsyncConfig
+	queue  chan Message
+	done   chan struct{}
+
+	// mu guards closed; Publish holds it for reading while it sends to queue
+	mu     sync.RWMutex
+	closed bool
+
+	queued  atomic.Int64
+	sent    atomic.Uint64
+	failed  atomic.Uint64
+	dropped atomic.Uint64
+	batches atomic.Uint64
+}
+
+func NewAsyncPublisher(b Broker, cfg AsyncConfig) *AsyncPublisher {
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	p := &AsyncPublisher{
+		broker: b,
+		cfg:    cfg,
+		queue:  make(chan Message, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// Publish queues msg. It returns ErrQueueFull when the message is dropped and
+// ErrClosed once the publisher is closed; write errors go to OnDelivery.
+func (p *AsyncPublisher) Publish(msg Message) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		return ErrClosed
+	}
+	if msg.Time.IsZero() {
+		msg.Time = time.Now()
+	}
+
+	p.queued.Add(1)
+	select {
+	case p.queue <- msg:
+		return nil
+	default:
+	}
+	if p.cfg.BlockTimeout > 0 {
+		timer := time.NewTimer(p.cfg.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case p.queue <- msg:
+			return nil
+		case <-timer.C:
+		}
+	}
+	p.queued.Add(-1)
+	p.dropped.Add(1)
+	return ErrQueueFull
+}
+
+// Close stops accepting messages and waits until the queued ones are written,
+// or until ctx is done. It doesn't close the broker.
+func (p *AsyncPublisher) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.queue)
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *AsyncPublisher) Stats() AsyncStats {
+	return AsyncStats{
+		Queued:  p.queued.Load(),
+		Sent:    p.sent.Load(),
+		Failed:  p.failed.Load(),
+		Dropped: p.dropped.Load(),
+		Batches: p.batches.Load(),
+	}
+}
+
+func (p *AsyncPublisher) run() {
+	defer close(p.done)
+	batch := make([]Message, 0, p.cfg.BatchSize)
+	timer := time.NewTimer(p.cfg.BatchTimeout)
+	timer.Stop()
+
+	for {
+		select {
+		case msg, ok := <-p.queue:
+			if !ok {
+				p.write(batch)
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) == 1 {
+				timer.Reset(p.cfg.BatchTimeout)
+			}
+			if len(batch) >= p.cfg.BatchSize {
+				timer.Stop()
+				batch = p.write(batch)
+			}
+		case <-timer.C:
+			batch = p.write(batch)
+		}
+	}
+}
+
+// write publishes batch and returns it emptied for reuse.
+func (p *AsyncPublisher) write(batch []Message) []Message {
+	if len(batch) == 0 {
+		return batch
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.cfg.WriteTimeout)
+	err := p.broker.Publish(ctx, batch...)
+	cancel()
+
+	p.batches.Add(1)
+	if err != nil {
+		p.failed.Add(uint64(len(batch)))
+	} else {
+		p.sent.Add(uint64(len(batch)))
+	}
+	if p.cfg.OnDelivery != nil {
+		for _, msg := range batch {
+			p.cfg.OnDelivery(msg, err)
+		}
+	}
+	p.queued.Add(-int64(len(batch)))
+	clear(batch)
+	return batch[:0]
+}
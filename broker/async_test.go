@@ -0,0 +1,263 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatedBroker is a memory broker whose writes wait until release is closed.
+type gatedBroker struct {
+	Broker
+	entered chan struct{}
+	release chan struct{}
+}
+
+func newGatedBroker() *gatedBroker {
+	return &gatedBroker{Broker: NewMemory(), entered: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (b *gatedBroker) Publish(ctx context.Context, msgs ...Message) error {
+	b.entered <- struct{}{}
+	<-b.release
+	return b.Broker.Publish(ctx, msgs...)
+}
+
+// failingBroker fails every write.
+type failingBroker struct {
+	Broker
+}
+
+var errWriteFailed = errors.New("write failed")
+
+func (b failingBroker) Publish(ctx context.Context, msgs ...Message) error {
+	return errWriteFailed
+}
+
+// drain fetches n messages of topic and returns their values.
+func drain(t *testing.T, b Broker, topic string, n int) []string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := b.Subscribe(topic, "drain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	var values []string
+	for len(values) < n {
+		msg, err := sub.Fetch(ctx)
+		if err != nil {
+			t.Fatalf("fetched %v, then: %v", values, err)
+		}
+		values = append(values, string(msg.Value))
+	}
+	return values
+}
+
+func closePublisher(t *testing.T, p *AsyncPublisher) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := p.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAsyncPublisherDropsWhenFull(t *testing.T) {
+	b := newGatedBroker()
+	p := NewAsyncPublisher(b, AsyncConfig{QueueSize: 1, BatchSize: 1, WriteTimeout: time.Second})
+
+	if err := p.Publish(Message{Topic: "t", Value: []byte("1")}); err != nil {
+		t.Fatal(err)
+	}
+	// the writer holds 1 and the queue 2, so 3 has no room
+	<-b.entered
+	if err := p.Publish(Message{Topic: "t", Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Publish(Message{Topic: "t", Value: []byte("3")}); err != ErrQueueFull {
+		t.Fatalf("Publish to a full queue = %v, want ErrQueueFull", err)
+	}
+	if stats := p.Stats(); stats.Dropped != 1 || stats.Queued != 2 {
+		t.Fatalf("stats with a full queue = %+v", stats)
+	}
+
+	close(b.release)
+	closePublisher(t, p)
+	if got := drain(t, b, "t", 2); got[0] != "1" || got[1] != "2" {
+		t.Fatalf("written %v, want [1 2]", got)
+	}
+	if stats := p.Stats(); stats.Sent != 2 || stats.Queued != 0 || stats.Dropped != 1 {
+		t.Fatalf("stats after close = %+v", stats)
+	}
+}
+
+func TestAsyncPublisherBlockTimeout(t *testing.T) {
+	b := newGatedBroker()
+	p := NewAsyncPublisher(b, AsyncConfig{QueueSize: 1, BatchSize: 1, WriteTimeout: time.Second, BlockTimeout: time.Second})
+	defer closePublisher(t, p)
+	defer close(b.release)
+
+	p.Publish(Message{Topic: "t", Value: []byte("1")})
+	<-b.entered
+	p.Publish(Message{Topic: "t", Value: []byte("2")})
+
+	// Publish waits for the writer to make room
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		b.release <- struct{}{}
+	}()
+	if err := p.Publish(Message{Topic: "t", Value: []byte("3")}); err != nil {
+		t.Fatalf("Publish while the writer makes room = %v", err)
+	}
+}
+
+func TestAsyncPublisherCloseDrains(t *testing.T) {
+	b := NewMemory()
+	// nothing is written before Close but for the batch timeout
+	p := NewAsyncPublisher(b, AsyncConfig{QueueSize: 10, BatchSize: 100, BatchTimeout: time.Hour, WriteTimeout: time.Second})
+	want := []string{"1", "2", "3", "4", "5"}
+	for _, v := range want {
+		if err := p.Publish(Message{Topic: "t", Value: []byte(v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	closePublisher(t, p)
+	got := drain(t, b, "t", len(want))
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("written %v, want %v", got, want)
+		}
+	}
+	if stats := p.Stats(); stats.Sent != 5 || stats.Batches != 1 || stats.Queued != 0 {
+		t.Fatalf("stats after close = %+v", stats)
+	}
+	if err := p.Publish(Message{Topic: "t"}); err != ErrClosed {
+		t.Fatalf("Publish after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestAsyncPublisherCloseGivesUpWithContext(t *testing.T) {
+	b := newGatedBroker()
+	p := NewAsyncPublisher(b, AsyncConfig{QueueSize: 1, BatchSize: 1, WriteTimeout: time.Second})
+	p.Publish(Message{Topic: "t"})
+	<-b.entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Close with a stuck write = %v, want DeadlineExceeded", err)
+	}
+	close(b.release)
+	closePublisher(t, p)
+}
+
+func TestAsyncPublisherReportsFailures(t *testing.T) {
+	var mu sync.Mutex
+	var delivered []error
+	p := NewAsyncPublisher(failingBroker{NewMemory()}, AsyncConfig{
+		QueueSize:    10,
+		BatchSize:    2,
+		BatchTimeout: time.Hour,
+		WriteTimeout: time.Second,
+		OnDelivery: func(msg Message, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			delivered = append(delivered, err)
+		},
+	})
+	for range 3 {
+		if err := p.Publish(Message{Topic: "t"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	closePublisher(t, p)
+
+	if len(delivered) != 3 {
+		t.Fatalf("OnDelivery called %d times, want 3", len(delivered))
+	}
+	for _, err := range delivered {
+		if err != errWriteFailed {
+			t.Fatalf("OnDelivery error = %v, want %v", err, errWriteFailed)
+		}
+	}
+	if stats := p.Stats(); stats.Failed != 3 || stats.Sent != 0 || stats.Batches != 2 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+// metricValue returns the value of the metric with the service label on the default registry.
+func metricValue(t *testing.T, name string, service string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "service" && label.GetValue() == service {
+					if m.GetCounter() != nil {
+						return m.GetCounter().GetValue()
+					}
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	t.Fatalf("no %s for service %s", name, service)
+	return 0
+}
+
+func TestRegisterAsyncMetrics(t *testing.T) {
+	sent := NewAsyncPublisher(NewMemory(), AsyncConfig{QueueSize: 10, BatchSize: 1, WriteTimeout: time.Second})
+	failed := NewAsyncPublisher(failingBroker{NewMemory()}, AsyncConfig{QueueSize: 10, BatchSize: 1, WriteTimeout: time.Second})
+	// the default registry outlives the test, so -count runs need their own services
+	run := strconv.FormatInt(time.Now().UnixNano(), 36)
+	sentService, failedService := "sent-"+run, "failed-"+run
+	// the producers of every service share the metric names
+	if err := RegisterAsyncMetrics(sent, sentService); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterAsyncMetrics(failed, failedService); err != nil {
+		t.Fatalf("registering a second service: %v", err)
+	}
+	if err := RegisterAsyncMetrics(sent, sentService); err == nil {
+		t.Fatal("registering a service twice succeeded")
+	}
+
+	for range 2 {
+		sent.Publish(Message{Topic: "t"})
+		failed.Publish(Message{Topic: "t"})
+	}
+	closePublisher(t, sent)
+	closePublisher(t, failed)
+
+	for _, c := range []struct {
+		name    string
+		service string
+		want    float64
+	}{
+		{"producer_messages_sent_total", sentService, 2},
+		{"producer_messages_failed_total", sentService, 0},
+		{"producer_batches_total", sentService, 2},
+		{"producer_queue_depth", sentService, 0},
+		{"producer_messages_sent_total", failedService, 0},
+		{"producer_messages_failed_total", failedService, 2},
+		{"producer_messages_dropped_total", failedService, 0},
+	} {
+		if got := metricValue(t, c.name, c.service); got != c.want {
+			t.Errorf("%s{service=%s} = %v, want %v", c.name, c.service, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Package broker is the message broker the services publish events to and
+// workerservice consumes them from. Kafka is used in production; NATS
+// JetStream can take its place, and the in-memory broker from NewMemory
+// connects producers and consumers in one process, such as a test, without any
+// external service.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+var (
+	// ErrClosed is returned by Fetch once the subscription or its broker is closed.
+	ErrClosed = errors.New("broker closed")
+	// ErrUnknownTopic is returned by AlterTopic for a topic that doesn't exist.
+	ErrUnknownTopic = errors.New("unknown topic")
+)
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers []Header
+	Time    time.Time
+	// Partition and Offset are set on fetched messages. Offsets increase
+	// within a partition.
+	Partition int
+	Offset    int64
+}
+
+// Header returns the value of the header with the given key, or "".
+func (m Message) Header(key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// TopicConfig describes a topic. Brokers ignore the settings they don't have:
+// NATS and the in-memory broker don't partition or compact topics.
+type TopicConfig struct {
+	Topic         string
+	NumPartitions int
+	// ReplicationFactor zero uses the broker's default
+	ReplicationFactor int
+	// Retention is how long messages are kept. Zero uses the broker's default
+	// and a negative value keeps them forever.
+	Retention time.Duration
+	// Compact keeps the last message of each key instead of deleting messages
+	// older than Retention
+	Compact bool
+}
+
+// TopicInfo is an existing topic. NumPartitions is zero on brokers without
+// partitions.
+type TopicInfo struct {
+	TopicConfig
+	Partitions []PartitionInfo
+}
+
+// PartitionInfo names the brokers, or servers, holding a partition.
+type PartitionInfo struct {
+	ID       int
+	Leader   string
+	Replicas []string
+	InSync   []string
+}
+
+type Broker interface {
+	// Publish writes the messages to the topics they name. Messages with the
+	// same key on a topic are delivered in the order they were published.
+	Publish(ctx context.Context, msgs ...Message) error
+	// Subscribe reads a topic as a member of group. Each message is delivered
+	// to one member of the group, at least once.
+	Subscribe(topic string, group string) (Subscription, error)
+	// CreateTopics creates the topics that don't exist yet and leaves the
+	// others as they are.
+	CreateTopics(ctx context.Context, topics ...TopicConfig) error
+	// DescribeTopics returns those of the topics that exist, or every topic
+	// when none is given, with their effective settings.
+	DescribeTopics(ctx context.Context, topics ...string) ([]TopicInfo, error)
+	// AlterTopic brings an existing topic to cfg. Partitions can only be
+	// added, and Kafka can't change the replication factor of a topic.
+	// Settings already as in cfg are left alone, so passing back what
+	// DescribeTopics returned doesn't pin the broker's defaults on the topic.
+	AlterTopic(ctx context.Context, cfg TopicConfig) error
+	// Ping returns an error when the broker can't be reached.
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+type Subscription interface {
+	// Fetch blocks until a message arrives. It returns ctx.Err() when ctx is
+	// done and ErrClosed once the subscription is closed.
+	Fetch(ctx context.Context) (Message, error)
+	// Commit marks msg and every earlier message of its partition as handled.
+	// A group that subscribes again resumes after the last committed message.
+	Commit(ctx context.Context, msg Message) error
+	// Lag asks the broker how many messages of each partition of the topic
+	// the group hasn't committed. It covers the whole group, not only the
+	// partitions this member reads.
+	Lag(ctx context.Context) ([]PartitionLag, error)
+	Close() error
+}
+
+// PartitionLag is the number of messages of a partition published after the
+// last one its group committed.
+type PartitionLag struct {
+	Topic     string
+	Partition int
+	Lag       int64
+}
+
+const (
+	Kafka  = "kafka"
+	NATS   = "nats"
+	Memory = "memory"
+)
+
+type Config struct {
+	// Driver is Kafka or NATS
+	Driver string
+	// KafkaBrokers is a comma separated list of host:port
+	KafkaBrokers string
+	NATSURL      string
+}
+
+// Environment variables that override the compiled-in Config of a service, so
+// that the broker can be switched without a rebuild.
+const (
+	DriverEnv       = "BROKER_DRIVER"
+	KafkaBrokersEnv = "KAFKA_BROKERS"
+	NATSURLEnv      = "NATS_URL"
+)
+
+// FromEnv returns cfg with the fields whose environment variable is set
+// replaced by its value.
+func FromEnv(cfg Config) Config {
+	if v := os.Getenv(DriverEnv); v != "" {
+		cfg.Driver = v
+	}
+	if v := os.Getenv(KafkaBrokersEnv); v != "" {
+		cfg.KafkaBrokers = v
+	}
+	if v := os.Getenv(NATSURLEnv); v != "" {
+		cfg.NATSURL = v
+	}
+	return cfg
+}
+
+// Open connects to the broker selected by cfg.Driver. It refuses Memory: a
+// service on its own would queue its messages where no other service can
+// read them. Code that runs producers and consumers in one process calls
+// NewMemory instead.
+func Open(cfg Config) (Broker, error) {
+	switch cfg.Driver {
+	case Kafka:
+		return NewKafka(cfg.KafkaBrokers), nil
+	case NATS:
+		return NewNATS(cfg.NATSURL)
+	case Memory:
+		return nil, errors.New("the memory broker only reaches consumers in the same process; use kafka or nats")
+	}
+	return nil, fmt.Errorf("unknown broker driver %q", cfg.Driver)
+}
@@ -0,0 +1,167 @@
+package broker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+func TestOpenRefusesMemory(t *testing.T) {
+	if _, err := Open(Config{Driver: Memory}); err == nil {
+		t.Fatal("Open accepted the memory driver")
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	t.Setenv(DriverEnv, NATS)
+	t.Setenv(NATSURLEnv, "nats://localhost:4222")
+	cfg := FromEnv(Config{Driver: Kafka, KafkaBrokers: "kafka:9093", NATSURL: "nats://nats:4222"})
+	want := Config{Driver: NATS, KafkaBrokers: "kafka:9093", NATSURL: "nats://localhost:4222"}
+	if cfg != want {
+		t.Fatalf("FromEnv = %+v, want %+v", cfg, want)
+	}
+}
+
+func TestMemoryResumesAfterCommit(t *testing.T) {
+	b := NewMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := b.Publish(ctx, Message{Topic: "t", Value: []byte("1")}, Message{Topic: "t", Value: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := b.Subscribe("t", "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := sub.Fetch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Commit(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	sub.Close()
+
+	// the group resumes after the committed message
+	sub, err = b.Subscribe("t", "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	next, err := sub.Fetch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(next.Value) != "2" {
+		t.Fatalf("fetched %s after the commit, want 2", next.Value)
+	}
+}
+
+func TestMemoryLagCountsUncommitted(t *testing.T) {
+	b := NewMemory()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sub, err := b.Subscribe("t", "g")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	for _, v := range []string{"1", "2", "3"} {
+		if err := b.Publish(ctx, Message{Topic: "t", Value: []byte(v)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// fetching without committing leaves the lag as it is
+	first, err := sub.Fetch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sub.Fetch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lags, err := sub.Lag(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []PartitionLag{{Topic: "t", Lag: 3}}; len(lags) != 1 || lags[0] != want[0] {
+		t.Fatalf("Lag = %+v, want %+v", lags, want)
+	}
+
+	if err := sub.Commit(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	lags, err = sub.Lag(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lags) != 1 || lags[0].Lag != 2 {
+		t.Fatalf("Lag after a commit = %+v, want 2", lags)
+	}
+}
+
+func TestKafkaWriterWaitsForReplicas(t *testing.T) {
+	b := NewKafka("kafka1:9092,kafka2:9092").(*kafkaBroker)
+	defer b.Close()
+	if b.writer.RequiredAcks != kafka.RequireAll {
+		t.Fatalf("RequiredAcks = %v, want RequireAll", b.writer.RequiredAcks)
+	}
+	if _, ok := b.writer.Balancer.(*kafka.Hash); !ok {
+		t.Fatalf("Balancer = %T, want messages to go to the partition of their key", b.writer.Balancer)
+	}
+}
+
+func TestCheckAlter(t *testing.T) {
+	current := TopicInfo{TopicConfig: TopicConfig{Topic: "t", NumPartitions: 3, ReplicationFactor: 2}}
+	for _, c := range []struct {
+		name string
+		cfg  TopicConfig
+		ok   bool
+	}{
+		{"unchanged", TopicConfig{Topic: "t", NumPartitions: 3, ReplicationFactor: 2}, true},
+		{"unset", TopicConfig{Topic: "t"}, true},
+		{"more partitions", TopicConfig{Topic: "t", NumPartitions: 6}, true},
+		{"fewer partitions", TopicConfig{Topic: "t", NumPartitions: 2}, false},
+		{"replication factor", TopicConfig{Topic: "t", ReplicationFactor: 3}, false},
+	} {
+		if err := checkAlter(c.cfg, current); (err == nil) != c.ok {
+			t.Errorf("%s: checkAlter = %v", c.name, err)
+		}
+	}
+}
+
+func TestKafkaConfigChanges(t *testing.T) {
+	current := TopicInfo{TopicConfig: TopicConfig{Topic: "t", Retention: 7 * 24 * time.Hour}}
+	for _, c := range []struct {
+		name string
+		cfg  TopicConfig
+		want []kafka.IncrementalAlterConfigsRequestConfig
+	}{
+		// what DescribeTopics returned, which may be the broker's defaults
+		{"unchanged", current.TopicConfig, nil},
+		{"retention", TopicConfig{Topic: "t", Retention: 24 * time.Hour}, []kafka.IncrementalAlterConfigsRequestConfig{
+			{Name: kafkaRetention, Value: "86400000", ConfigOperation: kafka.ConfigOperationSet},
+		}},
+		{"retention forever", TopicConfig{Topic: "t", Retention: -1}, []kafka.IncrementalAlterConfigsRequestConfig{
+			{Name: kafkaRetention, Value: "-1", ConfigOperation: kafka.ConfigOperationSet},
+		}},
+		{"retention default", TopicConfig{Topic: "t"}, []kafka.IncrementalAlterConfigsRequestConfig{
+			{Name: kafkaRetention, ConfigOperation: kafka.ConfigOperationDelete},
+		}},
+		{"compaction", TopicConfig{Topic: "t", Retention: 7 * 24 * time.Hour, Compact: true}, []kafka.IncrementalAlterConfigsRequestConfig{
+			{Name: kafkaCleanupPolicy, Value: "compact", ConfigOperation: kafka.ConfigOperationSet},
+		}},
+		{"both", TopicConfig{Topic: "t", Retention: time.Hour, Compact: true}, []kafka.IncrementalAlterConfigsRequestConfig{
+			{Name: kafkaRetention, Value: "3600000", ConfigOperation: kafka.ConfigOperationSet},
+			{Name: kafkaCleanupPolicy, Value: "compact", ConfigOperation: kafka.ConfigOperationSet},
+		}},
+	} {
+		if got := kafkaConfigChanges(c.cfg, current); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: kafkaConfigChanges = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+type kafkaBroker struct {
+	brokers []string
+	writer  *kafka.Writer
+	// admin sends the topic requests, to the controller where they need to be
+	admin *kafka.Client
+}
+
+// NewKafka returns a broker for the Kafka cluster at brokers, a comma separated
+// list of host:port. Messages go to the partition of their key.
+func NewKafka(brokers string) Broker {
+	addrs := strings.Split(brokers, ",")
+	return &kafkaBroker{
+		brokers: addrs,
+		writer: &kafka.Writer{
+			Addr:     kafka.TCP(addrs...),
+			Balancer: &kafka.Hash{},
+			// a message counts as published once every in-sync replica has
+			// it, so that a broker failing over doesn't lose it
+			RequiredAcks: kafka.RequireAll,
+			// Publish waits for its messages to be written, and the writer would
+			// otherwise hold a partial batch for up to a second
+			BatchTimeout: 5 * time.Millisecond,
+		},
+		admin: &kafka.Client{Addr: kafka.TCP(addrs...)},
+	}
+}
+
+func (b *kafkaBroker) Publish(ctx context.Context, msgs ...Message) error {
+	out := make([]kafka.Message, len(msgs))
+	for i, m := range msgs {
+		out[i] = kafka.Message{Topic: m.Topic, Key: m.Key, Value: m.Value, Time: m.Time}
+		for _, h := range m.Headers {
+			out[i].Headers = append(out[i].Headers, kafka.Header{Key: h.Key, Value: h.Value})
+		}
+	}
+	return b.writer.WriteMessages(ctx, out...)
+}
+
+func (b *kafkaBroker) Subscribe(topic string, group string) (Subscription, error) {
+	return &kafkaSubscription{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers: b.brokers,
+			GroupID: group,
+			Topic:   topic,
+		}),
+		admin: b.admin,
+		topic: topic,
+		group: group,
+	}, nil
+}
+
+// CreateTopics creates the topics through the controller of the cluster.
+func (b *kafkaBroker) CreateTopics(ctx context.Context, topics ...TopicConfig) error {
+	configs := make([]kafka.TopicConfig, len(topics))
+	for i, t := range topics {
+		configs[i] = kafka.TopicConfig{
+			Topic:             t.Topic,
+			NumPartitions:     orDefault(t.NumPartitions),
+			ReplicationFactor: orDefault(t.ReplicationFactor),
+		}
+		for _, c := range kafkaTopicConfig(t) {
+			if c.ConfigOperation == kafka.ConfigOperationSet {
+				configs[i].ConfigEntries = append(configs[i].ConfigEntries, kafka.ConfigEntry{ConfigName: c.Name, ConfigValue: c.Value})
+			}
+		}
+	}
+	res, err := b.admin.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: configs})
+	if err != nil {
+		return err
+	}
+	for topic, err := range res.Errors {
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("failed to create topic %s: %w", topic, err)
+		}
+	}
+	return nil
+}
+
+// DescribeTopics leaves out the internal topics of the cluster.
+func (b *kafkaBroker) DescribeTopics(ctx context.Context, topics ...string) ([]TopicInfo, error) {
+	// asking for the topics by name could create them on a cluster that
+	// creates topics automatically
+	meta, err := b.admin.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(topics))
+	for _, t := range topics {
+		wanted[t] = true
+	}
+
+	var infos []TopicInfo
+	var resources []kafka.DescribeConfigRequestResource
+	for _, t := range meta.Topics {
+		if t.Internal || (len(topics) > 0 && !wanted[t.Name]) {
+			continue
+		}
+		if t.Error != nil {
+			return nil, fmt.Errorf("failed to describe topic %s: %w", t.Name, t.Error)
+		}
+		info := TopicInfo{TopicConfig: TopicConfig{Topic: t.Name, NumPartitions: len(t.Partitions)}}
+		for _, p := range t.Partitions {
+			info.Partitions = append(info.Partitions, PartitionInfo{
+				ID:       p.ID,
+				Leader:   strconv.Itoa(p.Leader.ID),
+				Replicas: brokerIDs(p.Replicas),
+				InSync:   brokerIDs(p.Isr),
+			})
+			info.ReplicationFactor = len(p.Replicas)
+		}
+		sort.Slice(info.Partitions, func(i, j int) bool { return info.Partitions[i].ID < info.Partitions[j].ID })
+		infos = append(infos, info)
+		resources = append(resources, kafka.DescribeConfigRequestResource{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: t.Name,
+			ConfigNames:  []string{kafkaRetention, kafkaCleanupPolicy},
+		})
+	}
+	if len(infos) == 0 {
+		return nil, nil
+	}
+
+	res, err := b.admin.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, err
+	}
+	configs := make(map[string][]kafka.DescribeConfigResponseConfigEntry, len(res.Resources))
+	for _, r := range res.Resources {
+		if r.Error != nil {
+			return nil, fmt.Errorf("failed to describe topic %s: %w", r.ResourceName, r.Error)
+		}
+		configs[r.ResourceName] = r.ConfigEntries
+	}
+	for i := range infos {
+		for _, c := range configs[infos[i].Topic] {
+			switch c.ConfigName {
+			case kafkaRetention:
+				ms, _ := strconv.ParseInt(c.ConfigValue, 10, 64)
+				infos[i].Retention = time.Duration(ms) * time.Millisecond
+				if ms < 0 {
+					infos[i].Retention = -1
+				}
+			case kafkaCleanupPolicy:
+				infos[i].Compact = strings.Contains(c.ConfigValue, "compact")
+			}
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Topic < infos[j].Topic })
+	return infos, nil
+}
+
+func (b *kafkaBroker) AlterTopic(ctx context.Context, cfg TopicConfig) error {
+	infos, err := b.DescribeTopics(ctx, cfg.Topic)
+	if err != nil {
+		return err
+	}
+	if len(infos) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownTopic, cfg.Topic)
+	}
+	current := infos[0]
+	if err := checkAlter(cfg, current); err != nil {
+		return err
+	}
+
+	if cfg.NumPartitions > current.NumPartitions {
+		res, err := b.admin.CreatePartitions(ctx, &kafka.CreatePartitionsRequest{
+			Topics: []kafka.TopicPartitionsConfig{{Name: cfg.Topic, Count: int32(cfg.NumPartitions)}},
+		})
+		if err != nil {
+			return err
+		}
+		if err := res.Errors[cfg.Topic]; err != nil {
+			return fmt.Errorf("failed to add partitions to %s: %w", cfg.Topic, err)
+		}
+	}
+
+	configs := kafkaConfigChanges(cfg, current)
+	if len(configs) == 0 {
+		return nil
+	}
+	res, err := b.admin.IncrementalAlterConfigs(ctx, &kafka.IncrementalAlterConfigsRequest{
+		Resources: []kafka.IncrementalAlterConfigsRequestResource{{
+			ResourceType: kafka.ResourceTypeTopic,
+			ResourceName: cfg.Topic,
+			Configs:      configs,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range res.Resources {
+		if r.Error != nil {
+			return fmt.Errorf("failed to configure topic %s: %w", cfg.Topic, r.Error)
+		}
+	}
+	return nil
+}
+
+// checkAlter returns why a topic can't be brought from current to cfg, if it can't.
+func checkAlter(cfg TopicConfig, current TopicInfo) error {
+	if cfg.ReplicationFactor != 0 && cfg.ReplicationFactor != current.ReplicationFactor {
+		return fmt.Errorf("topic %s has replication factor %d; changing it takes a partition reassignment",
+			cfg.Topic, current.ReplicationFactor)
+	}
+	if cfg.NumPartitions != 0 && cfg.NumPartitions < current.NumPartitions {
+		return fmt.Errorf("topic %s has %d partitions, which can't be reduced to %d", cfg.Topic, current.NumPartitions, cfg.NumPartitions)
+	}
+	return nil
+}
+
+// kafkaConfigChanges returns the settings of cfg that differ from current.
+// DescribeTopics reports the effective settings, which may be the broker's
+// defaults; writing them back unchanged would make them the topic's own.
+func kafkaConfigChanges(cfg TopicConfig, current TopicInfo) []kafka.IncrementalAlterConfigsRequestConfig {
+	var configs []kafka.IncrementalAlterConfigsRequestConfig
+	for _, c := range kafkaTopicConfig(cfg) {
+		if (c.Name == kafkaRetention && cfg.Retention != current.Retention) ||
+			(c.Name == kafkaCleanupPolicy && cfg.Compact != current.Compact) {
+			configs = append(configs, c)
+		}
+	}
+	return configs
+}
+
+const (
+	kafkaRetention     = "retention.ms"
+	kafkaCleanupPolicy = "cleanup.policy"
+)
+
+// kafkaTopicConfig returns the topic settings of t. A retention of zero
+// deletes the topic's own setting, which makes it fall back to the broker's.
+func kafkaTopicConfig(t TopicConfig) []kafka.IncrementalAlterConfigsRequestConfig {
+	retention := kafka.IncrementalAlterConfigsRequestConfig{Name: kafkaRetention, ConfigOperation: kafka.ConfigOperationDelete}
+	if t.Retention < 0 {
+		retention.Value, retention.ConfigOperation = "-1", kafka.ConfigOperationSet
+	} else if t.Retention > 0 {
+		retention.Value, retention.ConfigOperation = strconv.FormatInt(t.Retention.Milliseconds(), 10), kafka.ConfigOperationSet
+	}
+	policy := kafka.IncrementalAlterConfigsRequestConfig{Name: kafkaCleanupPolicy, Value: "delete", ConfigOperation: kafka.ConfigOperationSet}
+	if t.Compact {
+		policy.Value = "compact"
+	}
+	return []kafka.IncrementalAlterConfigsRequestConfig{retention, policy}
+}
+
+// orDefault returns -1, which makes the cluster use its default, for zero.
+func orDefault(n int) int {
+	if n == 0 {
+		return -1
+	}
+	return n
+}
+
+func brokerIDs(brokers []kafka.Broker) []string {
+	ids := make([]string, len(brokers))
+	for i, b := range brokers {
+		ids[i] = strconv.Itoa(b.ID)
+	}
+	return ids
+}
+
+// Ping connects to the first of the brokers that answers.
+func (b *kafkaBroker) Ping(ctx context.Context) error {
+	var err error
+	for _, addr := range b.brokers {
+		var conn *kafka.Conn
+		if conn, err = kafka.DialContext(ctx, "tcp", addr); err == nil {
+			return conn.Close()
+		}
+	}
+	return err
+}
+
+func (b *kafkaBroker) Close() error {
+	return b.writer.Close()
+}
+
+type kafkaSubscription struct {
+	reader *kafka.Reader
+	// admin asks the cluster for the offsets of the group
+	admin *kafka.Client
+	topic string
+	group string
+}
+
+func (s *kafkaSubscription) Fetch(ctx context.Context) (Message, error) {
+	m, err := s.reader.FetchMessage(ctx)
+	if errors.Is(err, io.EOF) || errors.Is(err, kafka.ErrGroupClosed) {
+		return Message{}, ErrClosed
+	} else if err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		Topic:     m.Topic,
+		Key:       m.Key,
+		Value:     m.Value,
+		Time:      m.Time,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+	}
+	for _, h := range m.Headers {
+		msg.Headers = append(msg.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	return msg, nil
+}
+
+func (s *kafkaSubscription) Commit(ctx context.Context, msg Message) error {
+	return s.reader.CommitMessages(ctx, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+}
+
+// Lag compares the offsets the group committed with the end of each
+// partition. A partition the group never committed is counted from its first
+// message, where the reader starts.
+func (s *kafkaSubscription) Lag(ctx context.Context) ([]PartitionLag, error) {
+	meta, err := s.admin.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{s.topic}})
+	if err != nil {
+		return nil, err
+	}
+	var partitions []int
+	var requests []kafka.OffsetRequest
+	for _, t := range meta.Topics {
+		if t.Error != nil {
+			return nil, fmt.Errorf("failed to describe topic %s: %w", t.Name, t.Error)
+		}
+		for _, p := range t.Partitions {
+			partitions = append(partitions, p.ID)
+			requests = append(requests, kafka.FirstOffsetOf(p.ID), kafka.LastOffsetOf(p.ID))
+		}
+	}
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+
+	ends, err := s.admin.ListOffsets(ctx, &kafka.ListOffsetsRequest{Topics: map[string][]kafka.OffsetRequest{s.topic: requests}})
+	if err != nil {
+		return nil, err
+	}
+	committed, err := s.admin.OffsetFetch(ctx, &kafka.OffsetFetchRequest{GroupID: s.group, Topics: map[string][]int{s.topic: partitions}})
+	if err != nil {
+		return nil, err
+	}
+	if committed.Error != nil {
+		return nil, committed.Error
+	}
+	commits := make(map[int]int64, len(partitions))
+	for _, p := range committed.Topics[s.topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to fetch the offset of %s/%d: %w", s.topic, p.Partition, p.Error)
+		}
+		commits[p.Partition] = p.CommittedOffset
+	}
+
+	lags := make([]PartitionLag, 0, len(partitions))
+	for _, p := range ends.Topics[s.topic] {
+		if p.Error != nil {
+			return nil, fmt.Errorf("failed to list the offsets of %s/%d: %w", s.topic, p.Partition, p.Error)
+		}
+		// the committed offset is that of the next message to read, -1 when
+		// there is none
+		next, ok := commits[p.Partition]
+		if !ok || next < p.FirstOffset {
+			next = p.FirstOffset
+		}
+		lags = append(lags, PartitionLag{Topic: s.topic, Partition: p.Partition, Lag: max(p.LastOffset-next, 0)})
+	}
+	sort.Slice(lags, func(i, j int) bool { return lags[i].Partition < lags[j].Partition })
+	return lags, nil
+}
+
+func (s *kafkaSubscription) Close() error {
+	return s.reader.Close()
+}
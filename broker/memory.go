@@ -0,0 +1,226 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryBroker keeps each topic as a single partition in memory. A group reads
+// a topic from its first message; messages every group of a topic has
+// committed are dropped. The retention of a topic is remembered but not applied.
+type memoryBroker struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	config TopicConfig
+	// base is the offset of messages[0]
+	base     int64
+	messages []Message
+	groups   map[string]*memoryGroup
+	// arrived is closed and replaced whenever a message is published
+	arrived chan struct{}
+}
+
+type memoryGroup struct {
+	// next is the offset to deliver next and committed the first offset that
+	// isn't committed
+	next      int64
+	committed int64
+	members   int
+}
+
+// NewMemory returns an empty in-memory broker. Messages are lost when the
+// process exits.
+func NewMemory() Broker {
+	return &memoryBroker{topics: map[string]*memoryTopic{}}
+}
+
+func (b *memoryBroker) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &memoryTopic{
+			config:  memoryConfig(TopicConfig{Topic: name}),
+			groups:  map[string]*memoryGroup{},
+			arrived: make(chan struct{}),
+		}
+		b.topics[name] = t
+	}
+	return t
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, msgs ...Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range msgs {
+		t := b.topic(m.Topic)
+		m.Key = append([]byte(nil), m.Key...)
+		m.Value = append([]byte(nil), m.Value...)
+		m.Headers = append([]Header(nil), m.Headers...)
+		if m.Time.IsZero() {
+			m.Time = time.Now()
+		}
+		m.Partition, m.Offset = 0, t.base+int64(len(t.messages))
+		t.messages = append(t.messages, m)
+		close(t.arrived)
+		t.arrived = make(chan struct{})
+	}
+	return nil
+}
+
+// Subscribe starts the group over from its last commit when it has no other
+// members, as after a restart.
+func (b *memoryBroker) Subscribe(topic string, group string) (Subscription, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t := b.topic(topic)
+	g, ok := t.groups[group]
+	if !ok {
+		g = &memoryGroup{next: t.base, committed: t.base}
+		t.groups[group] = g
+	}
+	if g.members == 0 {
+		g.next = g.committed
+	}
+	g.members++
+	return &memorySubscription{broker: b, topic: t, group: g, closed: make(chan struct{})}, nil
+}
+
+func (b *memoryBroker) CreateTopics(ctx context.Context, topics ...TopicConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range topics {
+		if _, ok := b.topics[t.Topic]; !ok {
+			b.topic(t.Topic).config = memoryConfig(t)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) DescribeTopics(ctx context.Context, topics ...string) ([]TopicInfo, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(topics) == 0 {
+		for name := range b.topics {
+			topics = append(topics, name)
+		}
+		sort.Strings(topics)
+	}
+	var infos []TopicInfo
+	for _, name := range topics {
+		if t, ok := b.topics[name]; ok {
+			infos = append(infos, TopicInfo{TopicConfig: t.config, Partitions: []PartitionInfo{{ID: 0}}})
+		}
+	}
+	return infos, nil
+}
+
+func (b *memoryBroker) AlterTopic(ctx context.Context, cfg TopicConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.topics[cfg.Topic]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownTopic, cfg.Topic)
+	}
+	t.config = memoryConfig(cfg)
+	return nil
+}
+
+// memoryConfig drops the settings of t the in-memory broker doesn't have. It
+// keeps a single copy of each topic.
+func memoryConfig(t TopicConfig) TopicConfig {
+	t.NumPartitions, t.ReplicationFactor, t.Compact = 0, 1, false
+	return t
+}
+
+func (b *memoryBroker) Ping(ctx context.Context) error {
+	return nil
+}
+
+// Close does nothing; the messages are kept for the other users of the broker.
+func (b *memoryBroker) Close() error {
+	return nil
+}
+
+type memorySubscription struct {
+	broker    *memoryBroker
+	topic     *memoryTopic
+	group     *memoryGroup
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (s *memorySubscription) Fetch(ctx context.Context) (Message, error) {
+	for {
+		s.broker.mu.Lock()
+		select {
+		case <-s.closed:
+			s.broker.mu.Unlock()
+			return Message{}, ErrClosed
+		default:
+		}
+		t, g := s.topic, s.group
+		if g.next < t.base+int64(len(t.messages)) {
+			m := t.messages[g.next-t.base]
+			g.next++
+			s.broker.mu.Unlock()
+			return m, nil
+		}
+		arrived := t.arrived
+		s.broker.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-s.closed:
+			return Message{}, ErrClosed
+		case <-arrived:
+		}
+	}
+}
+
+func (s *memorySubscription) Commit(ctx context.Context, msg Message) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if msg.Offset+1 > s.group.committed {
+		s.group.committed = msg.Offset + 1
+	}
+
+	t := s.topic
+	low := t.base + int64(len(t.messages))
+	for _, g := range t.groups {
+		if g.committed < low {
+			low = g.committed
+		}
+	}
+	if n := low - t.base; n > 0 {
+		clear(t.messages[:n])
+		t.messages = t.messages[n:]
+		t.base = low
+	}
+	return nil
+}
+
+func (s *memorySubscription) Lag(ctx context.Context) ([]PartitionLag, error) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	lag := s.topic.base + int64(len(s.topic.messages)) - s.group.committed
+	return []PartitionLag{{Topic: s.topic.config.Topic, Lag: lag}}, nil
+}
+
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		s.broker.mu.Lock()
+		s.group.members--
+		s.broker.mu.Unlock()
+		close(s.closed)
+	})
+	return nil
+}
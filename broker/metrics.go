@@ -0,0 +1,39 @@
+package broker
+
+import (
+	"errors"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// RegisterAsyncMetrics exports the counters of an AsyncPublisher on the default
+// registry, labelled with the service.
+func RegisterAsyncMetrics(p *AsyncPublisher, service string) error {
+	labels := prometheus.Labels{"service": service}
+	counter := func(name string, help string, value func(AsyncStats) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help, ConstLabels: labels},
+			func() float64 { return float64(value(p.Stats())) })
+	}
+	collectors := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "producer_queue_depth",
+			Help:        "Messages queued by the producer and not yet written to the broker.",
+			ConstLabels: labels,
+		}, func() float64 { return float64(p.Stats().Queued) }),
+		counter("producer_messages_sent_total", "Messages written to the broker.",
+			func(s AsyncStats) uint64 { return s.Sent }),
+		counter("producer_messages_failed_total", "Messages the broker failed to write.",
+			func(s AsyncStats) uint64 { return s.Failed }),
+		counter("producer_messages_dropped_total", "Messages dropped because the producer queue was full.",
+			func(s AsyncStats) uint64 { return s.Dropped }),
+		counter("producer_batches_total", "Batches written to the broker.",
+			func(s AsyncStats) uint64 { return s.Batches }),
+	}
+	var errs []error
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
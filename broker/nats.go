@@ -0,0 +1,382 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+const (
+	// natsKeyHeader carries the message key, which NATS has no field for
+	natsKeyHeader = "x-broker-key"
+	// natsAckWait is how long a message can go unacknowledged before it is
+	// delivered again. Fetched messages are kept in progress until they are
+	// committed, so this only matters once their subscriber is gone.
+	natsAckWait = 5 * time.Minute
+	// natsRetention matches the default retention of Kafka topics
+	natsRetention = 7 * 24 * time.Hour
+	// natsRetryMin and natsRetryMax bound the wait after a failed fetch
+	natsRetryMin = 100 * time.Millisecond
+	natsRetryMax = 5 * time.Second
+)
+
+// natsBroker keeps each topic in a JetStream stream with the topic as its
+// subject. A group is a durable consumer of the stream. A stream has a single
+// partition whose offsets are stream sequences, so messages are delivered in
+// the order they were published, but a group with several members may handle
+// messages with the same key on different members.
+type natsBroker struct {
+	conn *nats.Conn
+	js   jetstream.JetStream
+
+	mu      sync.Mutex
+	streams map[string]string
+}
+
+// NewNATS connects to the NATS server at url, which must have JetStream
+// enabled. Streams are created as topics are first used.
+func NewNATS(url string) (Broker, error) {
+	conn, err := nats.Connect(url, nats.RetryOnFailedConnect(true), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsBroker{conn: conn, js: js, streams: map[string]string{}}, nil
+}
+
+// stream creates the stream of a topic with the default settings unless it
+// exists and returns its name.
+func (b *natsBroker) stream(ctx context.Context, topic string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if name, ok := b.streams[topic]; ok {
+		return name, nil
+	}
+	if err := b.createStream(ctx, TopicConfig{Topic: topic}); err != nil {
+		return "", err
+	}
+	return b.streams[topic], nil
+}
+
+// createStream creates the stream of t unless it exists. b.mu must be held.
+func (b *natsBroker) createStream(ctx context.Context, t TopicConfig) error {
+	cfg := natsStreamConfig(t)
+	if _, err := b.js.Stream(ctx, cfg.Name); errors.Is(err, jetstream.ErrStreamNotFound) {
+		_, err = b.js.CreateStream(ctx, cfg)
+		// another service may have created it in the meantime
+		if err != nil && !errors.Is(err, jetstream.ErrStreamNameAlreadyInUse) {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	b.streams[t.Topic] = cfg.Name
+	return nil
+}
+
+// streamName returns the stream of a topic. Stream names can't contain the
+// subject separators.
+func streamName(topic string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(topic)
+}
+
+func natsStreamConfig(t TopicConfig) jetstream.StreamConfig {
+	cfg := jetstream.StreamConfig{
+		Name:     streamName(t.Topic),
+		Subjects: []string{t.Topic},
+		MaxAge:   natsRetention,
+		Replicas: t.ReplicationFactor,
+	}
+	if t.Retention > 0 {
+		cfg.MaxAge = t.Retention
+	} else if t.Retention < 0 {
+		cfg.MaxAge = 0
+	}
+	return cfg
+}
+
+func (b *natsBroker) Publish(ctx context.Context, msgs ...Message) error {
+	for _, m := range msgs {
+		if _, err := b.stream(ctx, m.Topic); err != nil {
+			return err
+		}
+		out := &nats.Msg{Subject: m.Topic, Data: m.Value, Header: nats.Header{}}
+		if len(m.Key) > 0 {
+			out.Header.Set(natsKeyHeader, string(m.Key))
+		}
+		for _, h := range m.Headers {
+			out.Header.Add(h.Key, string(h.Value))
+		}
+		if _, err := b.js.PublishMsg(ctx, out); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *natsBroker) Subscribe(topic string, group string) (Subscription, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream, err := b.stream(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := b.js.CreateOrUpdateConsumer(ctx, stream, jetstream.ConsumerConfig{
+		Durable:   group,
+		AckPolicy: jetstream.AckExplicitPolicy,
+		AckWait:   natsAckWait,
+	})
+	if err != nil {
+		return nil, err
+	}
+	iter, err := consumer.Messages()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &natsSubscription{
+		topic:    topic,
+		consumer: consumer,
+		iter:     iter,
+		msgs:     make(chan jetstream.Msg),
+		pending:  map[uint64]jetstream.Msg{},
+		closed:   make(chan struct{}),
+	}
+	go s.receive()
+	go s.keepAlive()
+	return s, nil
+}
+
+func (b *natsBroker) CreateTopics(ctx context.Context, topics ...TopicConfig) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range topics {
+		if err := b.createStream(ctx, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DescribeTopics describes the streams that have a single subject, which the
+// topics of this package do.
+func (b *natsBroker) DescribeTopics(ctx context.Context, topics ...string) ([]TopicInfo, error) {
+	wanted := make(map[string]bool, len(topics))
+	for _, t := range topics {
+		wanted[t] = true
+	}
+	var infos []TopicInfo
+	streams := b.js.ListStreams(ctx)
+	for info := range streams.Info() {
+		if len(info.Config.Subjects) != 1 || (len(topics) > 0 && !wanted[info.Config.Subjects[0]]) {
+			continue
+		}
+		infos = append(infos, natsTopicInfo(info))
+	}
+	if err := streams.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Topic < infos[j].Topic })
+	return infos, nil
+}
+
+func natsTopicInfo(info *jetstream.StreamInfo) TopicInfo {
+	t := TopicInfo{
+		TopicConfig: TopicConfig{
+			Topic:             info.Config.Subjects[0],
+			ReplicationFactor: max(info.Config.Replicas, 1),
+			Retention:         info.Config.MaxAge,
+		},
+		Partitions: []PartitionInfo{{ID: 0}},
+	}
+	if t.Retention == 0 {
+		t.Retention = -1
+	}
+	if c := info.Cluster; c != nil && c.Leader != "" {
+		p := &t.Partitions[0]
+		p.Leader, p.Replicas, p.InSync = c.Leader, []string{c.Leader}, []string{c.Leader}
+		for _, peer := range c.Replicas {
+			p.Replicas = append(p.Replicas, peer.Name)
+			if peer.Current {
+				p.InSync = append(p.InSync, peer.Name)
+			}
+		}
+	}
+	return t
+}
+
+// AlterTopic changes the retention and replicas of the topic's stream.
+func (b *natsBroker) AlterTopic(ctx context.Context, cfg TopicConfig) error {
+	stream, err := b.js.Stream(ctx, streamName(cfg.Topic))
+	if errors.Is(err, jetstream.ErrStreamNotFound) {
+		return fmt.Errorf("%w: %s", ErrUnknownTopic, cfg.Topic)
+	} else if err != nil {
+		return err
+	}
+	streamCfg := stream.CachedInfo().Config
+	streamCfg.MaxAge = natsStreamConfig(cfg).MaxAge
+	if cfg.ReplicationFactor != 0 {
+		streamCfg.Replicas = cfg.ReplicationFactor
+	}
+	_, err = b.js.UpdateStream(ctx, streamCfg)
+	return err
+}
+
+// Ping checks that the connection is up and JetStream answers.
+func (b *natsBroker) Ping(ctx context.Context) error {
+	if status := b.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection %s", status)
+	}
+	_, err := b.js.AccountInfo(ctx)
+	return err
+}
+
+func (b *natsBroker) Close() error {
+	b.conn.Close()
+	return nil
+}
+
+type natsSubscription struct {
+	topic    string
+	consumer jetstream.Consumer
+	iter     jetstream.MessagesContext
+	msgs     chan jetstream.Msg
+
+	mu sync.Mutex
+	// pending holds the fetched messages that aren't committed, by stream sequence
+	pending map[uint64]jetstream.Msg
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// receive hands the messages of the iterator to Fetch, which can give up
+// waiting when its context is done. While the iterator fails, say because the
+// server is down, it waits between tries from natsRetryMin doubling up to
+// natsRetryMax.
+func (s *natsSubscription) receive() {
+	var wait time.Duration
+	for {
+		m, err := s.iter.Next()
+		if errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+			return
+		} else if err != nil {
+			wait = min(max(2*wait, natsRetryMin), natsRetryMax)
+			select {
+			case <-time.After(wait):
+			case <-s.closed:
+				return
+			}
+			continue
+		}
+		wait = 0
+		select {
+		case s.msgs <- m:
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// keepAlive stops the pending messages from being delivered again while they
+// are being handled.
+func (s *natsSubscription) keepAlive() {
+	ticker := time.NewTicker(natsAckWait / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			for _, m := range s.pending {
+				m.InProgress()
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *natsSubscription) Fetch(ctx context.Context) (Message, error) {
+	var m jetstream.Msg
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case <-s.closed:
+		return Message{}, ErrClosed
+	case m = <-s.msgs:
+	}
+
+	meta, err := m.Metadata()
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{
+		Topic:  s.topic,
+		Key:    []byte(m.Headers().Get(natsKeyHeader)),
+		Value:  m.Data(),
+		Time:   meta.Timestamp,
+		Offset: int64(meta.Sequence.Stream),
+	}
+	for key, values := range m.Headers() {
+		if key == natsKeyHeader {
+			continue
+		}
+		for _, v := range values {
+			msg.Headers = append(msg.Headers, Header{Key: key, Value: []byte(v)})
+		}
+	}
+
+	s.mu.Lock()
+	s.pending[meta.Sequence.Stream] = m
+	s.mu.Unlock()
+	return msg, nil
+}
+
+// Commit acknowledges msg and every pending message before it.
+func (s *natsSubscription) Commit(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var firstErr error
+	for seq, m := range s.pending {
+		if seq > uint64(msg.Offset) {
+			continue
+		}
+		if err := m.Ack(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(s.pending, seq)
+	}
+	return firstErr
+}
+
+// Lag counts the messages the durable consumer hasn't delivered and those it
+// delivered that aren't acknowledged.
+func (s *natsSubscription) Lag(ctx context.Context) ([]PartitionLag, error) {
+	info, err := s.consumer.Info(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return []PartitionLag{{Topic: s.topic, Lag: int64(info.NumPending) + int64(info.NumAckPending)}}, nil
+}
+
+// Close leaves the pending messages unacknowledged, so they are delivered
+// again after natsAckWait.
+func (s *natsSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+		s.iter.Stop()
+	})
+	return nil
+}
@@ -0,0 +1,24 @@
+package events
+
+import "errors"
+
+// ChatMessageVersion is the current version of ChatMessage.
+const ChatMessageVersion = 1
+
+// ChatMessage is a message from Sender to Recipients, delivered by workerservice
+// through NotificationService.PushMessage.
+type ChatMessage struct {
+	Sender     string   `json:"sender"`
+	Recipients []string `json:"recipients"`
+	Message    string   `json:"message"`
+}
+
+func (ChatMessage) EventType() string  { return TypeChatMessage }
+func (ChatMessage) SchemaVersion() int { return ChatMessageVersion }
+
+func (m ChatMessage) Validate() error {
+	if m.Sender == "" {
+		return errors.New("sender is required")
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// EmailVersion is the current version of Email.
+//
+// Version 1 named the fields userID and emailID, and an email without a type
+// was a registration email. Version 2 renamed them and requires the type.
+const EmailVersion = 2
+
+const (
+	EmailRegistration  = "registration"
+	EmailPasswordReset = "password_reset"
+	EmailVerification  = "email_verification"
+)
+
+// Email asks workerservice to send an email. Password reset and verification
+// emails carry the token to put in the link.
+type Email struct {
+	Type   string `json:"type"`
+	UserID string `json:"userId"`
+	To     string `json:"to"`
+	Token  string `json:"token,omitempty"`
+}
+
+func (Email) EventType() string  { return TypeEmail }
+func (Email) SchemaVersion() int { return EmailVersion }
+
+func (e Email) Validate() error {
+	switch e.Type {
+	case EmailRegistration:
+	case EmailPasswordReset, EmailVerification:
+		if e.Token == "" {
+			return errors.New("token is required")
+		}
+	default:
+		return fmt.Errorf("unknown email type %q", e.Type)
+	}
+	if e.To == "" {
+		return errors.New("to is required")
+	}
+	return nil
+}
+
+type emailV1 struct {
+	Type    string `json:"type"`
+	UserID  string `json:"userID"`
+	EmailID string `json:"emailID"`
+	Token   string `json:"token,omitempty"`
+}
+
+func upgradeEmailV1(data []byte) ([]byte, error) {
+	var v1 emailV1
+	if err := json.Unmarshal(data, &v1); err != nil {
+		return nil, err
+	}
+	if v1.Type == "" {
+		v1.Type = EmailRegistration
+	}
+	return json.Marshal(Email{Type: v1.Type, UserID: v1.UserID, To: v1.EmailID, Token: v1.Token})
+}
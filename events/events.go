@@ -0,0 +1,142 @@
+// Package events defines the payloads the services exchange over Kafka. It is
+// shared by userservice, notificationservice and workerservice through a
+// replace directive in their go.mod files.
+//
+// Producers put the event type and schema version of every message in the
+// x-event-type and x-schema-version headers. Consumers pass them to Upgrade,
+// which turns older versions into the current one and rejects versions it
+// doesn't know. To change a payload incompatibly, bump its version, keep the
+// old struct and add a step that upgrades it.
+package events
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Kafka message headers.
+const (
+	// HeaderEventID is unique per event; workerservice uses it to skip
+	// messages it has already handled. Sending an event again with the same
+	// ID, as IdempotentEventID gives, is a no-op there.
+	HeaderEventID = "x-event-id"
+	HeaderType    = "x-event-type"
+	HeaderVersion = "x-schema-version"
+)
+
+const (
+	TypeEmail       = "email"
+	TypeLog         = "log"
+	TypeChatMessage = "chat_message"
+	TypeAudit       = "audit"
+	TypeUser        = "user"
+)
+
+// NewEventID returns a random event ID, for events without an idempotency key.
+func NewEventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// IdempotentEventID returns the event ID for a caller's idempotency key. The
+// scope, such as the sender, keeps the keys of different callers apart.
+func IdempotentEventID(scope string, key string) string {
+	sum := sha256.Sum256([]byte(scope + "\x00" + key))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Event is implemented by every payload type.
+type Event interface {
+	EventType() string
+	SchemaVersion() int
+}
+
+var (
+	ErrUnknownType        = errors.New("unknown event type")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+type schema struct {
+	version int
+	// upgrades[v] turns version v into version v+1
+	upgrades map[int]func([]byte) ([]byte, error)
+	validate func([]byte) error
+}
+
+var schemas = map[string]schema{
+	TypeEmail: {
+		version:  EmailVersion,
+		upgrades: map[int]func([]byte) ([]byte, error){1: upgradeEmailV1},
+		validate: validator[Email](),
+	},
+	TypeLog: {
+		version:  LogVersion,
+		validate: validator[Log](),
+	},
+	TypeChatMessage: {
+		version:  ChatMessageVersion,
+		validate: validator[ChatMessage](),
+	},
+	TypeAudit: {
+		version:  AuditVersion,
+		validate: validator[Audit](),
+	},
+	TypeUser: {
+		version:  UserEventVersion,
+		validate: validator[UserEvent](),
+	},
+}
+
+// Upgrade returns the payload in the current version of its type and checks
+// it. Version 0 stands for a message without a version header, which predates
+// versioning and is version 1.
+func Upgrade(eventType string, version int, data []byte) ([]byte, error) {
+	s, ok := schemas[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, eventType)
+	}
+	if version == 0 {
+		version = 1
+	}
+	if version < 1 || version > s.version {
+		return nil, fmt.Errorf("%w %d of %s, the newest known is %d", ErrUnsupportedVersion, version, eventType, s.version)
+	}
+
+	for ; version < s.version; version++ {
+		upgrade, ok := s.upgrades[version]
+		if !ok {
+			return nil, fmt.Errorf("%w %d of %s, it can't be upgraded", ErrUnsupportedVersion, version, eventType)
+		}
+		var err error
+		if data, err = upgrade(data); err != nil {
+			return nil, fmt.Errorf("failed to upgrade %s from version %d: %w", eventType, version, err)
+		}
+	}
+	if err := s.validate(data); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", eventType, err)
+	}
+	return data, nil
+}
+
+// CurrentVersion returns the version producers write for the event type, or 0
+// for an unknown type.
+func CurrentVersion(eventType string) int {
+	return schemas[eventType].version
+}
+
+func validator[T interface{ Validate() error }]() func([]byte) error {
+	return func(data []byte) error {
+		var event T
+		if err := json.Unmarshal(data, &event); err != nil {
+			return err
+		}
+		return event.Validate()
+	}
+}
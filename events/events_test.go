@@ -0,0 +1,100 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestUpgradeEmailV1(t *testing.T) {
+	// version 1 without a type was a registration email
+	data, err := Upgrade(TypeEmail, 1, []byte(`{"userID":"u1","emailID":"a@b.c"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var email Email
+	if err := json.Unmarshal(data, &email); err != nil {
+		t.Fatal(err)
+	}
+	want := Email{Type: EmailRegistration, UserID: "u1", To: "a@b.c"}
+	if email != want {
+		t.Fatalf("upgraded email = %+v, want %+v", email, want)
+	}
+
+	// no version header means version 1
+	if _, err := Upgrade(TypeEmail, 0, []byte(`{"type":"password_reset","userID":"u1","emailID":"a@b.c","token":"t"}`)); err != nil {
+		t.Fatalf("unversioned email: %v", err)
+	}
+}
+
+func TestUpgradeCurrentVersion(t *testing.T) {
+	payload := []byte(`{"type":"registration","userId":"u1","to":"a@b.c"}`)
+	data, err := Upgrade(TypeEmail, EmailVersion, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(payload) {
+		t.Fatalf("current version changed: %s", data)
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		version   int
+		data      string
+		want      error
+	}{
+		{"unknown type", "nope", 1, `{}`, ErrUnknownType},
+		{"newer version", TypeEmail, EmailVersion + 1, `{}`, ErrUnsupportedVersion},
+		{"negative version", TypeLog, -1, `{}`, ErrUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		if _, err := Upgrade(tt.eventType, tt.version, []byte(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	invalid := map[string]string{
+		"email without token":   `{"type":"password_reset","userId":"u1","to":"a@b.c"}`,
+		"email of unknown type": `{"type":"newsletter","userId":"u1","to":"a@b.c"}`,
+		"malformed email":       `{"type":`,
+	}
+	for name, data := range invalid {
+		if _, err := Upgrade(TypeEmail, EmailVersion, []byte(data)); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, err := Upgrade(TypeLog, LogVersion, []byte(`{"userId":"u1"}`)); err == nil {
+		t.Error("log without message: accepted")
+	}
+	if _, err := Upgrade(TypeUser, UserEventVersion, []byte(`{"type":"user.deleted"}`)); err == nil {
+		t.Error("user event without user: accepted")
+	}
+}
+
+func TestUserEventBeforeHeaders(t *testing.T) {
+	// userservice sent user events as a map, with a time in whole seconds and
+	// without the type and version headers
+	data, err := Upgrade(TypeUser, 0, []byte(`{"type":"user.registered","userId":"u1","time":"2026-01-02T03:04:05Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event UserEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != UserRegistered || event.UserID != "u1" || event.Time.IsZero() {
+		t.Fatalf("user event = %+v", event)
+	}
+}
+
+func TestCurrentVersion(t *testing.T) {
+	if v := CurrentVersion(TypeEmail); v != EmailVersion {
+		t.Fatalf("CurrentVersion(email) = %d", v)
+	}
+	if v := CurrentVersion("nope"); v != 0 {
+		t.Fatalf("CurrentVersion(unknown) = %d", v)
+	}
+}
@@ -0,0 +1,29 @@
+package events
+
+import (
+	"errors"
+	"time"
+)
+
+// LogVersion is the current version of Log.
+const LogVersion = 1
+
+// Log is an entry for the logs table. Fields holds anything else worth
+// keeping, such as a remote address.
+type Log struct {
+	Message string            `json:"message"`
+	UserID  string            `json:"userId,omitempty"`
+	Error   string            `json:"error,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+	Time    time.Time         `json:"time"`
+}
+
+func (Log) EventType() string  { return TypeLog }
+func (Log) SchemaVersion() int { return LogVersion }
+
+func (l Log) Validate() error {
+	if l.Message == "" {
+		return errors.New("message is required")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package events
+
+import (
+	"errors"
+	"time"
+)
+
+// UserEventVersion is the current version of UserEvent.
+const UserEventVersion = 1
+
+// User event types. Consumers skip types they don't handle, so new ones can be
+// added without changing them.
+const (
+	UserRegistered = "user.registered"
+	UserLoggedOut  = "user.logged_out"
+	UserSuspended  = "user.suspended"
+	UserDeleted    = "user.deleted"
+)
+
+// UserEvent announces a change to an account on the users topic. workerservice
+// forwards it to webhooks and updates the user's schedules, and
+// notificationservice closes the connections of suspended and deleted users.
+type UserEvent struct {
+	Type   string    `json:"type"`
+	UserID string    `json:"userId"`
+	Time   time.Time `json:"time"`
+}
+
+// NewUserEvent returns an event of the type that happened to the user now.
+func NewUserEvent(eventType string, userID string) UserEvent {
+	return UserEvent{Type: eventType, UserID: userID, Time: time.Now().UTC()}
+}
+
+func (UserEvent) EventType() string  { return TypeUser }
+func (UserEvent) SchemaVersion() int { return UserEventVersion }
+
+func (e UserEvent) Validate() error {
+	if e.Type == "" {
+		return errors.New("type is required")
+	}
+	if e.UserID == "" {
+		return errors.New("userId is required")
+	}
+	return nil
+}
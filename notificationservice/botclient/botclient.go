@@ -0,0 +1,110 @@
+// Package botclient is a client for the streaming bot API of notificationservice.
+//
+//	bot, err := botclient.Dial("localhost:50054", "cak_...")
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer bot.Close()
+//	err = bot.Subscribe(ctx, func(msg botclient.Message) {
+//		bot.Reply(ctx, msg, "you said: "+msg.Text)
+//	})
+//
+// The API key needs the messages:send scope.
+package botclient
+
+import (
+	"context"
+	"time"
+
+	pb "notificationservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Message is a chat message delivered to the bot.
+type Message struct {
+	Sender     string
+	Recipients []string
+	Text       string
+	Time       time.Time
+	// Bot is the principal the message was delivered to, i.e. this bot
+	Bot string
+}
+
+type Client struct {
+	conn   *grpc.ClientConn
+	client pb.BotServiceClient
+}
+
+// Dial connects to the bot API and sends apiKey with every call.
+func Dial(address string, apiKey string) (*Client, error) {
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(apiKeyCredentials(apiKey)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, client: pb.NewBotServiceClient(conn)}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Subscribe calls handle for every message sent to the bot, one at a time, until
+// ctx is canceled or the stream breaks. It returns nil when ctx is canceled.
+func (c *Client) Subscribe(ctx context.Context, handle func(Message)) error {
+	stream, err := c.client.Subscribe(ctx, &pb.SubscribeRequest{})
+	if err != nil {
+		return err
+	}
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		at, _ := time.Parse(time.RFC3339, event.GetTime())
+		handle(Message{
+			Sender:     event.GetSender(),
+			Recipients: event.GetRecipients(),
+			Text:       event.GetMessage(),
+			Time:       at,
+			Bot:        event.GetBot(),
+		})
+	}
+}
+
+// Send posts text to the recipients as the bot.
+func (c *Client) Send(ctx context.Context, recipients []string, text string) error {
+	_, err := c.client.SendMessage(ctx, &pb.BotMessage{Recipients: recipients, Message: text})
+	return err
+}
+
+// Reply posts text to the conversation msg belongs to: its sender and the other
+// recipients.
+func (c *Client) Reply(ctx context.Context, msg Message, text string) error {
+	recipients := []string{msg.Sender}
+	for _, r := range msg.Recipients {
+		if r != msg.Bot && r != msg.Sender {
+			recipients = append(recipients, r)
+		}
+	}
+	return c.Send(ctx, recipients, text)
+}
+
+// apiKeyCredentials sends the key as a bearer token. It is allowed without TLS
+// so that the client works against the plaintext server in docker-compose.
+type apiKeyCredentials string
+
+func (k apiKeyCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(k)}, nil
+}
+
+func (k apiKeyCredentials) RequireTransportSecurity() bool {
+	return false
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net"
 	"notificationservice/config"
 	"notificationservice/grpc"
+	"notificationservice/grpcclient"
+	"notificationservice/internal/bot"
+	"notificationservice/internal/commands"
 	"notificationservice/internal/kafka"
 	"notificationservice/internal/routes"
 	"notificationservice/internal/websocket"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gofiber/fiber/v2"
 	"go.uber.org/zap"
@@ -19,15 +26,50 @@ func main() {
 		log.Fatalf("Can't initialize zap logger: %v", err)
 	}
 	defer logger.Sync()
-	producer := kafka.NewProducer(logger)
-	defer producer.Close()
+	msgBroker, err := kafka.Connect()
+	if err != nil {
+		logger.Fatal("Failed to connect to the message broker", zap.Error(err))
+	}
+	producer := kafka.NewProducer(msgBroker, logger)
 
 	app := fiber.New()
 
-	manager := websocket.NewWebSocketManager(logger, producer, 8)
+	keyClient, err := grpcclient.NewClient(config.UserServiceGRPCAddress)
+	if err != nil {
+		logger.Fatal("Failed to create gRPC client", zap.Error(err))
+	}
+	defer keyClient.Close()
+
+	scheduleClient, err := grpcclient.NewScheduleClient(config.WorkerGRPCAddress)
+	if err != nil {
+		logger.Fatal("Failed to create schedule gRPC client", zap.Error(err))
+	}
+	defer scheduleClient.Close()
+
+	commandStore, err := commands.NewStore(config.CommandsDatabasePath)
+	if err != nil {
+		logger.Fatal("Failed to open commands database", zap.Error(err))
+	}
+	defer commandStore.Close()
+	dispatcher, err := commands.NewDispatcher(keyClient, scheduleClient, commandStore, logger)
+	if err != nil {
+		logger.Fatal("Failed to load command state", zap.Error(err))
+	}
+
+	manager := websocket.NewWebSocketManager(logger, producer, keyClient, dispatcher, 8)
 	manager.StartWorkerPool()
 
-	routes.Setup(app, manager)
+	routes.Setup(app, manager, bot.NewHandler(manager, producer, keyClient, logger))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	userEventsDone := make(chan struct{})
+	go func() {
+		defer close(userEventsDone)
+		if err := kafka.WatchUserEvents(ctx, msgBroker, logger, manager.HandleUserEvent); err != nil {
+			logger.Error("Failed to watch user events", zap.Error(err))
+		}
+	}()
 
 	go func() {
 		logger.Info("Starting HTTP server on port 3001")
@@ -36,13 +78,45 @@ func main() {
 		}
 	}()
 
+	botLis, err := net.Listen("tcp", config.BotGRPCAddress)
+	if err != nil {
+		logger.Fatal("Failed to listen on port "+config.BotGRPCAddress, zap.Error(err))
+	}
+	botServer := grpc.NewBotServer(manager, producer, logger)
+	go func() {
+		logger.Info("Starting bot gRPC server on port " + config.BotGRPCAddress)
+		if err := botServer.Serve(botLis); err != nil {
+			logger.Fatal("Failed to start bot gRPC server", zap.Error(err))
+		}
+	}()
+
 	lis, err := net.Listen("tcp", config.GRPCAddress)
 	if err != nil {
 		logger.Fatal("Failed to listen on port "+config.GRPCAddress, zap.Error(err))
 	}
 	grpcServer := grpc.NewGRPCServer(manager, logger)
-	logger.Info("Starting gRPC server on port " + config.GRPCAddress)
-	if err := grpcServer.Serve(lis); err != nil {
-		logger.Fatal("Failed to start gRPC server", zap.Error(err))
+	go func() {
+		logger.Info("Starting gRPC server on port " + config.GRPCAddress)
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Fatal("Failed to start gRPC server", zap.Error(err))
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	// stop taking messages first so that nothing is queued after the flush
+	logger.Info("Shutting down...")
+	if err := app.ShutdownWithTimeout(config.ShutdownTimeout); err != nil {
+		logger.Error("Failed to shut down HTTP server", zap.Error(err))
+	}
+	// bot subscriptions never end on their own
+	botServer.Stop()
+	grpcServer.GracefulStop()
+	cancel()
+	<-userEventsDone
+	if err := producer.Close(); err != nil {
+		logger.Error("Failed to close producer", zap.Error(err))
 	}
 }
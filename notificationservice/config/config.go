@@ -1,11 +1,79 @@
 package config
 
+import "time"
+
 const HTTPPort = ":3001"
 const JWTSecret = "secret-key"
 const GRPCAddress = ":50051"
+
+// BrokerDriver selects the message broker: "kafka" uses KafkaBrokers and "nats" uses
+// NATSURL. The BROKER_DRIVER, KAFKA_BROKERS and NATS_URL environment variables
+// override them.
+const BrokerDriver = "kafka"
 const KafkaBrokers = "kafka:9093"
+const NATSURL = "nats://nats:4222"
+
+// The producer queues the messages of ProducerAsyncTopics and writes them in
+// batches in the background, so a send doesn't see write failures. A send waits
+// up to ProducerBlockTimeout for room in a full queue and then drops the
+// message. Queued messages are flushed on shutdown for up to
+// ProducerFlushTimeout. Messages of other topics are written before the send
+// returns, so that a failed email, audit event or chat message is reported.
+var ProducerAsyncTopics = []string{LogsTopic}
+
+const ProducerQueueSize = 10000
+const ProducerBatchSize = 100
+const ProducerBatchTimeout = 10 * time.Millisecond
+const ProducerWriteTimeout = 10 * time.Second
+const ProducerBlockTimeout = 100 * time.Millisecond
+const ProducerFlushTimeout = 10 * time.Second
+
+// ShutdownTimeout is how long open HTTP connections get to finish on shutdown
+const ShutdownTimeout = 10 * time.Second
+
 const EmailTopic = "email"
 const LogsTopic = "logs"
 const MessageTopic = "message"
+const AuditTopic = "audit"
+
+// UserEventsTopic carries account lifecycle events; the connections of
+// suspended and deleted users are closed. Each replica reads it in a group
+// named UserEventsGroupPrefix plus its host name.
+const UserEventsTopic = "users"
+const UserEventsGroupPrefix = "notificationservice-"
 
 //const KafkaBrokers = "localhost:9092"
+
+// UserServiceGRPCAddress verifies the API keys used by bots
+const UserServiceGRPCAddress = "userservice:50053"
+const APIKeyPrefix = "cak_"
+const MessagesSendScope = "messages:send"
+
+// IdempotencyKeyHeader carries a bot's key for a message, so that sending it
+// again after a timeout doesn't deliver it twice
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IncomingWebhookMaxLength caps the text of a message posted to an incoming webhook
+const IncomingWebhookMaxLength = 4000
+
+// Registered slash commands are fetched from userservice at most every
+// CommandRefreshInterval; their endpoints must answer within CommandTimeout.
+// CommandWorkers post to them, with up to CommandQueueSize invocations waiting.
+const CommandRefreshInterval = 30 * time.Second
+const CommandTimeout = 3 * time.Second
+const CommandWorkers = 4
+const CommandQueueSize = 100
+const CommandMaxTopicLength = 250
+
+// CommandsDatabasePath stores the mutes and conversation topics set with commands
+const CommandsDatabasePath = "commands.db"
+
+// BotGRPCAddress serves the streaming bot API. A subscription buffers up to
+// BotSubscriptionBuffer messages; more are dropped while the bot falls behind.
+// Its key is verified again every BotReverifyInterval.
+const BotGRPCAddress = ":50054"
+const BotSubscriptionBuffer = 100
+const BotReverifyInterval = time.Minute
+
+// WorkerGRPCAddress is workerservice, which sends scheduled messages and reminders
+const WorkerGRPCAddress = "workerservice:50052"
@@ -0,0 +1,136 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"chatapp/events"
+	"notificationservice/config"
+	"notificationservice/internal/audit"
+	"notificationservice/internal/kafka"
+	"notificationservice/internal/websocket"
+	pb "notificationservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+type botServer struct {
+	pb.UnimplementedBotServiceServer
+	manager  *websocket.WebSocketManager
+	producer *kafka.Producer
+	logger   *zap.Logger
+}
+
+// NewBotServer serves the bot API. It is separate from the internal
+// NotificationService so that only the bot API needs to be reachable by bots.
+func NewBotServer(manager *websocket.WebSocketManager, producer *kafka.Producer, logger *zap.Logger) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterBotServiceServer(s, &botServer{manager: manager, producer: producer, logger: logger})
+	reflection.Register(s)
+	return s
+}
+
+// Subscribe streams the messages sent to the bot. The key is verified again
+// every config.BotReverifyInterval, and the stream ends once it is revoked,
+// expired or can't be checked.
+func (s *botServer) Subscribe(req *pb.SubscribeRequest, stream grpc.ServerStreamingServer[pb.BotEvent]) error {
+	principal, err := s.authenticate(stream.Context())
+	if err != nil {
+		return err
+	}
+	messages, unsubscribe := s.manager.SubscribeBot(principal)
+	defer unsubscribe()
+	s.logger.Info("Bot subscribed", zap.String("bot", principal))
+
+	reverify := time.NewTicker(config.BotReverifyInterval)
+	defer reverify.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			s.logger.Info("Bot unsubscribed", zap.String("bot", principal))
+			return nil
+		case <-reverify.C:
+			if _, err := s.manager.VerifyAPIKey(apiKey(stream.Context()), config.MessagesSendScope); err != nil {
+				s.logger.Info("Closing bot subscription", zap.String("bot", principal), zap.Error(err))
+				return status.Error(codes.Unauthenticated, "API key no longer valid")
+			}
+		case msg := <-messages:
+			event := &pb.BotEvent{
+				Sender:     msg.Sender,
+				Recipients: msg.Recipients,
+				Message:    msg.Message,
+				Time:       time.Now().UTC().Format(time.RFC3339),
+				Bot:        principal,
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// SendMessage posts a message as the bot, through the same message topic as
+// WebSocket traffic.
+func (s *botServer) SendMessage(ctx context.Context, req *pb.BotMessage) (*pb.SendBotMessageResponse, error) {
+	principal, err := s.authenticate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(req.GetRecipients()) == 0 || req.GetMessage() == "" {
+		return nil, status.Error(codes.InvalidArgument, "recipients and message are required")
+	}
+	msg := websocket.Message{
+		Sender:         principal,
+		Recipients:     req.GetRecipients(),
+		Message:        req.GetMessage(),
+		IdempotencyKey: req.GetIdempotencyKey(),
+	}
+	if err := s.manager.PublishMessage(msg); err != nil {
+		return nil, status.Error(codes.Unavailable, "failed to send message")
+	}
+	return &pb.SendBotMessageResponse{}, nil
+}
+
+// apiKey returns the key in the authorization metadata.
+func apiKey(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			return strings.TrimPrefix(values[0], "Bearer ")
+		}
+	}
+	return ""
+}
+
+// authenticate resolves the API key in the authorization metadata to the bot's principal.
+func (s *botServer) authenticate(ctx context.Context) (string, error) {
+	key := apiKey(ctx)
+	if !strings.HasPrefix(key, config.APIKeyPrefix) {
+		return "", status.Error(codes.Unauthenticated, "API key required")
+	}
+	principal, err := s.manager.VerifyAPIKey(key, config.MessagesSendScope)
+	if err != nil {
+		s.auditRejected(ctx, err)
+		return "", status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	return principal, nil
+}
+
+func (s *botServer) auditRejected(ctx context.Context, err error) {
+	event := audit.NewEvent(events.AuditTokenInvalid, events.AuditDenied)
+	if p, ok := peer.FromContext(ctx); ok {
+		event.IP, _, _ = net.SplitHostPort(p.Addr.String())
+	}
+	method, _ := grpc.Method(ctx)
+	event.Details = map[string]string{"reason": err.Error(), "method": method}
+	if logErr := s.producer.SendMessageToAuditTopic(event.IP, event); logErr != nil {
+		s.logger.Error("Failed to send audit event", zap.Error(logErr))
+	}
+}
@@ -0,0 +1,88 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	pb "notificationservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Client verifies JWTs, API keys and incoming webhook tokens with userservice,
+// and fetches the registered slash commands.
+type Client struct {
+	conn     *grpc.ClientConn
+	client   pb.APIKeyServiceClient
+	tokens   pb.TokenServiceClient
+	hooks    pb.IncomingWebhookServiceClient
+	commands pb.CommandServiceClient
+}
+
+func NewClient(address string) (*Client, error) {
+	var opts []grpc.DialOption
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	conn, err := grpc.NewClient(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	client := pb.NewAPIKeyServiceClient(conn)
+	return &Client{
+		conn:     conn,
+		client:   client,
+		tokens:   pb.NewTokenServiceClient(conn),
+		hooks:    pb.NewIncomingWebhookServiceClient(conn),
+		commands: pb.NewCommandServiceClient(conn),
+	}, nil
+}
+
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+func (c *Client) VerifyAPIKey(key string) (*pb.VerifyAPIKeyResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := &pb.VerifyAPIKeyRequest{Key: key}
+	return c.client.VerifyAPIKey(ctx, req)
+}
+
+// VerifyToken also refuses JWTs that were logged out or whose user's sessions
+// were revoked since.
+func (c *Client) VerifyToken(token string) (*pb.VerifyTokenResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return c.tokens.VerifyToken(ctx, &pb.VerifyTokenRequest{Token: token})
+}
+
+func (c *Client) VerifyIncomingWebhook(id string, token string) (*pb.VerifyIncomingWebhookResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req := &pb.VerifyIncomingWebhookRequest{Id: id, Token: token}
+	return c.hooks.VerifyIncomingWebhook(ctx, req)
+}
+
+func (c *Client) ListCommands() (*pb.ListCommandsResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return c.commands.ListCommands(ctx, &pb.ListCommandsRequest{})
+}
+
+// SignCommand returns the signature of an invocation of a registered command.
+func (c *Client) SignCommand(name string, timestamp string, body []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	resp, err := c.commands.SignCommand(ctx, &pb.SignCommandRequest{Name: name, Timestamp: timestamp, Body: body})
+	if err != nil {
+		return "", err
+	}
+	return resp.GetSignature(), nil
+}
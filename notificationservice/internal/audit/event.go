@@ -0,0 +1,11 @@
+// Package audit creates this service's security audit events, defined in
+// chatapp/events, for the audit topic.
+package audit
+
+import "chatapp/events"
+
+const Service = "notificationservice"
+
+func NewEvent(eventType string, outcome string) events.Audit {
+	return events.NewAudit(eventType, outcome, Service)
+}
@@ -0,0 +1,76 @@
+// Package bot lets bots and integrations post chat messages over HTTP, with an
+// API key or an incoming webhook URL, instead of holding a WebSocket open.
+package bot
+
+import (
+	"chatapp/events"
+	"net"
+	"notificationservice/config"
+	"notificationservice/grpcclient"
+	"notificationservice/internal/audit"
+	"notificationservice/internal/kafka"
+	"notificationservice/internal/websocket"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+type Handler struct {
+	manager  *websocket.WebSocketManager
+	producer *kafka.Producer
+	hooks    *grpcclient.Client
+	logger   *zap.Logger
+}
+
+type SendMessageRequest struct {
+	Recipients []string `json:"recipients"`
+	Message    string   `json:"message"`
+}
+
+func NewHandler(manager *websocket.WebSocketManager, producer *kafka.Producer, hooks *grpcclient.Client, logger *zap.Logger) *Handler {
+	return &Handler{manager: manager, producer: producer, hooks: hooks, logger: logger}
+}
+
+// SendMessage posts a message as the key's principal. It goes through the same
+// message topic as WebSocket traffic, so delivery is identical.
+func (h *Handler) SendMessage(c *fiber.Ctx) error {
+	key := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	if !strings.HasPrefix(key, config.APIKeyPrefix) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "API key required"})
+	}
+	sender, err := h.manager.VerifyAPIKey(key, config.MessagesSendScope)
+	if err != nil {
+		h.auditRejected(c, err)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+
+	var req SendMessageRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if len(req.Recipients) == 0 || req.Message == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Recipients and message are required"})
+	}
+
+	msg := websocket.Message{
+		Sender:         sender,
+		Recipients:     req.Recipients,
+		Message:        req.Message,
+		IdempotencyKey: c.Get(config.IdempotencyKeyHeader),
+	}
+	if err := h.manager.PublishMessage(msg); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to send message"})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Message queued"})
+}
+
+func (h *Handler) auditRejected(c *fiber.Ctx, err error) {
+	event := audit.NewEvent(events.AuditTokenInvalid, events.AuditDenied)
+	event.IP, _, _ = net.SplitHostPort(c.Context().RemoteAddr().String())
+	// the route pattern, since an incoming webhook path holds its token
+	event.Details = map[string]string{"reason": err.Error(), "method": c.Method(), "path": c.Route().Path}
+	if logErr := h.producer.SendMessageToAuditTopic(event.IP, event); logErr != nil {
+		h.logger.Error("Failed to send audit event", zap.Error(logErr))
+	}
+}
@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"errors"
+	"notificationservice/config"
+	"notificationservice/internal/websocket"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+var errInvalidHookToken = errors.New("invalid incoming webhook token")
+
+// IncomingWebhookRequest is a message posted to an incoming webhook. The title,
+// when given, is put on its own line above the text.
+type IncomingWebhookRequest struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+// PostIncomingWebhook posts a message to the webhook's recipients as
+// hook:<name>. The URL itself is the credential, so no Authorization header is needed.
+func (h *Handler) PostIncomingWebhook(c *fiber.Ctx) error {
+	resp, err := h.hooks.VerifyIncomingWebhook(c.Params("hookID"), c.Params("token"))
+	if err != nil {
+		h.logger.Error("Failed to verify incoming webhook", zap.Error(err))
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "Cannot verify webhook"})
+	}
+	if !resp.GetValid() {
+		h.auditRejected(c, errInvalidHookToken)
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown webhook"})
+	}
+	// its recipients may all have been deleted since it was created
+	if len(resp.GetRecipients()) == 0 {
+		return c.Status(fiber.StatusGone).JSON(fiber.Map{"error": "Webhook has no recipients left"})
+	}
+
+	var req IncomingWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	req.Title = strings.TrimSpace(req.Title)
+	req.Text = strings.TrimSpace(req.Text)
+	if req.Text == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Text is required"})
+	}
+	message := req.Text
+	if req.Title != "" {
+		message = req.Title + "\n" + req.Text
+	}
+	if len(message) > config.IncomingWebhookMaxLength {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "Message is too long"})
+	}
+
+	msg := websocket.Message{
+		Sender:         resp.GetSender(),
+		Recipients:     resp.GetRecipients(),
+		Message:        message,
+		IdempotencyKey: c.Get(config.IdempotencyKeyHeader),
+	}
+	if err := h.manager.PublishMessage(msg); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to send message"})
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"message": "Message queued"})
+}
@@ -0,0 +1,290 @@
+// Package commands handles chat messages that start with a slash. Built-in
+// commands run in this service; other commands are registered in userservice
+// with an HTTP endpoint that each invocation is posted to, by a pool of
+// workers of their own so that a slow endpoint doesn't hold up chat messages.
+//
+// There are no channel objects, so a conversation is the sender of a message
+// together with its recipients.
+package commands
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"notificationservice/config"
+	"notificationservice/grpcclient"
+
+	"go.uber.org/zap"
+)
+
+// Context is one invocation of a command.
+type Context struct {
+	Sender     string
+	Recipients []string
+	// Command is the name without the slash, Args the rest of the message
+	Command string
+	Args    string
+}
+
+// Conversation returns the sender and the recipients, without duplicates.
+func (c Context) Conversation() []string {
+	seen := map[string]bool{c.Sender: true}
+	members := []string{c.Sender}
+	for _, r := range c.Recipients {
+		if !seen[r] {
+			seen[r] = true
+			members = append(members, r)
+		}
+	}
+	return members
+}
+
+// Response is what a command answers. An ephemeral response is shown only to the
+// sender; otherwise it is posted as Sender to Recipients, or to the whole
+// conversation when Recipients is empty.
+type Response struct {
+	Text       string
+	Ephemeral  bool
+	Sender     string
+	Recipients []string
+}
+
+type Handler func(ctx Context) Response
+
+type builtin struct {
+	handler     Handler
+	usage       string
+	description string
+}
+
+type Dispatcher struct {
+	builtins  map[string]builtin
+	client    *grpcclient.Client
+	schedules *grpcclient.ScheduleClient
+	store     *Store
+	logger    *zap.Logger
+	// invocations waits for the workers that post to external commands
+	invocations chan invocationJob
+
+	mu       sync.RWMutex
+	external map[string]externalCommand
+	loadedAt time.Time
+	// mutes maps a user to the senders whose messages they don't receive; they
+	// and topics are written to the store first
+	mutes  map[string]map[string]bool
+	topics map[string]string
+}
+
+// NewDispatcher loads the mutes and topics from store and starts
+// config.CommandWorkers workers for external commands.
+func NewDispatcher(client *grpcclient.Client, schedules *grpcclient.ScheduleClient, store *Store, logger *zap.Logger) (*Dispatcher, error) {
+	mutes, topics, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+	d := &Dispatcher{
+		client:      client,
+		schedules:   schedules,
+		store:       store,
+		logger:      logger,
+		invocations: make(chan invocationJob, config.CommandQueueSize),
+		external:    map[string]externalCommand{},
+		mutes:       mutes,
+		topics:      topics,
+	}
+	d.builtins = map[string]builtin{
+		"help":     {d.help, "/help", "list the available commands"},
+		"me":       {d.me, "/me <action>", "describe what you are doing"},
+		"mute":     {d.mute, "/mute [user]", "stop receiving messages from a user, or list muted users"},
+		"unmute":   {d.unmute, "/unmute <user>", "receive messages from a muted user again"},
+		"invite":   {d.invite, "/invite <user>", "invite a user to the conversation"},
+		"topic":    {d.topic, "/topic [text]", "show or set the conversation topic"},
+		"remind":   {d.remind, "/remind me in <delay> <text> | list | cancel <id>", "remind yourself later, e.g. /remind me in 2h call Bob"},
+		"schedule": {d.schedule, "/schedule in <delay> <text>", "send a message to the conversation later"},
+	}
+	for i := 0; i < config.CommandWorkers; i++ {
+		go d.invokeWorker()
+	}
+	return d, nil
+}
+
+// IsCommand reports whether a chat message is a command. A message starting
+// with two slashes is an ordinary message with the first slash removed.
+func IsCommand(message string) bool {
+	return strings.HasPrefix(message, "/") && !strings.HasPrefix(message, "//")
+}
+
+// Dispatch runs the command in message on behalf of sender and passes the
+// response to reply. A built-in command answers before Dispatch returns; an
+// external one answers later, from a worker.
+func (d *Dispatcher) Dispatch(sender string, recipients []string, message string, reply func(Response)) {
+	name, args, _ := strings.Cut(strings.TrimPrefix(message, "/"), " ")
+	ctx := Context{
+		Sender:     sender,
+		Recipients: recipients,
+		Command:    strings.ToLower(name),
+		Args:       strings.TrimSpace(args),
+	}
+
+	if b, ok := d.builtins[ctx.Command]; ok {
+		reply(b.handler(ctx))
+		return
+	}
+	if cmd, ok := d.lookup(ctx.Command); ok {
+		select {
+		case d.invocations <- invocationJob{cmd: cmd, ctx: ctx, reply: reply}:
+		default:
+			reply(ephemeral("Too many commands are running, try /" + cmd.name + " again later."))
+		}
+		return
+	}
+	reply(ephemeral("Unknown command /" + ctx.Command + ". Type /help for the list of commands."))
+}
+
+// Muted reports whether recipient has muted sender.
+func (d *Dispatcher) Muted(recipient string, sender string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.mutes[recipient][sender]
+}
+
+func ephemeral(text string) Response {
+	return Response{Text: text, Ephemeral: true}
+}
+
+// conversationKey identifies a conversation regardless of who sent the message.
+func conversationKey(members []string) string {
+	sorted := append([]string(nil), members...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, ",")
+}
+
+func (d *Dispatcher) help(ctx Context) Response {
+	lines := []string{"Commands:"}
+	names := make([]string, 0, len(d.builtins))
+	for name := range d.builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b := d.builtins[name]
+		lines = append(lines, b.usage+" - "+b.description)
+	}
+
+	d.refresh()
+	d.mu.RLock()
+	external := make([]string, 0, len(d.external))
+	for name, cmd := range d.external {
+		line := "/" + name
+		if cmd.description != "" {
+			line += " - " + cmd.description
+		}
+		external = append(external, line)
+	}
+	d.mu.RUnlock()
+	sort.Strings(external)
+	lines = append(lines, external...)
+	lines = append(lines, "Start a message with // to send it as text.")
+	return ephemeral(strings.Join(lines, "\n"))
+}
+
+func (d *Dispatcher) me(ctx Context) Response {
+	if ctx.Args == "" {
+		return ephemeral("Usage: /me <action>")
+	}
+	return Response{Text: "* " + ctx.Sender + " " + ctx.Args, Sender: ctx.Sender}
+}
+
+func (d *Dispatcher) mute(ctx Context) Response {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if ctx.Args == "" {
+		muted := make([]string, 0, len(d.mutes[ctx.Sender]))
+		for user := range d.mutes[ctx.Sender] {
+			muted = append(muted, user)
+		}
+		if len(muted) == 0 {
+			return ephemeral("You haven't muted anyone.")
+		}
+		sort.Strings(muted)
+		return ephemeral("Muted: " + strings.Join(muted, ", "))
+	}
+	if ctx.Args == ctx.Sender {
+		return ephemeral("You can't mute yourself.")
+	}
+	if err := d.store.mute(ctx.Sender, ctx.Args); err != nil {
+		d.logger.Error("Failed to store mute", zap.Error(err))
+		return ephemeral("Muting is unavailable, try again later.")
+	}
+	if d.mutes[ctx.Sender] == nil {
+		d.mutes[ctx.Sender] = map[string]bool{}
+	}
+	d.mutes[ctx.Sender][ctx.Args] = true
+	return ephemeral("Muted " + ctx.Args + ". You won't receive their messages until you /unmute them.")
+}
+
+func (d *Dispatcher) unmute(ctx Context) Response {
+	if ctx.Args == "" {
+		return ephemeral("Usage: /unmute <user>")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.mutes[ctx.Sender][ctx.Args] {
+		return ephemeral(ctx.Args + " isn't muted.")
+	}
+	if err := d.store.unmute(ctx.Sender, ctx.Args); err != nil {
+		d.logger.Error("Failed to remove mute", zap.Error(err))
+		return ephemeral("Unmuting is unavailable, try again later.")
+	}
+	delete(d.mutes[ctx.Sender], ctx.Args)
+	return ephemeral("Unmuted " + ctx.Args + ".")
+}
+
+func (d *Dispatcher) invite(ctx Context) Response {
+	if ctx.Args == "" || strings.Contains(ctx.Args, " ") {
+		return ephemeral("Usage: /invite <user>")
+	}
+	members := ctx.Conversation()
+	for _, m := range members {
+		if m == ctx.Args {
+			return ephemeral(ctx.Args + " is already in the conversation.")
+		}
+	}
+	return Response{
+		Text:       ctx.Sender + " invited " + ctx.Args + " to the conversation with " + strings.Join(members, ", "),
+		Sender:     ctx.Sender,
+		Recipients: append(members, ctx.Args),
+	}
+}
+
+func (d *Dispatcher) topic(ctx Context) Response {
+	key := conversationKey(ctx.Conversation())
+	if ctx.Args == "" {
+		d.mu.RLock()
+		topic := d.topics[key]
+		d.mu.RUnlock()
+		if topic == "" {
+			return ephemeral("This conversation has no topic.")
+		}
+		return ephemeral("Topic: " + topic)
+	}
+	if len(ctx.Args) > config.CommandMaxTopicLength {
+		return ephemeral("The topic is too long.")
+	}
+
+	d.mu.Lock()
+	err := d.store.setTopic(key, ctx.Args)
+	if err == nil {
+		d.topics[key] = ctx.Args
+	}
+	d.mu.Unlock()
+	if err != nil {
+		d.logger.Error("Failed to store topic", zap.Error(err))
+		return ephemeral("Setting the topic is unavailable, try again later.")
+	}
+	return Response{Text: ctx.Sender + " set the topic to: " + ctx.Args, Sender: ctx.Sender}
+}
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"chatapp/slash"
+
+	"go.uber.org/zap"
+)
+
+func newTestDispatcher(t *testing.T, path string) *Dispatcher {
+	t.Helper()
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	d, err := NewDispatcher(nil, nil, store, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func run(d *Dispatcher, sender string, recipients []string, message string) Response {
+	var resp Response
+	d.Dispatch(sender, recipients, message, func(r Response) { resp = r })
+	return resp
+}
+
+func TestBuiltinsMatchSlash(t *testing.T) {
+	d := newTestDispatcher(t, filepath.Join(t.TempDir(), "commands.db"))
+	var names []string
+	for name := range d.builtins {
+		names = append(names, name)
+	}
+	want := append([]string(nil), slash.Builtins...)
+	sort.Strings(names)
+	sort.Strings(want)
+	if len(names) != len(want) {
+		t.Fatalf("builtins = %v, slash.Builtins = %v", names, want)
+	}
+	for i := range names {
+		if names[i] != want[i] {
+			t.Fatalf("builtins = %v, slash.Builtins = %v", names, want)
+		}
+	}
+}
+
+func TestMutesAndTopicsSurviveRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "commands.db")
+	d := newTestDispatcher(t, path)
+	run(d, "alice", []string{"bob"}, "/mute carol")
+	run(d, "alice", []string{"bob"}, "/mute dave")
+	run(d, "alice", []string{"bob"}, "/unmute dave")
+	run(d, "bob", []string{"alice"}, "/topic release")
+
+	d = newTestDispatcher(t, path)
+	if !d.Muted("alice", "carol") || d.Muted("alice", "dave") {
+		t.Errorf("mutes after restart: carol %v, dave %v; want true, false", d.Muted("alice", "carol"), d.Muted("alice", "dave"))
+	}
+	if resp := run(d, "alice", []string{"bob"}, "/topic"); resp.Text != "Topic: release" {
+		t.Errorf("topic after restart = %q", resp.Text)
+	}
+}
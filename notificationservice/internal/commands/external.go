@@ -0,0 +1,162 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"chatapp/outbound"
+	"notificationservice/config"
+
+	"go.uber.org/zap"
+)
+
+// SenderPrefix marks messages posted by an external command.
+const SenderPrefix = "command:"
+
+type externalCommand struct {
+	name        string
+	url         string
+	description string
+}
+
+// invocationJob is an external command waiting for a worker.
+type invocationJob struct {
+	cmd   externalCommand
+	ctx   Context
+	reply func(Response)
+}
+
+// invocation is the body posted to an external command's endpoint. It is signed
+// like outgoing webhooks, with outbound.Sign and the command's secret, which only
+// userservice holds.
+type invocation struct {
+	Command    string   `json:"command"`
+	Text       string   `json:"text"`
+	Sender     string   `json:"sender"`
+	Recipients []string `json:"recipients"`
+}
+
+// reply is what the endpoint answers. response_type "in_channel" posts the text
+// to the conversation; anything else shows it only to the sender.
+type reply struct {
+	Text         string `json:"text"`
+	ResponseType string `json:"response_type"`
+}
+
+// httpClient refuses internal addresses, as anyone with commands:manage picks the URL
+var httpClient = outbound.NewClient(config.CommandTimeout)
+
+// lookup returns the registered command, refreshing the list when it is stale.
+func (d *Dispatcher) lookup(name string) (externalCommand, bool) {
+	d.refresh()
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	cmd, ok := d.external[name]
+	return cmd, ok
+}
+
+// refresh reloads the registered commands from userservice. On failure the
+// previous list is kept until the next refresh is due.
+func (d *Dispatcher) refresh() {
+	d.mu.RLock()
+	fresh := time.Since(d.loadedAt) < config.CommandRefreshInterval
+	d.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	resp, err := d.client.ListCommands()
+	if err != nil {
+		d.logger.Error("Failed to call ListCommands RPC", zap.Error(err))
+		d.mu.Lock()
+		d.loadedAt = time.Now()
+		d.mu.Unlock()
+		return
+	}
+	external := make(map[string]externalCommand, len(resp.GetCommands()))
+	for _, cmd := range resp.GetCommands() {
+		external[cmd.GetName()] = externalCommand{
+			name:        cmd.GetName(),
+			url:         cmd.GetUrl(),
+			description: cmd.GetDescription(),
+		}
+	}
+
+	d.mu.Lock()
+	d.external = external
+	d.loadedAt = time.Now()
+	d.mu.Unlock()
+}
+
+func (d *Dispatcher) invokeWorker() {
+	for job := range d.invocations {
+		job.reply(d.invoke(job.cmd, job.ctx))
+	}
+}
+
+func (d *Dispatcher) invoke(cmd externalCommand, ctx Context) Response {
+	r, err := d.post(cmd, ctx)
+	if err != nil {
+		d.logger.Error("Command endpoint failed", zap.String("command", cmd.name), zap.Error(err))
+		return ephemeral("/" + cmd.name + " failed, try again later.")
+	}
+	if r.Text == "" {
+		return Response{}
+	}
+	if r.ResponseType != "in_channel" {
+		return ephemeral(r.Text)
+	}
+	return Response{Text: r.Text, Sender: SenderPrefix + cmd.name}
+}
+
+func (d *Dispatcher) post(cmd externalCommand, ctx Context) (*reply, error) {
+	body, err := json.Marshal(invocation{
+		Command:    "/" + cmd.name,
+		Text:       ctx.Args,
+		Sender:     ctx.Sender,
+		Recipients: ctx.Recipients,
+	})
+	if err != nil {
+		return nil, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature, err := d.client.SignCommand(cmd.name, timestamp, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign invocation: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cmd.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(outbound.TimestampHeader, timestamp)
+	req.Header.Set(outbound.SignatureHeader, signature)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+
+	var r reply
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &r, nil
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	return &r, nil
+}
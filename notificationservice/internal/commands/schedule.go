@@ -0,0 +1,117 @@
+package commands
+
+import (
+	"chatapp/slash"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "notificationservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const remindUsage = "Usage: /remind me in <delay> <text>, /remind list or /remind cancel <id>. A delay looks like 30m, 2h or 1d."
+
+func (d *Dispatcher) remind(ctx Context) Response {
+	sub, rest, _ := strings.Cut(ctx.Args, " ")
+	rest = strings.TrimSpace(rest)
+	switch sub {
+	case "list":
+		return d.listSchedules(ctx.Sender)
+	case "cancel":
+		id, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return ephemeral(remindUsage)
+		}
+		if err := d.schedules.Cancel(id, ctx.Sender); err != nil {
+			return d.scheduleError(err)
+		}
+		return ephemeral("Canceled #" + rest + ".")
+	case "me":
+		sendAt, text, ok := parseDelay(rest)
+		if !ok {
+			return ephemeral(remindUsage)
+		}
+		return d.createSchedule(&pb.CreateScheduleRequest{Owner: ctx.Sender, Kind: "reminder", Message: text, SendAt: sendAt})
+	}
+	return ephemeral(remindUsage)
+}
+
+func (d *Dispatcher) schedule(ctx Context) Response {
+	sendAt, text, ok := parseDelay(ctx.Args)
+	if !ok || len(ctx.Recipients) == 0 {
+		return ephemeral("Usage: /schedule in <delay> <text>. A delay looks like 30m, 2h or 1d.")
+	}
+	return d.createSchedule(&pb.CreateScheduleRequest{
+		Owner:      ctx.Sender,
+		Kind:       "message",
+		Recipients: ctx.Recipients,
+		Message:    text,
+		SendAt:     sendAt,
+	})
+}
+
+func (d *Dispatcher) createSchedule(req *pb.CreateScheduleRequest) Response {
+	sched, err := d.schedules.Create(req)
+	if err != nil {
+		return d.scheduleError(err)
+	}
+	what := "Message"
+	if sched.GetKind() == "reminder" {
+		what = "Reminder"
+	}
+	return ephemeral(what + " #" + strconv.FormatInt(sched.GetId(), 10) + " scheduled for " + sched.GetSendAt() + ".")
+}
+
+func (d *Dispatcher) listSchedules(owner string) Response {
+	resp, err := d.schedules.List(owner)
+	if err != nil {
+		return d.scheduleError(err)
+	}
+	if len(resp.GetSchedules()) == 0 {
+		return ephemeral("Nothing scheduled.")
+	}
+	lines := []string{"Scheduled:"}
+	for _, s := range resp.GetSchedules() {
+		line := "#" + strconv.FormatInt(s.GetId(), 10) + " " + s.GetSendAt() + " "
+		if s.GetKind() == "reminder" {
+			line += "reminder: "
+		} else {
+			line += "to " + strings.Join(s.GetRecipients(), ", ") + ": "
+		}
+		lines = append(lines, line+s.GetMessage())
+	}
+	return ephemeral(strings.Join(lines, "\n"))
+}
+
+func (d *Dispatcher) scheduleError(err error) Response {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return ephemeral("Can't schedule that: " + status.Convert(err).Message() + ".")
+	case codes.ResourceExhausted:
+		return ephemeral("You have too many pending schedules.")
+	case codes.PermissionDenied:
+		return ephemeral("You aren't allowed to send messages.")
+	case codes.NotFound:
+		return ephemeral("No pending schedule with that id.")
+	}
+	d.logger.Error("Schedule RPC failed", zap.Error(err))
+	return ephemeral("Scheduling is unavailable, try again later.")
+}
+
+// parseDelay reads "in <delay> <text>" and returns the RFC 3339 send time and the
+// text. The delay is read by slash.ParseDelay, like in the schedules API.
+func parseDelay(args string) (string, string, bool) {
+	fields := strings.SplitN(args, " ", 3)
+	if len(fields) < 3 || fields[0] != "in" || strings.TrimSpace(fields[2]) == "" {
+		return "", "", false
+	}
+	delay, err := slash.ParseDelay(fields[1])
+	if err != nil {
+		return "", "", false
+	}
+	return time.Now().Add(delay).UTC().Format(time.RFC3339), strings.TrimSpace(fields[2]), true
+}
@@ -0,0 +1,97 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Store keeps the mutes and conversation topics set with commands, so they
+// survive a restart. The dispatcher holds a copy in memory for lookups.
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path+"?_busy_timeout=5000&_journal_mode=WAL")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open commands database: %w", err)
+	}
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS mutes (
+			user_id TEXT NOT NULL,
+			muted TEXT NOT NULL,
+			PRIMARY KEY (user_id, muted)
+		)`,
+		`CREATE TABLE IF NOT EXISTS topics (
+			conversation TEXT NOT NULL PRIMARY KEY,
+			topic TEXT NOT NULL
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create commands tables: %w", err)
+		}
+	}
+	return &Store{db: db}, nil
+}
+
+// load returns every mute, by user, and every topic, by conversation key.
+func (s *Store) load() (map[string]map[string]bool, map[string]string, error) {
+	mutes := map[string]map[string]bool{}
+	rows, err := s.db.Query("SELECT user_id, muted FROM mutes")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load mutes: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var user, muted string
+		if err := rows.Scan(&user, &muted); err != nil {
+			return nil, nil, err
+		}
+		if mutes[user] == nil {
+			mutes[user] = map[string]bool{}
+		}
+		mutes[user][muted] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	topics := map[string]string{}
+	rows, err = s.db.Query("SELECT conversation, topic FROM topics")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load topics: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var conversation, topic string
+		if err := rows.Scan(&conversation, &topic); err != nil {
+			return nil, nil, err
+		}
+		topics[conversation] = topic
+	}
+	return mutes, topics, rows.Err()
+}
+
+func (s *Store) mute(user string, muted string) error {
+	_, err := s.db.Exec("INSERT OR IGNORE INTO mutes (user_id, muted) VALUES (?, ?)", user, muted)
+	return err
+}
+
+func (s *Store) unmute(user string, muted string) error {
+	_, err := s.db.Exec("DELETE FROM mutes WHERE user_id = ? AND muted = ?", user, muted)
+	return err
+}
+
+func (s *Store) setTopic(conversation string, topic string) error {
+	_, err := s.db.Exec(`INSERT INTO topics (conversation, topic) VALUES (?, ?)
+		ON CONFLICT (conversation) DO UPDATE SET topic = excluded.topic`, conversation, topic)
+	return err
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -3,73 +3,109 @@ package kafka
 import (
 	"context"
 	"encoding/json"
+	"slices"
+	"strconv"
 	"time"
 
 	"notificationservice/config"
 
-	"github.com/segmentio/kafka-go"
+	"chatapp/broker"
+	"chatapp/events"
+
 	"go.uber.org/zap"
 )
 
 type Producer struct {
-	emailWriter *kafka.Writer
-	logsWriter  *kafka.Writer
-	msgWriter   *kafka.Writer
-	logger      *zap.Logger
+	broker broker.Broker
+	// async queues the messages of config.ProducerAsyncTopics for writing in
+	// batches; nil when there are none
+	async  *broker.AsyncPublisher
+	logger *zap.Logger
 }
 
-func NewProducer(logger *zap.Logger) *Producer {
-	emailWriter := &kafka.Writer{
-		Addr:     kafka.TCP(config.KafkaBrokers),
-		Topic:    config.EmailTopic,
-		Balancer: &kafka.LeastBytes{},
-	}
+// Connect opens the message broker selected by config.BrokerDriver, or by the
+// environment variables of broker.FromEnv.
+func Connect() (broker.Broker, error) {
+	return broker.Open(broker.FromEnv(broker.Config{
+		Driver:       config.BrokerDriver,
+		KafkaBrokers: config.KafkaBrokers,
+		NATSURL:      config.NATSURL,
+	}))
+}
 
-	logsWriter := &kafka.Writer{
-		Addr:     kafka.TCP(config.KafkaBrokers),
-		Topic:    config.LogsTopic,
-		Balancer: &kafka.LeastBytes{},
-	}
-	msgWriter := &kafka.Writer{
-		Addr:     kafka.TCP(config.KafkaBrokers),
-		Topic:    config.MessageTopic,
-		Balancer: &kafka.LeastBytes{},
+// NewProducer publishes to b, which Close closes.
+func NewProducer(b broker.Broker, logger *zap.Logger) *Producer {
+	p := &Producer{broker: b, logger: logger}
+	if len(config.ProducerAsyncTopics) > 0 {
+		p.async = broker.NewAsyncPublisher(b, broker.AsyncConfig{
+			QueueSize:    config.ProducerQueueSize,
+			BatchSize:    config.ProducerBatchSize,
+			BatchTimeout: config.ProducerBatchTimeout,
+			WriteTimeout: config.ProducerWriteTimeout,
+			BlockTimeout: config.ProducerBlockTimeout,
+			OnDelivery:   p.delivered,
+		})
+		if err := broker.RegisterAsyncMetrics(p.async, "notificationservice"); err != nil {
+			logger.Error("Failed to register producer metrics", zap.Error(err))
+		}
 	}
+	return p
+}
 
-	return &Producer{
-		emailWriter: emailWriter,
-		logsWriter:  logsWriter,
-		msgWriter:   msgWriter,
-		logger:      logger,
-	}
+func (p *Producer) SendMessageToEmailTopic(key string, event events.Email) error {
+	return p.sendMessage(config.EmailTopic, key, "", event)
 }
 
-func (p *Producer) SendMessageToEmailTopic(key string, value interface{}) error {
-	return p.sendMessage(p.emailWriter, key, value)
+func (p *Producer) SendMessageToLogsTopic(key string, event events.Log) error {
+	return p.sendMessage(config.LogsTopic, key, "", event)
 }
 
-func (p *Producer) SendMessageToLogsTopic(key string, value interface{}) error {
-	return p.sendMessage(p.logsWriter, key, value)
+// SendMessageToMessageTopic publishes a chat message. The eventID can come from
+// events.IdempotentEventID, so that sending the message again delivers it once;
+// an empty one gets a random ID.
+func (p *Producer) SendMessageToMessageTopic(key string, eventID string, event events.ChatMessage) error {
+	return p.sendMessage(config.MessageTopic, key, eventID, event)
 }
 
-func (p *Producer) SendMessageToMessageTopic(key string, value interface{}) error {
-	return p.sendMessage(p.msgWriter, key, value)
+func (p *Producer) SendMessageToAuditTopic(key string, event events.Audit) error {
+	return p.sendMessage(config.AuditTopic, key, "", event)
 }
 
-func (p *Producer) sendMessage(writer *kafka.Writer, key string, value interface{}) error {
+func (p *Producer) sendMessage(topic string, key string, eventID string, value interface{}) error {
 	message, err := json.Marshal(value)
 	if err != nil {
 		return err
 	}
+	if eventID == "" {
+		if eventID, err = events.NewEventID(); err != nil {
+			return err
+		}
+	}
+	headers := []broker.Header{{Key: events.HeaderEventID, Value: []byte(eventID)}}
+	if e, ok := value.(events.Event); ok {
+		headers = append(headers,
+			broker.Header{Key: events.HeaderType, Value: []byte(e.EventType())},
+			broker.Header{Key: events.HeaderVersion, Value: []byte(strconv.Itoa(e.SchemaVersion()))})
+	}
 
-	err = writer.WriteMessages(context.Background(),
-		kafka.Message{
-			Key:   []byte(key),
-			Value: message,
-			Time:  time.Now(),
-		},
-	)
-	if err != nil {
+	msg := broker.Message{
+		Topic:   topic,
+		Key:     []byte(key),
+		Value:   message,
+		Time:    time.Now(),
+		Headers: headers,
+	}
+	if p.async != nil && slices.Contains(config.ProducerAsyncTopics, topic) {
+		if err := p.async.Publish(msg); err != nil {
+			p.logger.Error("Failed to queue message", zap.String("topic", topic), zap.Error(err))
+			return err
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ProducerWriteTimeout)
+	defer cancel()
+	if err := p.broker.Publish(ctx, msg); err != nil {
 		p.logger.Error("Failed to send message", zap.Error(err))
 		return err
 	}
@@ -78,15 +114,24 @@ func (p *Producer) sendMessage(writer *kafka.Writer, key string, value interface
 	return nil
 }
 
-func (p *Producer) Close() error {
-	if err := p.emailWriter.Close(); err != nil {
-		return err
-	}
-	if err := p.logsWriter.Close(); err != nil {
-		return err
+// delivered reports the outcome of a message written in the background.
+func (p *Producer) delivered(msg broker.Message, err error) {
+	if err != nil {
+		p.logger.Error("Failed to send message", zap.String("topic", msg.Topic), zap.String("key", string(msg.Key)), zap.Error(err))
+		return
 	}
-	if err := p.msgWriter.Close(); err != nil {
-		return err
+	p.logger.Debug("Message sent successfully", zap.String("topic", msg.Topic), zap.String("key", string(msg.Key)))
+}
+
+// Close writes the queued messages, waiting up to config.ProducerFlushTimeout,
+// and closes the broker.
+func (p *Producer) Close() error {
+	if p.async != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), config.ProducerFlushTimeout)
+		defer cancel()
+		if err := p.async.Close(ctx); err != nil {
+			p.logger.Error("Failed to flush queued messages", zap.Int64("queued", p.async.Stats().Queued), zap.Error(err))
+		}
 	}
-	return nil
+	return p.broker.Close()
 }
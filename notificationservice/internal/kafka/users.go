@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"notificationservice/config"
+
+	"chatapp/broker"
+	"chatapp/events"
+
+	"go.uber.org/zap"
+)
+
+// WatchUserEvents reads the users topic until ctx is canceled and passes the
+// type and user id of each event to handle. Every replica holds the
+// connections of its own users, so each one reads every event, in a group of
+// its own named after its host.
+func WatchUserEvents(ctx context.Context, b broker.Broker, logger *zap.Logger, handle func(eventType string, userID string)) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	sub, err := b.Subscribe(config.UserEventsTopic, config.UserEventsGroupPrefix+host)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", config.UserEventsTopic, err)
+	}
+	defer sub.Close()
+
+	for {
+		msg, err := sub.Fetch(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, broker.ErrClosed) {
+				return nil
+			}
+			logger.Error("Failed to read user event", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		var event events.UserEvent
+		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			logger.Error("Failed to unmarshal user event", zap.Error(err))
+		} else if event.UserID != "" {
+			handle(event.Type, event.UserID)
+		}
+		if err := sub.Commit(ctx, msg); err != nil && ctx.Err() == nil {
+			logger.Error("Failed to commit user event", zap.Error(err))
+		}
+	}
+}
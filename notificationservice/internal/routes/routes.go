@@ -1,13 +1,19 @@
 package routes
 
 import (
+	"notificationservice/internal/bot"
 	"notificationservice/internal/websocket"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	ws "github.com/gofiber/websocket/v2"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func Setup(app *fiber.App, manager *websocket.WebSocketManager) {
+func Setup(app *fiber.App, manager *websocket.WebSocketManager, bots *bot.Handler) {
+	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 	app.Use("/ws", manager.HandleConnections)
 	app.Get("/ws", ws.New(manager.WebSocket))
+	app.Post("/api/v1/bot/messages", bots.SendMessage)
+	app.Post("/api/v1/hooks/:hookID/:token", bots.PostIncomingWebhook)
 }
@@ -1,8 +1,15 @@
 package websocket
 
 import (
+	"chatapp/events"
+	"errors"
+	"net"
 	"notificationservice/config"
+	"notificationservice/grpcclient"
+	"notificationservice/internal/audit"
+	"notificationservice/internal/commands"
 	"notificationservice/internal/kafka"
+	"strings"
 	"sync"
 	"time"
 
@@ -18,23 +25,33 @@ type WebSocketManager struct {
 	mutex     *sync.Mutex
 	logger    *zap.Logger
 	producer  *kafka.Producer
+	keys      *grpcclient.Client
+	commands  *commands.Dispatcher
 	workers   int
+	// bots maps a bot principal to its gRPC subscriptions
+	bots map[string]map[chan Message]bool
 }
 
 type Message struct {
 	Sender     string   `json:"sender"`
 	Recipients []string `json:"recipients"`
 	Message    string   `json:"message"`
+	// IdempotencyKey lets a sender send a message again, say after a timeout,
+	// without it being delivered twice
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
 }
 
-func NewWebSocketManager(logger *zap.Logger, producer *kafka.Producer, workers int) *WebSocketManager {
+func NewWebSocketManager(logger *zap.Logger, producer *kafka.Producer, keys *grpcclient.Client, cmds *commands.Dispatcher, workers int) *WebSocketManager {
 	return &WebSocketManager{
 		clients:   make(map[string]*websocket.Conn),
 		broadcast: make(chan Message),
 		mutex:     &sync.Mutex{},
 		logger:    logger,
 		producer:  producer,
+		keys:      keys,
+		commands:  cmds,
 		workers:   workers,
+		bots:      make(map[string]map[chan Message]bool),
 	}
 }
 
@@ -62,38 +79,27 @@ func (m *WebSocketManager) WebSocket(c *websocket.Conn) {
 		c.Close()
 	}()
 
-	// Validate JWT token
+	// Validate JWT token or bot API key
 	tokenString := c.Query("token")
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.JWTSecret), nil
-	})
-
-	if err != nil || !token.Valid {
-		m.logger.Error("Invalid token", zap.String("token", tokenString), zap.Error(err))
+	userID, err := m.authenticate(tokenString)
+	if err != nil {
+		m.logger.Error("Invalid token", zap.Error(err))
 		c.WriteMessage(websocket.TextMessage, []byte("Invalid token"))
 		c.Close()
-
-		logEvent := map[string]interface{}{
-			"message": "Invalid token",
-			"token":   tokenString,
-			"time":    time.Now().Format(time.RFC3339),
-		}
-		if logErr := m.producer.SendMessageToLogsTopic("Token", logEvent); logErr != nil {
-			m.logger.Error("Failed to send log event", zap.Error(logErr))
-		}
+		m.auditInvalidToken(c, tokenString, err)
 		return
 	}
-	claims := token.Claims.(jwt.MapClaims)
-	userID := claims["id"].(string)
 	// Send welcome message via websocket
 	m.logger.Info("Client connected", zap.String("remote_addr", c.RemoteAddr().String()), zap.String("user_id", userID))
 	c.WriteMessage(websocket.TextMessage, []byte("Welcome back!"))
 
-	logEvent := map[string]interface{}{
-		"message":     "Client connected",
-		"userId":      userID,
-		"remote_addr": c.RemoteAddr().String(),
-		"time":        time.Now().Format(time.RFC3339),
+	logEvent := events.Log{
+		Message: "Client connected",
+		UserID:  userID,
+		Fields: map[string]string{
+			"remote_addr": c.RemoteAddr().String(),
+		},
+		Time: time.Now(),
 	}
 	if logErr := m.producer.SendMessageToLogsTopic(userID, logEvent); logErr != nil {
 		m.logger.Error("Failed to send log event", zap.Error(logErr))
@@ -112,6 +118,8 @@ func (m *WebSocketManager) WebSocket(c *websocket.Conn) {
 			m.logger.Error("Error reading JSON", zap.Error(err))
 			break
 		}
+		// the sender is whoever the token belongs to, not what the client claims
+		msg.Sender = userID
 		m.logger.Info("Message received", zap.String("sender", msg.Sender), zap.Strings("recipients", msg.Recipients), zap.String("message", msg.Message))
 		m.broadcast <- msg
 	}
@@ -121,6 +129,10 @@ func (m *WebSocketManager) HandleMessages(msg Message) {
 	m.logger.Info("Handling message", zap.String("sender", msg.Sender), zap.Strings("recipients", msg.Recipients), zap.String("message", msg.Message))
 	m.mutex.Lock()
 	for _, recipient := range msg.Recipients {
+		if m.commands.Muted(recipient, msg.Sender) {
+			continue
+		}
+		toBot := m.deliverToBot(recipient, msg)
 		if client, exists := m.clients[recipient]; exists {
 			err := client.WriteJSON(msg.Message)
 			if err != nil {
@@ -128,13 +140,51 @@ func (m *WebSocketManager) HandleMessages(msg Message) {
 				client.Close()
 				delete(m.clients, recipient)
 			}
-		} else {
+		} else if !toBot {
 			m.logger.Error("Recipient not connected", zap.String("recipient", recipient))
 		}
 	}
 	m.mutex.Unlock()
 }
 
+// SubscribeBot returns a channel that receives every message sent to the bot
+// principal, and a function that ends the subscription.
+func (m *WebSocketManager) SubscribeBot(principal string) (<-chan Message, func()) {
+	ch := make(chan Message, config.BotSubscriptionBuffer)
+	m.mutex.Lock()
+	if m.bots[principal] == nil {
+		m.bots[principal] = make(map[chan Message]bool)
+	}
+	m.bots[principal][ch] = true
+	m.mutex.Unlock()
+
+	return ch, func() {
+		m.mutex.Lock()
+		delete(m.bots[principal], ch)
+		if len(m.bots[principal]) == 0 {
+			delete(m.bots, principal)
+		}
+		m.mutex.Unlock()
+	}
+}
+
+// deliverToBot hands the message to the recipient's bot subscriptions, if it
+// has any. The caller holds m.mutex.
+func (m *WebSocketManager) deliverToBot(recipient string, msg Message) bool {
+	subs, ok := m.bots[recipient]
+	if !ok {
+		return false
+	}
+	for ch := range subs {
+		select {
+		case ch <- msg:
+		default:
+			m.logger.Warn("Bot subscription is full, dropping message", zap.String("bot", recipient))
+		}
+	}
+	return true
+}
+
 func (m *WebSocketManager) StartWorkerPool() {
 	for i := 0; i < m.workers; i++ {
 		go m.worker(i)
@@ -144,25 +194,144 @@ func (m *WebSocketManager) StartWorkerPool() {
 func (m *WebSocketManager) worker(id int) {
 	for msg := range m.broadcast {
 		m.logger.Info("Worker handling message", zap.Int("worker_id", id), zap.String("sender", msg.Sender), zap.Strings("recipients", msg.Recipients), zap.String("message", msg.Message))
+		if commands.IsCommand(msg.Message) {
+			m.runCommand(msg)
+			continue
+		}
+		if strings.HasPrefix(msg.Message, "//") {
+			msg.Message = msg.Message[1:]
+		}
 		m.pushMessageToKafka(msg)
 	}
 }
 
+// runCommand dispatches a slash command. Ephemeral responses go straight to the
+// sender's connection; the others are published like any chat message.
+func (m *WebSocketManager) runCommand(msg Message) {
+	m.commands.Dispatch(msg.Sender, msg.Recipients, msg.Message, func(resp commands.Response) {
+		m.respond(msg, resp)
+	})
+}
+
+func (m *WebSocketManager) respond(msg Message, resp commands.Response) {
+	if resp.Text == "" {
+		return
+	}
+	if resp.Ephemeral {
+		m.sendEphemeral(msg.Sender, resp.Text)
+		return
+	}
+	recipients := resp.Recipients
+	if len(recipients) == 0 {
+		recipients = commands.Context{Sender: msg.Sender, Recipients: msg.Recipients}.Conversation()
+	}
+	m.PublishMessage(Message{Sender: resp.Sender, Recipients: recipients, Message: resp.Text})
+}
+
+func (m *WebSocketManager) sendEphemeral(userID string, text string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	client, ok := m.clients[userID]
+	if !ok {
+		return
+	}
+	if err := client.WriteJSON(text); err != nil {
+		m.logger.Error("Error writing JSON", zap.Error(err))
+		client.Close()
+		delete(m.clients, userID)
+	}
+}
+
 func (m *WebSocketManager) pushMessageToKafka(msg Message) {
-	msgEvent := Message{
+	m.PublishMessage(msg)
+}
+
+// PublishMessage puts a chat message on the message topic, from where the
+// worker delivers it to the recipients' WebSockets.
+func (m *WebSocketManager) PublishMessage(msg Message) error {
+	msgEvent := events.ChatMessage{
 		Sender:     msg.Sender,
 		Recipients: msg.Recipients,
 		Message:    msg.Message,
 	}
 
-	err := m.producer.SendMessageToMessageTopic(msg.Sender, msgEvent)
+	var eventID string
+	if msg.IdempotencyKey != "" {
+		eventID = events.IdempotentEventID(msg.Sender, msg.IdempotencyKey)
+	}
+	err := m.producer.SendMessageToMessageTopic(msg.Sender, eventID, msgEvent)
 	if err != nil {
 		m.logger.Error("Error writing message to Kafka", zap.Error(err))
-	} else {
-		m.logger.Info("Message pushed to Kafka", zap.String("sender", msg.Sender))
+		return err
+	}
+	m.logger.Info("Message pushed to Kafka", zap.String("sender", msg.Sender))
+	return nil
+}
+
+// authenticate returns the user id of a JWT that userservice still accepts, or
+// the principal of an API key allowed to send messages.
+func (m *WebSocketManager) authenticate(tokenString string) (string, error) {
+	if strings.HasPrefix(tokenString, config.APIKeyPrefix) {
+		return m.VerifyAPIKey(tokenString, config.MessagesSendScope)
+	}
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return []byte(config.JWTSecret), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+	if _, ok := token.Claims.(jwt.MapClaims)["id"].(string); !ok {
+		return "", errors.New("token has no user id")
+	}
+
+	// only userservice knows about logouts and revoked sessions
+	res, err := m.keys.VerifyToken(tokenString)
+	if err != nil {
+		m.logger.Error("Failed to call VerifyToken RPC", zap.Error(err))
+		return "", err
+	}
+	if !res.GetValid() {
+		return "", errors.New(res.GetReason())
+	}
+	return res.GetUserId(), nil
+}
+
+// HandleUserEvent closes the connection of a user who was suspended or
+// deleted, whichever replica userservice asked to log them out.
+func (m *WebSocketManager) HandleUserEvent(eventType string, userID string) {
+	if eventType != events.UserSuspended && eventType != events.UserDeleted {
+		return
+	}
+	m.mutex.Lock()
+	_, connected := m.clients[userID]
+	m.mutex.Unlock()
+	if connected {
+		m.CloseConnection(userID)
 	}
 }
 
+// VerifyAPIKey asks userservice about the key and returns its principal if the
+// key is valid and carries the scope.
+func (m *WebSocketManager) VerifyAPIKey(key string, scope string) (string, error) {
+	res, err := m.keys.VerifyAPIKey(key)
+	if err != nil {
+		m.logger.Error("Failed to call VerifyAPIKey RPC", zap.Error(err))
+		return "", err
+	}
+	if !res.GetValid() {
+		return "", errors.New(res.GetReason())
+	}
+	for _, s := range res.GetScopes() {
+		if s == scope {
+			return res.GetPrincipal(), nil
+		}
+	}
+	return "", errors.New("api key lacks scope " + scope)
+}
+
 func (m *WebSocketManager) CloseConnection(userId string) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
@@ -175,11 +344,11 @@ func (m *WebSocketManager) CloseConnection(userId string) {
 
 	if err := client.Close(); err != nil {
 		m.logger.Error("Error closing WebSocket connection", zap.Error(err))
-		logEvent := map[string]interface{}{
-			"message": "Error closing WebSocket connection",
-			"userId":  userId,
-			"error":   err.Error(),
-			"time":    time.Now().Format(time.RFC3339),
+		logEvent := events.Log{
+			Message: "Error closing WebSocket connection",
+			UserID:  userId,
+			Error:   err.Error(),
+			Time:    time.Now(),
 		}
 		if logErr := m.producer.SendMessageToLogsTopic(userId, logEvent); logErr != nil {
 			m.logger.Error("Failed to send log event", zap.Error(logErr))
@@ -188,12 +357,33 @@ func (m *WebSocketManager) CloseConnection(userId string) {
 
 	delete(m.clients, userId)
 	m.logger.Info("WebSocket connection closed", zap.String("userId", userId))
-	logEvent := map[string]interface{}{
-		"message": "WebSocket connection closed",
-		"userId":  userId,
-		"time":    time.Now().Format(time.RFC3339),
+	logEvent := events.Log{
+		Message: "WebSocket connection closed",
+		UserID:  userId,
+		Time:    time.Now(),
 	}
 	if logErr := m.producer.SendMessageToLogsTopic(userId, logEvent); logErr != nil {
 		m.logger.Error("Failed to send log event", zap.Error(logErr))
 	}
 }
+
+// auditInvalidToken records a rejected websocket token. The claimed user id is
+// read without verification and only serves to tell whose identity was presented.
+func (m *WebSocketManager) auditInvalidToken(c *websocket.Conn, tokenString string, err error) {
+	event := audit.NewEvent(events.AuditTokenInvalid, events.AuditDenied)
+	event.IP, _, _ = net.SplitHostPort(c.RemoteAddr().String())
+	reason := "invalid token"
+	if err != nil {
+		reason = err.Error()
+	}
+	event.Details = map[string]string{"reason": reason, "path": "/ws"}
+	claims := jwt.MapClaims{}
+	if _, _, parseErr := jwt.NewParser().ParseUnverified(tokenString, claims); parseErr == nil {
+		if claimedID, ok := claims["id"].(string); ok {
+			event.TargetID = claimedID
+		}
+	}
+	if logErr := m.producer.SendMessageToAuditTopic(event.TargetID, event); logErr != nil {
+		m.logger.Error("Failed to send audit event", zap.Error(logErr))
+	}
+}
@@ -0,0 +1,77 @@
+// Package outbound makes HTTP requests to URLs that users configure, such as
+// webhook and slash command endpoints, without letting them reach the services
+// next to ours. Addresses are checked when connecting, after DNS resolution, so
+// a name that resolves to an internal address is refused even if it resolved
+// to a public one when the URL was saved.
+package outbound
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for loopback, private, link-local, multicast
+// and unspecified addresses.
+var ErrForbiddenAddress = errors.New("address not allowed")
+
+// Allowed reports whether requests may be sent to ip.
+func Allowed(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// CheckURL validates a URL before it is saved: it must be absolute http or
+// https, and a literal IP or localhost must be allowed.
+func CheckURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, errors.New("url must be an absolute http or https URL")
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return nil, ErrForbiddenAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && !Allowed(ip) {
+		return nil, ErrForbiddenAddress
+	}
+	return u, nil
+}
+
+// NewClient returns a client that refuses to connect to addresses that aren't
+// Allowed and doesn't follow redirects. It ignores proxy settings, as a proxy
+// would connect on its behalf.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !Allowed(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// a redirect counts as a failed request rather than being followed
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
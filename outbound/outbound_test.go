@@ -0,0 +1,54 @@
+package outbound
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllowed(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1", "10.1.2.3", "172.16.0.1", "192.168.1.1",
+		"169.254.169.254", "fe80::1", "fd00::1", "0.0.0.0", "::", "224.0.0.1"} {
+		if Allowed(net.ParseIP(addr)) {
+			t.Errorf("Allowed(%s) = true", addr)
+		}
+	}
+	for _, addr := range []string{"93.184.216.34", "2606:2800:220:1::"} {
+		if !Allowed(net.ParseIP(addr)) {
+			t.Errorf("Allowed(%s) = false", addr)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	for _, raw := range []string{"ftp://example.com", "/hook", "http://localhost:8080/", "http://127.0.0.1/",
+		"http://[::1]/", "http://169.254.169.254/latest/meta-data"} {
+		if _, err := CheckURL(raw); err == nil {
+			t.Errorf("CheckURL(%q) accepted", raw)
+		}
+	}
+	if _, err := CheckURL("https://hooks.example.com/chat"); err != nil {
+		t.Errorf("CheckURL refused a public URL: %v", err)
+	}
+}
+
+func TestClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second).Get(server.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("got %v, want ErrForbiddenAddress", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{}' | openssl dgst -sha256 -hmac secret
+	want := "sha256=b8569b78799ff9e3cbff0fc2d63a33a2b57f3282abd07c37ae5e8e7d79a5f163"
+	if got := Sign("secret", "1700000000", []byte("{}")); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
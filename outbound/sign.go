@@ -0,0 +1,23 @@
+package outbound
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Headers of a signed request. SignatureHeader is Sign of the body with the
+// TimestampHeader value, so receivers can check both and reject replays.
+const (
+	TimestampHeader = "X-ChatApp-Timestamp"
+	SignatureHeader = "X-ChatApp-Signature"
+)
+
+// Sign returns sha256=hex(HMAC-SHA256(secret, timestamp + "." + body)), the
+// signature of outgoing webhook deliveries and slash command invocations.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,49 @@
+// Package slash holds what userservice and notificationservice must agree on
+// about slash commands.
+package slash
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Builtins are the commands notificationservice handles itself. They can't be
+// registered as external commands.
+var Builtins = []string{"help", "me", "mute", "unmute", "invite", "topic", "remind", "schedule"}
+
+// IsBuiltin reports whether name, without the slash, is a built-in command.
+func IsBuiltin(name string) bool {
+	for _, b := range Builtins {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+var errDelay = errors.New("a delay looks like 30m, 2h or 1d")
+
+// ParseDelay reads the delay of /remind and /schedule, which is also accepted
+// by the schedules API: a Go duration such as 90m or 1h30m, or a number of
+// days such as 2d. It must be positive.
+func ParseDelay(s string) (time.Duration, error) {
+	var delay time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errDelay
+		}
+		delay = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if delay, err = time.ParseDuration(s); err != nil {
+			return 0, errDelay
+		}
+	}
+	if delay <= 0 {
+		return 0, errDelay
+	}
+	return delay, nil
+}
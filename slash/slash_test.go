@@ -0,0 +1,25 @@
+package slash
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDelay(t *testing.T) {
+	valid := map[string]time.Duration{
+		"30m":   30 * time.Minute,
+		"1h30m": 90 * time.Minute,
+		"2d":    48 * time.Hour,
+	}
+	for in, want := range valid {
+		got, err := ParseDelay(in)
+		if err != nil || got != want {
+			t.Errorf("ParseDelay(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "d", "0d", "-1h", "0s", "1.5d", "tomorrow"} {
+		if _, err := ParseDelay(in); err == nil {
+			t.Errorf("ParseDelay(%q) accepted an invalid delay", in)
+		}
+	}
+}
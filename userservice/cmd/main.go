@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 	"userservice/config"
 	"userservice/database"
+	"userservice/grpc"
+	"userservice/internal/audit"
 	"userservice/internal/controllers"
 	"userservice/internal/kafka"
 	"userservice/internal/middleware"
+	"userservice/internal/oidc"
 	"userservice/internal/routes"
 	"userservice/internal/utils"
 
@@ -16,20 +24,80 @@ import (
 func main() {
 	logger, _ := zap.NewProduction()
 	defer logger.Sync()
-	producer := kafka.NewProducer(logger)
-	defer producer.Close()
+	msgBroker, err := kafka.Connect()
+	if err != nil {
+		logger.Fatal("Failed to connect to the message broker", zap.Error(err))
+	}
+	producer := kafka.NewProducer(msgBroker, logger)
 	blacklist := utils.NewBlacklist(config.RedisAddr,logger)
-	controllers.InitAuthController(config.GRPCAddress, logger, producer, blacklist)
-	middleware.InitMiddleware(producer, logger)
+	loginGuard := utils.NewLoginGuard(config.RedisAddr,
+		utils.LockoutPolicy{
+			Threshold:   config.MaxFailedLoginsPerAccount,
+			Window:      config.FailedLoginWindow,
+			BaseLockout: config.LoginLockoutBase,
+			MaxLockout:  config.LoginLockoutMax,
+		},
+		utils.LockoutPolicy{
+			Threshold:   config.MaxFailedLoginsPerIP,
+			Window:      config.FailedLoginWindow,
+			BaseLockout: config.LoginLockoutBase,
+			MaxLockout:  config.LoginLockoutMax,
+		},
+		logger)
+	oidcProvider := oidc.NewProvider(oidc.Config{
+		Name:         config.OIDCProviderName,
+		IssuerURL:    config.OIDCIssuerURL,
+		ClientID:     config.OIDCClientID,
+		ClientSecret: config.OIDCClientSecret,
+		RedirectURL:  config.OIDCRedirectURL,
+		StateTTL:     config.OIDCStateTTL,
+		StateSecret:  config.OIDCStateSecret,
+	}, config.RedisAddr, logger)
+	controllers.InitAuthController(config.GRPCAddress, config.WorkerGRPCAddress, logger, producer, blacklist, loginGuard, oidcProvider)
+	middleware.InitMiddleware(blacklist, logger)
+	audit.Init(producer, logger)
 
 	app := fiber.New()
 
 	database.Init(logger)
 
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	go kafka.NewOutboxRelay(producer, logger).Run(relayCtx)
+
 	routes.Setup(app)
 
-	logger.Info("Server started on port " + config.HTTPPort)
-	if err := app.Listen(config.HTTPPort); err != nil {
-		logger.Fatal("Failed to start server", zap.Error(err))
+	lis, err := net.Listen("tcp", config.APIKeyGRPCAddress)
+	if err != nil {
+		logger.Fatal("Failed to listen on port "+config.APIKeyGRPCAddress, zap.Error(err))
+	}
+	grpcServer := grpc.NewGRPCServer(logger)
+	go func() {
+		logger.Info("Starting gRPC server on port " + config.APIKeyGRPCAddress)
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Fatal("Failed to start gRPC server", zap.Error(err))
+		}
+	}()
+
+	go func() {
+		logger.Info("Server started on port " + config.HTTPPort)
+		if err := app.Listen(config.HTTPPort); err != nil {
+			logger.Fatal("Failed to start server", zap.Error(err))
+		}
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigChan
+
+	// stop taking requests first so that nothing is queued after the flush
+	logger.Info("Shutting down...")
+	if err := app.ShutdownWithTimeout(config.ShutdownTimeout); err != nil {
+		logger.Error("Failed to shut down HTTP server", zap.Error(err))
+	}
+	grpcServer.GracefulStop()
+	stopRelay()
+	if err := producer.Close(); err != nil {
+		logger.Error("Failed to close producer", zap.Error(err))
 	}
 }
@@ -0,0 +1,127 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+	"userservice/internal/models"
+)
+
+const selectAPIKey = `SELECT id, name, owner_id, created_by, scopes, key_hash, expires_at, revoked, last_used_at, created_at FROM api_keys`
+
+// ErrAPIKeyNameTaken is returned for a service key named like an unrevoked one,
+// as both would act as the same bot.
+var ErrAPIKeyNameTaken = errors.New("service key name taken")
+
+func CreateAPIKey(key models.APIKey, keyHash string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if key.OwnerID == "" {
+		// an expired key no longer holds on to its name
+		_, err := tx.Exec(rebind("UPDATE api_keys SET revoked = 1 WHERE owner_id = '' AND name = ? AND revoked = 0 AND expires_at <= ?"),
+			key.Name, time.Now().Unix())
+		if err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(rebind(`INSERT INTO api_keys (id, name, owner_id, created_by, scopes, key_hash, expires_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?)`),
+		key.ID, key.Name, key.OwnerID, key.CreatedBy, strings.Join(key.Scopes, ","), keyHash, key.ExpiresAt.Unix())
+	if isUniqueViolation(err) {
+		return ErrAPIKeyNameTaken
+	} else if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetAPIKey returns the key and its hash, or nil when there is no such key.
+func GetAPIKey(id string) (*models.APIKey, string, error) {
+	key, keyHash, err := scanAPIKey(DB.QueryRow(rebind(selectAPIKey+" WHERE id = ?"), id))
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	return &key, keyHash, nil
+}
+
+// GetServiceAPIKey returns the unrevoked service key with the name, or nil.
+func GetServiceAPIKey(name string) (*models.APIKey, error) {
+	key, _, err := scanAPIKey(DB.QueryRow(rebind(selectAPIKey+" WHERE owner_id = '' AND name = ? AND revoked = 0"), name))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// ListAPIKeys returns the keys of one owner, or every key when ownerId is nil.
+func ListAPIKeys(ownerId *string) ([]models.APIKey, error) {
+	query := selectAPIKey
+	var args []interface{}
+	if ownerId != nil {
+		query += " WHERE owner_id = ?"
+		args = append(args, *ownerId)
+	}
+	rows, err := DB.Query(rebind(query+" ORDER BY created_at DESC"), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, _, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func RevokeAPIKey(id string) error {
+	_, err := DB.Exec(rebind("UPDATE api_keys SET revoked = 1 WHERE id = ?"), id)
+	return err
+}
+
+// RevokeUserAPIKeys revokes every key owned by the user and returns how many were active.
+func RevokeUserAPIKeys(userId string) (int64, error) {
+	res, err := DB.Exec(rebind("UPDATE api_keys SET revoked = 1 WHERE owner_id = ? AND revoked = 0"), userId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func TouchAPIKey(id string, usedAt time.Time) error {
+	_, err := DB.Exec(rebind("UPDATE api_keys SET last_used_at = ? WHERE id = ?"), usedAt.Unix(), id)
+	return err
+}
+
+func scanAPIKey(row scanner) (models.APIKey, string, error) {
+	var key models.APIKey
+	var scopes, keyHash string
+	var expiresAt, lastUsedAt int64
+	err := row.Scan(&key.ID, &key.Name, &key.OwnerID, &key.CreatedBy, &scopes, &keyHash, &expiresAt, &key.Revoked, &lastUsedAt, &key.CreatedAt)
+	if err != nil {
+		return key, "", err
+	}
+	key.Scopes = []string{}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	key.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+	if lastUsedAt > 0 {
+		t := time.Unix(lastUsedAt, 0).UTC()
+		key.LastUsedAt = &t
+	}
+	return key, keyHash, nil
+}
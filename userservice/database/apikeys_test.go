@@ -0,0 +1,47 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"userservice/internal/models"
+)
+
+func TestCreateAPIKeyServiceNamesAreUnique(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	DB = db
+
+	key := func(id string, expiresAt time.Time) models.APIKey {
+		return models.APIKey{ID: id, Name: "deploy", CreatedBy: "admin", ExpiresAt: expiresAt}
+	}
+	expired := key("k1", time.Now().Add(-time.Hour))
+	if err := CreateAPIKey(expired, "h1"); err != nil {
+		t.Fatal(err)
+	}
+	// the expired key gives up its name
+	if err := CreateAPIKey(key("k2", time.Now().Add(time.Hour)), "h2"); err != nil {
+		t.Fatalf("reusing the name of an expired key: %v", err)
+	}
+	if err := CreateAPIKey(key("k3", time.Now().Add(time.Hour)), "h3"); err != ErrAPIKeyNameTaken {
+		t.Fatalf("reusing the name of an active key: got %v, want ErrAPIKeyNameTaken", err)
+	}
+
+	// user keys may share names
+	userKey := models.APIKey{ID: "u1", Name: "deploy", OwnerID: "alice", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := CreateAPIKey(userKey, "h4"); err != nil {
+		t.Fatal(err)
+	}
+	userKey.ID = "u2"
+	if err := CreateAPIKey(userKey, "h5"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := RevokeUserAPIKeys("alice"); err != nil || n != 2 {
+		t.Fatalf("RevokeUserAPIKeys = %d, %v; want 2", n, err)
+	}
+	if stored, _, err := GetAPIKey("k2"); err != nil || stored.Revoked {
+		t.Fatalf("service key revoked with the user's keys: %+v, %v", stored, err)
+	}
+}
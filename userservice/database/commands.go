@@ -0,0 +1,64 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"userservice/internal/models"
+)
+
+var ErrCommandExists = errors.New("command already registered")
+
+// CreateCommand returns ErrCommandExists when the name is taken.
+func CreateCommand(cmd models.SlashCommand) error {
+	var exists int
+	err := DB.QueryRow(rebind("SELECT COUNT(*) FROM slash_commands WHERE name = ?"), cmd.Name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if exists > 0 {
+		return ErrCommandExists
+	}
+	_, err = DB.Exec(rebind(`INSERT INTO slash_commands (name, url, secret, description, created_by)
+        VALUES (?, ?, ?, ?, ?)`),
+		cmd.Name, cmd.URL, cmd.Secret, cmd.Description, cmd.CreatedBy)
+	return err
+}
+
+// GetCommandSecret returns the signing secret of a command, or "" when there is no such command.
+func GetCommandSecret(name string) (string, error) {
+	var secret string
+	err := DB.QueryRow(rebind("SELECT secret FROM slash_commands WHERE name = ?"), name).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return secret, err
+}
+
+// ListCommands returns every registered command, secrets included.
+func ListCommands() ([]models.SlashCommand, error) {
+	rows, err := DB.Query("SELECT name, url, secret, description, created_by, created_at FROM slash_commands ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	commands := []models.SlashCommand{}
+	for rows.Next() {
+		var cmd models.SlashCommand
+		if err := rows.Scan(&cmd.Name, &cmd.URL, &cmd.Secret, &cmd.Description, &cmd.CreatedBy, &cmd.CreatedAt); err != nil {
+			return nil, err
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, rows.Err()
+}
+
+// DeleteCommand reports whether the command existed.
+func DeleteCommand(name string) (bool, error) {
+	res, err := DB.Exec(rebind("DELETE FROM slash_commands WHERE name = ?"), name)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
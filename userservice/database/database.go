@@ -2,76 +2,122 @@ package database
 
 import (
 	"database/sql"
-	"fmt"
+	"time"
 	"userservice/config"
-	"userservice/internal/models"
+	"userservice/internal/rbac"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
 )
 
 var DB *sql.DB
+var Users UserRepository
+var Roles RoleRepository
 var logger *zap.Logger
 
+// Init connects to the database selected by config.DBDriver, brings the schema up
+// to date and sets up the repositories.
 func Init(l *zap.Logger) {
 	var err error
 	logger = l
-	DB, err = sql.Open("sqlite3", config.UserDBPath)
+
+	var dsn string
+	switch Dialect(config.DBDriver) {
+	case SQLite:
+		dsn = config.UserDBPath
+	case Postgres:
+		dsn = config.PostgresDSN
+	default:
+		logger.Fatal("Unsupported database driver", zap.String("driver", config.DBDriver))
+	}
+	dialect = Dialect(config.DBDriver)
+
+	DB, err = sql.Open(config.DBDriver, dsn)
 	if err != nil {
 		logger.Fatal("Connecting to DB failed with error", zap.Error(err))
 	}
+	if err := DB.Ping(); err != nil {
+		logger.Fatal("Connecting to DB failed with error", zap.Error(err))
+	}
 
-	createUserTable()
-}
+	if err := migrate(DB); err != nil {
+		logger.Fatal("Failed to migrate database", zap.Error(err))
+	}
+
+	Users = NewUserRepository(DB)
+	Roles = NewRoleRepository(DB)
 
-func createUserTable() {
-	query := `
-    CREATE TABLE IF NOT EXISTS users (id TEXT NOT NULL PRIMARY KEY,email TEXT NOT NULL,password TEXT NOT NULL,
-        role TEXT NOT NULL
-    );
-    `
-	_, err := DB.Exec(query)
+	if config.BootstrapAdminID != "" {
+		if err := Users.UpdateRole(config.BootstrapAdminID, "admin"); err != nil {
+			logger.Error("Failed to promote bootstrap admin", zap.String("userId", config.BootstrapAdminID), zap.Error(err))
+		}
+	}
+	var managers int
+	err = DB.QueryRow(rebind(`SELECT COUNT(*) FROM users u JOIN role_permissions rp ON rp.role = u.role
+        WHERE rp.permission = ?`), rbac.RolesManage).Scan(&managers)
 	if err != nil {
-		logger.Fatal("Failed to create users table", zap.Error(err))
+		logger.Error("Failed to count role managers", zap.Error(err))
+	} else if managers == 0 {
+		logger.Warn("No user can manage roles; set config.BootstrapAdminID to the id of the first admin")
 	}
 }
 
-func CreateUser(user models.User) error {
-	_, err := DB.Exec("INSERT INTO users (id, email, password, role) VALUES (?, ?, ?, ?)", user.ID, user.Email, user.Password, user.Role)
-	return err
+// CreatePasswordReset stores the hash of a reset token; the plain token is only ever sent to the user.
+// CreatePasswordReset stores a reset token and the outbox events announcing it,
+// such as the email with the token, in one transaction.
+func CreatePasswordReset(tokenHash string, userId string, expiresAt time.Time, outbox ...OutboxEvent) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(rebind("INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)"), tokenHash, userId, expiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	if err := insertOutbox(tx, outbox); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func GetUserById(userId string) (*models.User, error) {
-	query := fmt.Sprintf(`SELECT id, email, role FROM users WHERE id = '%s'`, userId)
-	row := DB.QueryRow(query)
+// ResetPassword marks an unused, unexpired reset token as used and sets the
+// password of its user in the same transaction, so a failed update leaves the
+// token usable. It returns the user ID; an empty one means the token is
+// unknown, expired or already used.
+func ResetPassword(tokenHash string, hashedPassword string) (string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
 
-	var user models.User
-	err := row.Scan(&user.ID, &user.Email, &user.Role)
+	var userId string
+	err = tx.QueryRow(rebind("SELECT user_id FROM password_resets WHERE token_hash = ? AND used = 0 AND expires_at > ?"), tokenHash, time.Now().Unix()).Scan(&userId)
 	if err == sql.ErrNoRows {
-		logger.Error("User not found", zap.String("userId", userId))
-		return nil, nil
+		return "", nil
 	} else if err != nil {
-		logger.Error("Failed to get user", zap.String("userId", userId), zap.Error(err))
-		return nil, err
+		return "", err
 	}
 
-	return &user, nil
-}
-
-func GetUsersWithRole(role string) ([]models.User, error) {
-	rows, err := DB.Query("SELECT id, email, role FROM users WHERE role = ?", role)
+	res, err := tx.Exec(rebind("UPDATE password_resets SET used = 1 WHERE token_hash = ? AND used = 0"), tokenHash)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return "", err
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.ID, &user.Email, &user.Role); err != nil {
-			return nil, err
-		}
-		users = append(users, user)
+	// Any other outstanding reset tokens for this user are no longer needed
+	if _, err := tx.Exec(rebind("UPDATE password_resets SET used = 1 WHERE user_id = ?"), userId); err != nil {
+		return "", err
+	}
+	// the token was mailed to the user, so the address is theirs
+	_, err = tx.Exec(rebind("UPDATE users SET password = ?, email_verified = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"), hashedPassword, true, userId)
+	if err != nil {
+		return "", err
 	}
-	return users, nil
+	return userId, tx.Commit()
 }
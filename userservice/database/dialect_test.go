@@ -0,0 +1,29 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	defer func(d Dialect) { dialect = d }(dialect)
+
+	query := "SELECT * FROM users WHERE id = ? AND role IN (?, ?)"
+	dialect = SQLite
+	if got := rebind(query); got != query {
+		t.Errorf("sqlite rebind = %q, want the query unchanged", got)
+	}
+
+	dialect = Postgres
+	tests := map[string]string{
+		query:                         "SELECT * FROM users WHERE id = $1 AND role IN ($2, $3)",
+		"SELECT 1":                    "SELECT 1",
+		"UPDATE t SET a = ?, b = 'é'": "UPDATE t SET a = $1, b = 'é'",
+		strings.Repeat("?", 11):       "$1$2$3$4$5$6$7$8$9$10$11",
+	}
+	for in, want := range tests {
+		if got := rebind(in); got != want {
+			t.Errorf("postgres rebind(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
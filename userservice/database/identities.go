@@ -0,0 +1,26 @@
+package database
+
+import (
+	"database/sql"
+)
+
+// GetIdentityUser returns the id of the user linked to the external identity,
+// or "" if it isn't linked yet.
+func GetIdentityUser(issuer string, subject string) (string, error) {
+	var userId string
+	err := DB.QueryRow(rebind("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?"), issuer, subject).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return userId, err
+}
+
+// LinkIdentity attaches an external identity to a user. An identity can only be
+// linked once; linking it again returns ErrUserExists.
+func LinkIdentity(userId string, issuer string, subject string, email string) error {
+	_, err := DB.Exec(rebind("INSERT INTO user_identities (issuer, subject, user_id, email) VALUES (?, ?, ?, ?)"), issuer, subject, userId, email)
+	if isUniqueViolation(err) {
+		return ErrUserExists
+	}
+	return err
+}
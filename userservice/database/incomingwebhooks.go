@@ -0,0 +1,117 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"userservice/internal/models"
+)
+
+// ErrIncomingWebhookNameTaken is returned for a name already in use, as both
+// webhooks would post as the same hook:<name>.
+var ErrIncomingWebhookNameTaken = errors.New("incoming webhook name taken")
+
+const selectIncomingWebhook = `SELECT id, name, recipients, token_hash, created_by, created_at FROM incoming_webhooks`
+
+func CreateIncomingWebhook(hook models.IncomingWebhook, tokenHash string) error {
+	_, err := DB.Exec(rebind(`INSERT INTO incoming_webhooks (id, name, recipients, token_hash, created_by)
+        VALUES (?, ?, ?, ?, ?)`),
+		hook.ID, hook.Name, strings.Join(hook.Recipients, ","), tokenHash, hook.CreatedBy)
+	if isUniqueViolation(err) {
+		return ErrIncomingWebhookNameTaken
+	}
+	return err
+}
+
+// GetIncomingWebhook returns the webhook and its token hash, or nil when there is no such webhook.
+func GetIncomingWebhook(id string) (*models.IncomingWebhook, string, error) {
+	hook, tokenHash, err := scanIncomingWebhook(DB.QueryRow(rebind(selectIncomingWebhook+" WHERE id = ?"), id))
+	if err == sql.ErrNoRows {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", err
+	}
+	return &hook, tokenHash, nil
+}
+
+func ListIncomingWebhooks() ([]models.IncomingWebhook, error) {
+	rows, err := DB.Query(selectIncomingWebhook + " ORDER BY created_at DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hooks := []models.IncomingWebhook{}
+	for rows.Next() {
+		hook, _, err := scanIncomingWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+// DeleteIncomingWebhook reports whether the webhook existed.
+func DeleteIncomingWebhook(id string) (bool, error) {
+	res, err := DB.Exec(rebind("DELETE FROM incoming_webhooks WHERE id = ?"), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func scanIncomingWebhook(row scanner) (models.IncomingWebhook, string, error) {
+	var hook models.IncomingWebhook
+	var recipients, tokenHash string
+	err := row.Scan(&hook.ID, &hook.Name, &recipients, &tokenHash, &hook.CreatedBy, &hook.CreatedAt)
+	if err != nil {
+		return hook, "", err
+	}
+	hook.Recipients = splitRecipients(recipients)
+	return hook, tokenHash, nil
+}
+
+// removeIncomingWebhookRecipient takes a deleted user off the recipients of
+// every incoming webhook. A webhook left without recipients is kept, and
+// posts to it are refused.
+func removeIncomingWebhookRecipient(tx *sql.Tx, userId string) error {
+	rows, err := tx.Query(rebind("SELECT id, recipients FROM incoming_webhooks WHERE (',' || recipients || ',') LIKE ?"), "%,"+userId+",%")
+	if err != nil {
+		return err
+	}
+	updated := map[string]string{}
+	for rows.Next() {
+		var id, recipients string
+		if err := rows.Scan(&id, &recipients); err != nil {
+			rows.Close()
+			return err
+		}
+		kept := []string{}
+		for _, recipient := range splitRecipients(recipients) {
+			if recipient != userId {
+				kept = append(kept, recipient)
+			}
+		}
+		updated[id] = strings.Join(kept, ",")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, recipients := range updated {
+		if _, err := tx.Exec(rebind("UPDATE incoming_webhooks SET recipients = ? WHERE id = ?"), recipients, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitRecipients(recipients string) []string {
+	if recipients == "" {
+		return []string{}
+	}
+	return strings.Split(recipients, ",")
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+
+	"userservice/internal/models"
+)
+
+func TestDeleteUserLeavesIncomingWebhooks(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	DB = db
+
+	for _, hook := range []models.IncomingWebhook{
+		{ID: "h1", Name: "ci", Recipients: []string{"alice", "bob"}, CreatedBy: "admin"},
+		{ID: "h2", Name: "alerts", Recipients: []string{"bob"}, CreatedBy: "admin"},
+	} {
+		if err := CreateIncomingWebhook(hook, "hash"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dup := models.IncomingWebhook{ID: "h3", Name: "ci", Recipients: []string{"alice"}, CreatedBy: "admin"}
+	if err := CreateIncomingWebhook(dup, "hash"); err != ErrIncomingWebhookNameTaken {
+		t.Fatalf("reusing a name: got %v, want ErrIncomingWebhookNameTaken", err)
+	}
+
+	if err := NewUserRepository(db).DeleteUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]string{"h1": {"alice"}, "h2": {}}
+	for id, recipients := range want {
+		hook, _, err := GetIncomingWebhook(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(hook.Recipients, recipients) {
+			t.Errorf("recipients of %s = %v, want %v", id, hook.Recipients, recipients)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+
+	"go.uber.org/zap"
+)
+
+// migration is one versioned schema change. Versions are applied in order, each
+// in its own transaction, and recorded in schema_migrations. Released migrations
+// must never be edited; add a new one instead.
+type migration struct {
+	version     int
+	description string
+	sqlite      []string
+	postgres    []string
+}
+
+const renameDuplicateEmails = `UPDATE users SET email = email || '+' || id WHERE EXISTS (
+        SELECT 1 FROM users o WHERE lower(o.email) = lower(users.email)
+        AND (o.created_at < users.created_at OR (o.created_at = users.created_at AND o.id < users.id))
+    )`
+
+const revokeDuplicateServiceKeys = `UPDATE api_keys SET revoked = 1 WHERE owner_id = '' AND revoked = 0 AND EXISTS (
+        SELECT 1 FROM api_keys o WHERE o.owner_id = '' AND o.revoked = 0 AND o.name = api_keys.name
+        AND (o.created_at > api_keys.created_at OR (o.created_at = api_keys.created_at AND o.id > api_keys.id))
+    )`
+
+const renameDuplicateIncomingWebhooks = `UPDATE incoming_webhooks SET name = name || '-' || id WHERE EXISTS (
+        SELECT 1 FROM incoming_webhooks o WHERE o.name = incoming_webhooks.name
+        AND (o.created_at < incoming_webhooks.created_at OR (o.created_at = incoming_webhooks.created_at AND o.id < incoming_webhooks.id))
+    )`
+
+var migrations = []migration{
+	{
+		// Matches the tables that were created with CREATE TABLE IF NOT EXISTS before
+		// migrations existed, so existing databases pick up from here unchanged.
+		version:     1,
+		description: "baseline schema",
+		sqlite: []string{
+			`CREATE TABLE IF NOT EXISTS users (id TEXT NOT NULL PRIMARY KEY,email TEXT NOT NULL,password TEXT NOT NULL,
+        role TEXT NOT NULL
+    )`,
+			`CREATE TABLE IF NOT EXISTS password_resets (token_hash TEXT NOT NULL PRIMARY KEY,user_id TEXT NOT NULL,
+        expires_at INTEGER NOT NULL,used INTEGER NOT NULL DEFAULT 0
+    )`,
+			`CREATE TABLE IF NOT EXISTS two_factor (user_id TEXT NOT NULL PRIMARY KEY,secret TEXT NOT NULL,
+        enabled INTEGER NOT NULL DEFAULT 0,last_step INTEGER NOT NULL DEFAULT 0
+    )`,
+			`CREATE TABLE IF NOT EXISTS recovery_codes (user_id TEXT NOT NULL,code_hash TEXT NOT NULL,
+        used INTEGER NOT NULL DEFAULT 0,PRIMARY KEY (user_id, code_hash)
+    )`,
+			`CREATE TABLE IF NOT EXISTS profiles (user_id TEXT NOT NULL PRIMARY KEY,display_name TEXT NOT NULL DEFAULT '',
+        avatar_url TEXT NOT NULL DEFAULT '',status_text TEXT NOT NULL DEFAULT ''
+    )`,
+			`CREATE TABLE IF NOT EXISTS email_changes (token_hash TEXT NOT NULL PRIMARY KEY,user_id TEXT NOT NULL,
+        new_email TEXT NOT NULL,expires_at INTEGER NOT NULL,used INTEGER NOT NULL DEFAULT 0
+    )`,
+		},
+		postgres: []string{
+			`CREATE TABLE IF NOT EXISTS users (id TEXT NOT NULL PRIMARY KEY,email TEXT NOT NULL,password TEXT NOT NULL,
+        role TEXT NOT NULL
+    )`,
+			`CREATE TABLE IF NOT EXISTS password_resets (token_hash TEXT NOT NULL PRIMARY KEY,user_id TEXT NOT NULL,
+        expires_at BIGINT NOT NULL,used INTEGER NOT NULL DEFAULT 0
+    )`,
+			`CREATE TABLE IF NOT EXISTS two_factor (user_id TEXT NOT NULL PRIMARY KEY,secret TEXT NOT NULL,
+        enabled INTEGER NOT NULL DEFAULT 0,last_step BIGINT NOT NULL DEFAULT 0
+    )`,
+			`CREATE TABLE IF NOT EXISTS recovery_codes (user_id TEXT NOT NULL,code_hash TEXT NOT NULL,
+        used INTEGER NOT NULL DEFAULT 0,PRIMARY KEY (user_id, code_hash)
+    )`,
+			`CREATE TABLE IF NOT EXISTS profiles (user_id TEXT NOT NULL PRIMARY KEY,display_name TEXT NOT NULL DEFAULT '',
+        avatar_url TEXT NOT NULL DEFAULT '',status_text TEXT NOT NULL DEFAULT ''
+    )`,
+			`CREATE TABLE IF NOT EXISTS email_changes (token_hash TEXT NOT NULL PRIMARY KEY,user_id TEXT NOT NULL,
+        new_email TEXT NOT NULL,expires_at BIGINT NOT NULL,used INTEGER NOT NULL DEFAULT 0
+    )`,
+		},
+	},
+	{
+		// SQLite can't add a column with a non-constant default, so the table is rebuilt.
+		version:     2,
+		description: "add created_at and updated_at to users",
+		sqlite: []string{
+			`CREATE TABLE users_new (id TEXT NOT NULL PRIMARY KEY,email TEXT NOT NULL,password TEXT NOT NULL,
+        role TEXT NOT NULL,created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+			`INSERT INTO users_new (id, email, password, role) SELECT id, email, password, role FROM users`,
+			`DROP TABLE users`,
+			`ALTER TABLE users_new RENAME TO users`,
+		},
+		postgres: []string{
+			`ALTER TABLE users ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+			`ALTER TABLE users ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now()`,
+		},
+	},
+	{
+		// Emails used to be compared case sensitively and not checked for duplicates.
+		// The oldest account keeps each address; the others get "+<id>" appended so
+		// they can be found and given a real address by an admin.
+		version:     3,
+		description: "unique email",
+		sqlite: []string{
+			renameDuplicateEmails,
+			`CREATE UNIQUE INDEX users_email_key ON users (lower(email))`,
+		},
+		postgres: []string{
+			renameDuplicateEmails,
+			`CREATE UNIQUE INDEX users_email_key ON users (lower(email))`,
+		},
+	},
+	{
+		version:     4,
+		description: "roles and permissions",
+		sqlite: []string{
+			`CREATE TABLE roles (name TEXT NOT NULL PRIMARY KEY,description TEXT NOT NULL DEFAULT '')`,
+			`CREATE TABLE role_permissions (role TEXT NOT NULL,permission TEXT NOT NULL,PRIMARY KEY (role, permission))`,
+			`INSERT INTO roles (name, description) VALUES
+        ('user', 'Regular chat user'),
+        ('moderator', 'Can look up users'),
+        ('admin', 'Full access')`,
+			`INSERT INTO role_permissions (role, permission) VALUES
+        ('user', 'profile:read'),
+        ('moderator', 'profile:read'),('moderator', 'users:read'),
+        ('admin', 'profile:read'),('admin', 'users:read'),('admin', 'users:manage'),
+        ('admin', 'roles:manage'),('admin', 'logs:read')`,
+			// Roles used to be chosen by the client at registration, so none of them can
+			// be trusted. config.BootstrapAdminID makes the first admin.
+			`UPDATE users SET role = 'user' WHERE role <> 'user'`,
+		},
+		postgres: []string{
+			`CREATE TABLE roles (name TEXT NOT NULL PRIMARY KEY,description TEXT NOT NULL DEFAULT '')`,
+			`CREATE TABLE role_permissions (role TEXT NOT NULL,permission TEXT NOT NULL,PRIMARY KEY (role, permission))`,
+			`INSERT INTO roles (name, description) VALUES
+        ('user', 'Regular chat user'),
+        ('moderator', 'Can look up users'),
+        ('admin', 'Full access')`,
+			`INSERT INTO role_permissions (role, permission) VALUES
+        ('user', 'profile:read'),
+        ('moderator', 'profile:read'),('moderator', 'users:read'),
+        ('admin', 'profile:read'),('admin', 'users:read'),('admin', 'users:manage'),
+        ('admin', 'roles:manage'),('admin', 'logs:read')`,
+			// Roles used to be chosen by the client at registration, so none of them can
+			// be trusted. config.BootstrapAdminID makes the first admin.
+			`UPDATE users SET role = 'user' WHERE role <> 'user'`,
+		},
+	},
+	{
+		version:     5,
+		description: "suspended users",
+		sqlite: []string{
+			`ALTER TABLE users ADD COLUMN suspended INTEGER NOT NULL DEFAULT 0`,
+		},
+		postgres: []string{
+			`ALTER TABLE users ADD COLUMN suspended BOOLEAN NOT NULL DEFAULT false`,
+		},
+	},
+	{
+		version:     6,
+		description: "external identities",
+		sqlite: []string{
+			`CREATE TABLE user_identities (issuer TEXT NOT NULL,subject TEXT NOT NULL,user_id TEXT NOT NULL,
+        email TEXT NOT NULL DEFAULT '',created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (issuer, subject)
+    )`,
+			`CREATE INDEX idx_user_identities_user_id ON user_identities (user_id)`,
+		},
+		postgres: []string{
+			`CREATE TABLE user_identities (issuer TEXT NOT NULL,subject TEXT NOT NULL,user_id TEXT NOT NULL,
+        email TEXT NOT NULL DEFAULT '',created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        PRIMARY KEY (issuer, subject)
+    )`,
+			`CREATE INDEX idx_user_identities_user_id ON user_identities (user_id)`,
+		},
+	},
+	{
+		version:     7,
+		description: "api keys",
+		sqlite: []string{
+			`CREATE TABLE api_keys (id TEXT NOT NULL PRIMARY KEY,name TEXT NOT NULL,owner_id TEXT NOT NULL DEFAULT '',
+        created_by TEXT NOT NULL,scopes TEXT NOT NULL,key_hash TEXT NOT NULL,expires_at INTEGER NOT NULL,
+        revoked INTEGER NOT NULL DEFAULT 0,last_used_at INTEGER NOT NULL DEFAULT 0,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+			`CREATE INDEX idx_api_keys_owner_id ON api_keys (owner_id)`,
+			`INSERT INTO role_permissions (role, permission) VALUES
+        ('user', 'messages:send'),('moderator', 'messages:send'),('admin', 'messages:send'),('admin', 'apikeys:manage')`,
+		},
+		postgres: []string{
+			`CREATE TABLE api_keys (id TEXT NOT NULL PRIMARY KEY,name TEXT NOT NULL,owner_id TEXT NOT NULL DEFAULT '',
+        created_by TEXT NOT NULL,scopes TEXT NOT NULL,key_hash TEXT NOT NULL,expires_at BIGINT NOT NULL,
+        revoked INTEGER NOT NULL DEFAULT 0,last_used_at BIGINT NOT NULL DEFAULT 0,
+        created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+			`CREATE INDEX idx_api_keys_owner_id ON api_keys (owner_id)`,
+			`INSERT INTO role_permissions (role, permission) VALUES
+        ('user', 'messages:send'),('moderator', 'messages:send'),('admin', 'messages:send'),('admin', 'apikeys:manage')`,
+		},
+	},
+	{
+		version:     8,
+		description: "webhook management permission",
+		sqlite: []string{
+			`INSERT INTO role_permissions (role, permission) VALUES ('admin', 'webhooks:manage')`,
+		},
+		postgres: []string{
+			`INSERT INTO role_permissions (role, permission) VALUES ('admin', 'webhooks:manage')`,
+		},
+	},
+	{
+		version:     9,
+		description: "incoming webhooks",
+		sqlite: []string{
+			`CREATE TABLE incoming_webhooks (id TEXT NOT NULL PRIMARY KEY,name TEXT NOT NULL,recipients TEXT NOT NULL,
+        token_hash TEXT NOT NULL,created_by TEXT NOT NULL,created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+		},
+		postgres: []string{
+			`CREATE TABLE incoming_webhooks (id TEXT NOT NULL PRIMARY KEY,name TEXT NOT NULL,recipients TEXT NOT NULL,
+        token_hash TEXT NOT NULL,created_by TEXT NOT NULL,created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+		},
+	},
+	{
+		version:     10,
+		description: "slash commands",
+		sqlite: []string{
+			`CREATE TABLE slash_commands (name TEXT NOT NULL PRIMARY KEY,url TEXT NOT NULL,secret TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',created_by TEXT NOT NULL,created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+			`INSERT INTO role_permissions (role, permission) VALUES ('admin', 'commands:manage')`,
+		},
+		postgres: []string{
+			`CREATE TABLE slash_commands (name TEXT NOT NULL PRIMARY KEY,url TEXT NOT NULL,secret TEXT NOT NULL,
+        description TEXT NOT NULL DEFAULT '',created_by TEXT NOT NULL,created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+			`INSERT INTO role_permissions (role, permission) VALUES ('admin', 'commands:manage')`,
+		},
+	},
+	{
+		version:     11,
+		description: "event outbox",
+		sqlite: []string{
+			`CREATE TABLE outbox (id INTEGER PRIMARY KEY AUTOINCREMENT,topic TEXT NOT NULL,msg_key TEXT NOT NULL,
+        payload BLOB NOT NULL,event_id TEXT NOT NULL,event_type TEXT NOT NULL DEFAULT '',schema_version INTEGER NOT NULL DEFAULT 0,
+        attempts INTEGER NOT NULL DEFAULT 0,last_error TEXT NOT NULL DEFAULT '',created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        sent_at TIMESTAMP
+    )`,
+			`CREATE INDEX idx_outbox_pending ON outbox (sent_at, id)`,
+		},
+		postgres: []string{
+			`CREATE TABLE outbox (id BIGSERIAL PRIMARY KEY,topic TEXT NOT NULL,msg_key TEXT NOT NULL,
+        payload BYTEA NOT NULL,event_id TEXT NOT NULL,event_type TEXT NOT NULL DEFAULT '',schema_version INTEGER NOT NULL DEFAULT 0,
+        attempts INTEGER NOT NULL DEFAULT 0,last_error TEXT NOT NULL DEFAULT '',created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+        sent_at TIMESTAMP
+    )`,
+			`CREATE INDEX idx_outbox_pending ON outbox (sent_at, id)`,
+		},
+	},
+	{
+		// Set once the user has shown they receive mail at the address; identities
+		// are only linked by email to verified addresses
+		version:     12,
+		description: "verified emails",
+		sqlite: []string{
+			`ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0`,
+		},
+		postgres: []string{
+			`ALTER TABLE users ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT false`,
+		},
+	},
+	{
+		// A service key acts as bot:<name>, so two active ones can't share a name.
+		// Of existing duplicates only the newest stays active.
+		version:     13,
+		description: "unique service key names",
+		sqlite: []string{
+			revokeDuplicateServiceKeys,
+			`CREATE UNIQUE INDEX api_keys_service_name_key ON api_keys (name) WHERE owner_id = '' AND revoked = 0`,
+		},
+		postgres: []string{
+			revokeDuplicateServiceKeys,
+			`CREATE UNIQUE INDEX api_keys_service_name_key ON api_keys (name) WHERE owner_id = '' AND revoked = 0`,
+		},
+	},
+	{
+		// An incoming webhook posts as hook:<name>. Of existing duplicates the
+		// oldest keeps its name and the others become <name>-<id>.
+		version:     14,
+		description: "unique incoming webhook names",
+		sqlite: []string{
+			renameDuplicateIncomingWebhooks,
+			`CREATE UNIQUE INDEX incoming_webhooks_name_key ON incoming_webhooks (name)`,
+		},
+		postgres: []string{
+			renameDuplicateIncomingWebhooks,
+			`CREATE UNIQUE INDEX incoming_webhooks_name_key ON incoming_webhooks (name)`,
+		},
+	},
+	{
+		// Events that fail too often are failed instead of blocking the outbox
+		// (until version 16), and a relay claims the events it publishes so that replicas don't all
+		// publish every one
+		version:     15,
+		description: "outbox status and claims",
+		sqlite: []string{
+			`ALTER TABLE outbox ADD COLUMN status TEXT NOT NULL DEFAULT 'pending'`,
+			`UPDATE outbox SET status = 'sent' WHERE sent_at IS NOT NULL`,
+			`ALTER TABLE outbox ADD COLUMN claimed_by TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE outbox ADD COLUMN claimed_until INTEGER NOT NULL DEFAULT 0`,
+			`DROP INDEX idx_outbox_pending`,
+			`CREATE INDEX idx_outbox_pending ON outbox (status, id)`,
+		},
+		postgres: []string{
+			`ALTER TABLE outbox ADD COLUMN status TEXT NOT NULL DEFAULT 'pending'`,
+			`UPDATE outbox SET status = 'sent' WHERE sent_at IS NOT NULL`,
+			`ALTER TABLE outbox ADD COLUMN claimed_by TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE outbox ADD COLUMN claimed_until BIGINT NOT NULL DEFAULT 0`,
+			`DROP INDEX idx_outbox_pending`,
+			`CREATE INDEX idx_outbox_pending ON outbox (status, id)`,
+		},
+	},
+	{
+		// Events are no longer given up on after a number of attempts, so that a
+		// long broker outage doesn't lose them
+		version:     16,
+		description: "requeue failed outbox events",
+		sqlite:      []string{`UPDATE outbox SET status = 'pending' WHERE status = 'failed'`},
+		postgres:    []string{`UPDATE outbox SET status = 'pending' WHERE status = 'failed'`},
+	},
+}
+
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY,
+        description TEXT NOT NULL,applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := db.QueryRow("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := applyMigration(db, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.version, m.description, err)
+		}
+		logger.Info("Applied database migration", zap.Int("version", m.version), zap.String("description", m.description))
+	}
+	return nil
+}
+
+func applyMigration(db *sql.DB, m migration) error {
+	statements := m.sqlite
+	if dialect == Postgres {
+		statements = m.postgres
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(rebind("INSERT INTO schema_migrations (version, description) VALUES (?, ?)"), m.version, m.description); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
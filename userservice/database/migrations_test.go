@@ -0,0 +1,76 @@
+package database
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	logger = zap.NewNop()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrateRenamesDuplicateEmails(t *testing.T) {
+	db := openTestDB(t)
+	// a database from before migrations, with emails differing only in case
+	_, err := db.Exec(migrations[0].sqlite[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`INSERT INTO users (id, email, password, role) VALUES
+        ('user2', 'Alice@example.com', 'x', 'user'),
+        ('user1', 'alice@example.com', 'x', 'user'),
+        ('user3', 'bob@example.com', 'x', 'user')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	want := map[string]string{
+		"user1": "alice@example.com",
+		"user2": "Alice@example.com+user2",
+		"user3": "bob@example.com",
+	}
+	for id, email := range want {
+		var got string
+		if err := db.QueryRow("SELECT email FROM users WHERE id = ?", id).Scan(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got != email {
+			t.Errorf("email of %s = %q, want %q", id, got, email)
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO users (id, email, password, role) VALUES ('user4', 'ALICE@example.com', 'x', 'user')")
+	if !isUniqueViolation(err) {
+		t.Errorf("inserting a duplicate email: got %v, want a unique violation", err)
+	}
+}
+
+func TestMigrateIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+	for i := 0; i < 2; i++ {
+		if err := migrate(db); err != nil {
+			t.Fatalf("migrate run %d: %v", i+1, err)
+		}
+	}
+	var version int
+	if err := db.QueryRow("SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if want := migrations[len(migrations)-1].version; version != want {
+		t.Errorf("schema version = %d, want %d", version, want)
+	}
+}
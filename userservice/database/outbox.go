@@ -0,0 +1,143 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"chatapp/events"
+)
+
+// OutboxEvent is a Kafka message stored in the same transaction as the change it
+// announces. The relay in internal/kafka publishes it once the transaction has
+// committed, so an event is never lost when Kafka is down and never sent for a
+// change that was rolled back.
+type OutboxEvent struct {
+	ID            int64
+	Topic         string
+	Key           string
+	Payload       []byte
+	EventID       string
+	EventType     string
+	SchemaVersion int
+	Attempts      int
+	CreatedAt     time.Time
+}
+
+// NewOutboxEvent encodes value for topic. The event ID is fixed here so that a
+// message the relay publishes twice is still recognised as one by workerservice.
+func NewOutboxEvent(topic string, key string, value interface{}) (OutboxEvent, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	eventID, err := events.NewEventID()
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	event := OutboxEvent{Topic: topic, Key: key, Payload: payload, EventID: eventID}
+	if e, ok := value.(events.Event); ok {
+		event.EventType = e.EventType()
+		event.SchemaVersion = e.SchemaVersion()
+	}
+	return event, nil
+}
+
+func insertOutbox(tx *sql.Tx, outbox []OutboxEvent) error {
+	for _, e := range outbox {
+		_, err := tx.Exec(rebind(`INSERT INTO outbox (topic, msg_key, payload, event_id, event_type, schema_version)
+        VALUES (?, ?, ?, ?, ?, ?)`),
+			e.Topic, e.Key, e.Payload, e.EventID, e.EventType, e.SchemaVersion)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Outbox event states. An event stays pending, however often publishing it
+// fails, until it is sent.
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+)
+
+// ClaimOutbox claims up to limit pending events, oldest first, for the relay
+// until the lease runs out and returns them. Nothing is claimed while another
+// relay holds a claim, so only one relay publishes at a time and events for the
+// same key stay in order; a relay that stops or dies is taken over once its
+// lease has run out. A relay renews its claims by claiming again.
+func ClaimOutbox(relay string, limit int, lease time.Duration) ([]OutboxEvent, error) {
+	now := time.Now()
+	_, err := DB.Exec(rebind(`UPDATE outbox SET claimed_by = ?, claimed_until = ?
+        WHERE status = ? AND (claimed_by = ? OR claimed_until <= ?)
+        AND id IN (SELECT id FROM outbox WHERE status = ? ORDER BY id LIMIT ?)
+        AND NOT EXISTS (SELECT 1 FROM outbox o WHERE o.status = ? AND o.claimed_by <> ? AND o.claimed_until > ?)`),
+		relay, now.Add(lease).Unix(), OutboxPending, relay, now.Unix(), OutboxPending, limit, OutboxPending, relay, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := DB.Query(rebind(`SELECT id, topic, msg_key, payload, event_id, event_type, schema_version, attempts, created_at
+        FROM outbox WHERE status = ? AND claimed_by = ? ORDER BY id LIMIT ?`), OutboxPending, relay, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var claimed []OutboxEvent
+	for rows.Next() {
+		var e OutboxEvent
+		if err := rows.Scan(&e.ID, &e.Topic, &e.Key, &e.Payload, &e.EventID, &e.EventType, &e.SchemaVersion, &e.Attempts, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		claimed = append(claimed, e)
+	}
+	return claimed, rows.Err()
+}
+
+// ReleaseOutbox gives up the relay's claims, so that another relay can take
+// over without waiting for the lease.
+func ReleaseOutbox(relay string) error {
+	_, err := DB.Exec(rebind("UPDATE outbox SET claimed_until = 0 WHERE claimed_by = ? AND status = ?"), relay, OutboxPending)
+	return err
+}
+
+// MarkOutboxSent records a published event. Its payload is dropped, as it can
+// hold one-time tokens and nothing reads it again.
+func MarkOutboxSent(id int64) error {
+	_, err := DB.Exec(rebind(`UPDATE outbox SET status = ?, sent_at = CURRENT_TIMESTAMP, attempts = attempts + 1, last_error = '',
+        payload = ? WHERE id = ?`), OutboxSent, []byte{}, id)
+	return err
+}
+
+// MarkOutboxFailed records a failed publish. The event stays pending, so that
+// it is sent once the broker is back however long it was down.
+func MarkOutboxFailed(id int64, errMsg string) error {
+	_, err := DB.Exec(rebind("UPDATE outbox SET attempts = attempts + 1, last_error = ? WHERE id = ?"), errMsg, id)
+	return err
+}
+
+// OutboxBacklog returns how many events are pending and when the oldest of
+// them was written, the zero time when there is none.
+func OutboxBacklog() (int64, time.Time, error) {
+	var pending int64
+	if err := DB.QueryRow(rebind("SELECT COUNT(*) FROM outbox WHERE status = ?"), OutboxPending).Scan(&pending); err != nil {
+		return 0, time.Time{}, err
+	}
+	var oldest time.Time
+	err := DB.QueryRow(rebind("SELECT created_at FROM outbox WHERE status = ? ORDER BY id LIMIT 1"), OutboxPending).Scan(&oldest)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, time.Time{}, err
+	}
+	return pending, oldest, nil
+}
+
+// PruneOutbox deletes events that were sent before the given time.
+func PruneOutbox(before time.Time) (int64, error) {
+	res, err := DB.Exec(rebind("DELETE FROM outbox WHERE sent_at IS NOT NULL AND sent_at < ?"), before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"chatapp/events"
+)
+
+func TestOutboxClaimsAndFailures(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	DB = db
+
+	var outbox []OutboxEvent
+	for _, to := range []string{"a@b.c", "d@e.f"} {
+		e, err := NewOutboxEvent("email", to, events.Email{Type: events.EmailPasswordReset, UserID: "alice", To: to, Token: "secret"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		outbox = append(outbox, e)
+	}
+	if err := CreatePasswordReset("hash", "alice", time.Now().Add(time.Hour), outbox...); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed, err := ClaimOutbox("relay-1", 10, time.Minute)
+	if err != nil || len(claimed) != 2 {
+		t.Fatalf("first relay claimed %d events, err %v", len(claimed), err)
+	}
+	// the second relay waits while the first holds claims
+	if other, err := ClaimOutbox("relay-2", 10, time.Minute); err != nil || len(other) != 0 {
+		t.Fatalf("second relay claimed %d events, err %v", len(other), err)
+	}
+
+	if err := MarkOutboxSent(claimed[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	var payload []byte
+	var status string
+	if err := db.QueryRow("SELECT payload, status FROM outbox WHERE id = ?", claimed[0].ID).Scan(&payload, &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(payload) != 0 || status != OutboxSent {
+		t.Fatalf("sent event has status %s and payload %q", status, payload)
+	}
+
+	// a failing event stays pending, however often it fails
+	for i := 0; i < 50; i++ {
+		if err := MarkOutboxFailed(claimed[1].ID, "broker down"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var attempts int
+	if err := db.QueryRow("SELECT status, attempts FROM outbox WHERE id = ?", claimed[1].ID).Scan(&status, &attempts); err != nil {
+		t.Fatal(err)
+	}
+	if status != OutboxPending || attempts != 50 {
+		t.Fatalf("failing event has status %s after %d attempts", status, attempts)
+	}
+	pending, oldest, err := OutboxBacklog()
+	if err != nil || pending != 1 || oldest.IsZero() {
+		t.Fatalf("OutboxBacklog = %d, %v, %v", pending, oldest, err)
+	}
+	if left, err := ClaimOutbox("relay-1", 10, time.Minute); err != nil || len(left) != 1 || left[0].ID != claimed[1].ID {
+		t.Fatalf("claimed %v after the failures, err %v", left, err)
+	}
+	if err := MarkOutboxSent(claimed[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if pending, oldest, err := OutboxBacklog(); err != nil || pending != 0 || !oldest.IsZero() {
+		t.Fatalf("OutboxBacklog once all were sent = %d, %v, %v", pending, oldest, err)
+	}
+
+	// released claims are taken over right away
+	e, err := NewOutboxEvent("users", "alice", map[string]string{"type": "user.registered"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CreateEmailChange("hash2", "alice", "new@b.c", time.Now().Add(time.Hour), e); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := ClaimOutbox("relay-1", 10, time.Minute); err != nil || len(claimed) != 1 {
+		t.Fatalf("first relay claimed %d events, err %v", len(claimed), err)
+	}
+	if err := ReleaseOutbox("relay-1"); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, err := ClaimOutbox("relay-2", 10, time.Minute); err != nil || len(claimed) != 1 {
+		t.Fatalf("second relay claimed %d released events, err %v", len(claimed), err)
+	}
+}
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CreateEmailChange stores a pending email change until the new address is verified.
+// CreateEmailChange stores a pending email change and the outbox events
+// announcing it, such as the verification email, in one transaction.
+func CreateEmailChange(tokenHash string, userId string, newEmail string, expiresAt time.Time, outbox ...OutboxEvent) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(rebind("INSERT INTO email_changes (token_hash, user_id, new_email, expires_at) VALUES (?, ?, ?, ?)"), tokenHash, userId, newEmail, expiresAt.Unix())
+	if err != nil {
+		return err
+	}
+	if err := insertOutbox(tx, outbox); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConfirmEmailChange applies the pending change for an unused, unexpired token and
+// returns the user ID and new email. An empty user ID means the token is not valid.
+func ConfirmEmailChange(tokenHash string) (string, string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+
+	var userId, newEmail string
+	err = tx.QueryRow(rebind("SELECT user_id, new_email FROM email_changes WHERE token_hash = ? AND used = 0 AND expires_at > ?"), tokenHash, time.Now().Unix()).Scan(&userId, &newEmail)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+
+	if _, err := tx.Exec(rebind("UPDATE email_changes SET used = 1 WHERE user_id = ?"), userId); err != nil {
+		return "", "", err
+	}
+	res, err := tx.Exec(rebind("UPDATE users SET email = ?, email_verified = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"), newEmail, true, userId)
+	if isUniqueViolation(err) {
+		return "", "", ErrUserExists
+	} else if err != nil {
+		return "", "", err
+	}
+	if n, err := res.RowsAffected(); err != nil || n != 1 {
+		return "", "", err
+	}
+	return userId, newEmail, tx.Commit()
+}
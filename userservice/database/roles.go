@@ -0,0 +1,115 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+)
+
+// ErrLastRoleManager is returned when saving a role would leave no role that can manage roles.
+var ErrLastRoleManager = errors.New("no other role can manage roles")
+
+// RoleRepository stores roles and the permissions they grant.
+type RoleRepository interface {
+	// PermissionsForUser resolves the permissions of the user's current role.
+	PermissionsForUser(userId string) ([]string, error)
+	ListRoles() ([]models.Role, error)
+	RoleExists(name string) (bool, error)
+	// SaveRole creates the role or replaces its description and permissions. It
+	// returns ErrLastRoleManager instead of removing roles:manage from the last
+	// role that has it.
+	SaveRole(role models.Role) error
+}
+
+type sqlRoleRepository struct {
+	db *sql.DB
+}
+
+func NewRoleRepository(db *sql.DB) RoleRepository {
+	return &sqlRoleRepository{db: db}
+}
+
+func (r *sqlRoleRepository) PermissionsForUser(userId string) ([]string, error) {
+	rows, err := r.db.Query(rebind(`SELECT rp.permission FROM users u
+        JOIN role_permissions rp ON rp.role = u.role WHERE u.id = ?`), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []string
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	return permissions, rows.Err()
+}
+
+func (r *sqlRoleRepository) ListRoles() ([]models.Role, error) {
+	rows, err := r.db.Query(`SELECT r.name, r.description, COALESCE(rp.permission, '') FROM roles r
+        LEFT JOIN role_permissions rp ON rp.role = r.name ORDER BY r.name, rp.permission`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var roles []models.Role
+	for rows.Next() {
+		var name, description, permission string
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			return nil, err
+		}
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, models.Role{Name: name, Description: description, Permissions: []string{}})
+		}
+		if permission != "" {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission)
+		}
+	}
+	return roles, rows.Err()
+}
+
+func (r *sqlRoleRepository) RoleExists(name string) (bool, error) {
+	var count int
+	err := r.db.QueryRow(rebind("SELECT COUNT(*) FROM roles WHERE name = ?"), name).Scan(&count)
+	return count > 0, err
+}
+
+func (r *sqlRoleRepository) SaveRole(role models.Role) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(rebind(`INSERT INTO roles (name, description) VALUES (?, ?)
+        ON CONFLICT(name) DO UPDATE SET description = excluded.description`), role.Name, role.Description)
+	if err != nil {
+		return err
+	}
+	if !rbac.Has(role.Permissions, rbac.RolesManage) {
+		var others int
+		err := tx.QueryRow(rebind("SELECT COUNT(*) FROM role_permissions WHERE permission = ? AND role <> ?"),
+			rbac.RolesManage, role.Name).Scan(&others)
+		if err != nil {
+			return err
+		}
+		if others == 0 {
+			return ErrLastRoleManager
+		}
+	}
+	if _, err := tx.Exec(rebind("DELETE FROM role_permissions WHERE role = ?"), role.Name); err != nil {
+		return err
+	}
+	for _, permission := range role.Permissions {
+		if _, err := tx.Exec(rebind("INSERT INTO role_permissions (role, permission) VALUES (?, ?)"), role.Name, permission); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
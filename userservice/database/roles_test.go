@@ -0,0 +1,54 @@
+package database
+
+import (
+	"testing"
+
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+)
+
+func TestSaveRoleKeepsARoleManager(t *testing.T) {
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	roles := NewRoleRepository(db)
+
+	admin := models.Role{Name: "admin", Permissions: []string{rbac.UsersRead}}
+	if err := roles.SaveRole(admin); err != ErrLastRoleManager {
+		t.Fatalf("removing roles:manage from the only role: got %v, want ErrLastRoleManager", err)
+	}
+
+	owner := models.Role{Name: "owner", Permissions: []string{rbac.RolesManage}}
+	if err := roles.SaveRole(owner); err != nil {
+		t.Fatal(err)
+	}
+	if err := roles.SaveRole(admin); err != nil {
+		t.Fatalf("removing roles:manage while another role has it: %v", err)
+	}
+	if err := roles.SaveRole(models.Role{Name: "owner"}); err != ErrLastRoleManager {
+		t.Fatalf("removing roles:manage from the last role: got %v, want ErrLastRoleManager", err)
+	}
+}
+
+func TestMigrateDemotesClientChosenRoles(t *testing.T) {
+	db := openTestDB(t)
+	if _, err := db.Exec(migrations[0].sqlite[0]); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.Exec(`INSERT INTO users (id, email, password, role) VALUES
+        ('a', 'a@example.com', 'x', 'admin'),('b', 'b@example.com', 'x', 'moderator'),('c', 'c@example.com', 'x', 'root')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	var elevated int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE role <> 'user'").Scan(&elevated); err != nil {
+		t.Fatal(err)
+	}
+	if elevated != 0 {
+		t.Errorf("%d users kept a role other than user", elevated)
+	}
+}
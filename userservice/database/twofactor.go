@@ -0,0 +1,95 @@
+package database
+
+import (
+	"database/sql"
+)
+
+type TwoFactor struct {
+	UserID   string
+	Secret   string
+	Enabled  bool
+	LastStep int64
+}
+
+func GetTwoFactor(userId string) (*TwoFactor, error) {
+	var tf TwoFactor
+	err := DB.QueryRow(rebind("SELECT user_id, secret, enabled, last_step FROM two_factor WHERE user_id = ?"), userId).
+		Scan(&tf.UserID, &tf.Secret, &tf.Enabled, &tf.LastStep)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// SaveTwoFactorSecret starts (or restarts) enrollment with a new, not yet enabled secret.
+// It refuses to touch a user who already has 2FA enabled.
+func SaveTwoFactorSecret(userId string, secret string) (bool, error) {
+	res, err := DB.Exec(rebind(`INSERT INTO two_factor (user_id, secret) VALUES (?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_step = 0 WHERE two_factor.enabled = 0`), userId, secret)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// EnableTwoFactor activates 2FA and replaces the user's recovery codes in one transaction.
+func EnableTwoFactor(userId string, step int64, codeHashes []string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rebind("UPDATE two_factor SET enabled = 1, last_step = ? WHERE user_id = ?"), step, userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userId); err != nil {
+		return err
+	}
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(rebind("INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)"), userId, hash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func DisableTwoFactor(userId string) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(rebind("DELETE FROM two_factor WHERE user_id = ?"), userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTwoFactorStep records a successfully used TOTP step. It returns false when the
+// step is not newer than the last one used, i.e. the code is being replayed.
+func UseTwoFactorStep(userId string, step int64) (bool, error) {
+	res, err := DB.Exec(rebind("UPDATE two_factor SET last_step = ? WHERE user_id = ? AND last_step < ?"), step, userId, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode burns a recovery code, returning false if it is unknown or already used.
+func UseRecoveryCode(userId string, codeHash string) (bool, error) {
+	res, err := DB.Exec(rebind("UPDATE recovery_codes SET used = 1 WHERE user_id = ? AND code_hash = ? AND used = 0"), userId, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
@@ -0,0 +1,290 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+
+	"go.uber.org/zap"
+)
+
+// ErrUserExists is returned by CreateUser when the ID or email is already taken.
+var ErrUserExists = errors.New("user already exists")
+
+// ErrLastUserManager is returned instead of demoting or deleting the last user who can manage roles.
+var ErrLastUserManager = errors.New("no other user can manage roles")
+
+// UserRepository is the store for user accounts and their profiles.
+type UserRepository interface {
+	// CreateUser stores the user and the outbox events announcing it in one transaction.
+	CreateUser(user models.User, outbox ...OutboxEvent) error
+	// GetUserById returns nil, nil when the user doesn't exist. The password hash is not loaded.
+	GetUserById(userId string) (*models.User, error)
+	// GetUserWithPassword is GetUserById including the password hash, for credential checks.
+	GetUserWithPassword(userId string) (*models.User, error)
+	// GetUserByEmail matches the email case insensitively and returns nil, nil when there is no such user.
+	GetUserByEmail(email string) (*models.User, error)
+	GetUsersWithRole(role string) ([]models.User, error)
+	// ListUsers returns one page of users matching the filter and the total number of matches.
+	ListUsers(filter models.UserFilter) ([]models.User, int, error)
+	UpdatePassword(userId string, hashedPassword string) error
+	// UpdateRole returns ErrLastUserManager instead of taking roles:manage from
+	// the last user who has it.
+	UpdateRole(userId string, role string) error
+	SetSuspended(userId string, suspended bool) error
+	// UpdateProfile sets the given profile fields, leaving nil ones unchanged.
+	UpdateProfile(userId string, displayName, avatarURL, statusText *string) error
+	// DeleteUser removes the user and everything stored for them. It returns
+	// ErrLastUserManager instead of removing the last user who can manage roles.
+	DeleteUser(userId string) error
+}
+
+type sqlUserRepository struct {
+	db *sql.DB
+}
+
+// NewUserRepository returns a UserRepository backed by db, which must use the
+// dialect the package was initialised with.
+func NewUserRepository(db *sql.DB) UserRepository {
+	return &sqlUserRepository{db: db}
+}
+
+const selectUser = `SELECT u.id, u.email, u.email_verified, u.role, u.suspended, u.created_at, u.updated_at,
+        COALESCE(p.display_name, ''), COALESCE(p.avatar_url, ''), COALESCE(p.status_text, '')
+    FROM users u LEFT JOIN profiles p ON p.user_id = u.id`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(&user.ID, &user.Email, &user.EmailVerified, &user.Role, &user.Suspended, &user.CreatedAt, &user.UpdatedAt, &user.DisplayName, &user.AvatarURL, &user.StatusText)
+	return user, err
+}
+
+func (r *sqlUserRepository) CreateUser(user models.User, outbox ...OutboxEvent) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(rebind("INSERT INTO users (id, email, email_verified, password, role) VALUES (?, ?, ?, ?, ?)"),
+		user.ID, user.Email, user.EmailVerified, user.Password, user.Role)
+	if isUniqueViolation(err) {
+		return ErrUserExists
+	} else if err != nil {
+		return err
+	}
+	if err := insertOutbox(tx, outbox); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlUserRepository) GetUserById(userId string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(rebind(selectUser+" WHERE u.id = ?"), userId))
+	if err == sql.ErrNoRows {
+		logger.Error("User not found", zap.String("userId", userId))
+		return nil, nil
+	} else if err != nil {
+		logger.Error("Failed to get user", zap.String("userId", userId), zap.Error(err))
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (r *sqlUserRepository) GetUserWithPassword(userId string) (*models.User, error) {
+	row := r.db.QueryRow(rebind("SELECT id, email, password, role, suspended, created_at, updated_at FROM users WHERE id = ?"), userId)
+
+	var user models.User
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.Role, &user.Suspended, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *sqlUserRepository) GetUserByEmail(email string) (*models.User, error) {
+	user, err := scanUser(r.db.QueryRow(rebind(selectUser+" WHERE lower(u.email) = lower(?)"), email))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		logger.Error("Failed to get user by email", zap.Error(err))
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *sqlUserRepository) GetUsersWithRole(role string) ([]models.User, error) {
+	rows, err := r.db.Query(rebind(selectUser+" WHERE u.role = ? ORDER BY u.id"), role)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *sqlUserRepository) ListUsers(filter models.UserFilter) ([]models.User, int, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Role != "" {
+		conditions = append(conditions, "u.role = ?")
+		args = append(args, filter.Role)
+	}
+	if filter.Suspended != nil {
+		conditions = append(conditions, "u.suspended = ?")
+		args = append(args, *filter.Suspended)
+	}
+	if filter.Query != "" {
+		pattern := "%" + escapeLike(strings.ToLower(filter.Query)) + "%"
+		conditions = append(conditions, `(lower(u.id) LIKE ? ESCAPE '\' OR lower(u.email) LIKE ? ESCAPE '\')`)
+		args = append(args, pattern, pattern)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := r.db.QueryRow(rebind("SELECT COUNT(*) FROM users u"+where), args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.Query(rebind(selectUser+where+" ORDER BY u.id LIMIT ? OFFSET ?"), append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		users = append(users, user)
+	}
+	return users, total, rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (r *sqlUserRepository) UpdatePassword(userId string, hashedPassword string) error {
+	_, err := r.db.Exec(rebind("UPDATE users SET password = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"), hashedPassword, userId)
+	return err
+}
+
+func (r *sqlUserRepository) UpdateRole(userId string, role string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var manages int
+	err = tx.QueryRow(rebind("SELECT COUNT(*) FROM role_permissions WHERE role = ? AND permission = ?"), role, rbac.RolesManage).Scan(&manages)
+	if err != nil {
+		return err
+	}
+	if manages == 0 {
+		if err := keepUserManager(tx, userId); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(rebind("UPDATE users SET role = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"), role, userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// keepUserManager returns ErrLastUserManager if the user is the only one whose
+// role grants roles:manage, so that roles can't become unmanageable.
+func keepUserManager(tx *sql.Tx, userId string) error {
+	var self, others int
+	err := tx.QueryRow(rebind(`SELECT COALESCE(SUM(CASE WHEN u.id = ? THEN 1 ELSE 0 END), 0), COALESCE(SUM(CASE WHEN u.id <> ? THEN 1 ELSE 0 END), 0)
+        FROM users u JOIN role_permissions rp ON rp.role = u.role WHERE rp.permission = ?`), userId, userId, rbac.RolesManage).Scan(&self, &others)
+	if err != nil {
+		return err
+	}
+	if self > 0 && others == 0 {
+		return ErrLastUserManager
+	}
+	return nil
+}
+
+func (r *sqlUserRepository) SetSuspended(userId string, suspended bool) error {
+	_, err := r.db.Exec(rebind("UPDATE users SET suspended = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?"), suspended, userId)
+	return err
+}
+
+func (r *sqlUserRepository) UpdateProfile(userId string, displayName, avatarURL, statusText *string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(rebind(`INSERT INTO profiles (user_id, display_name, avatar_url, status_text)
+        VALUES (?, COALESCE(?, ''), COALESCE(?, ''), COALESCE(?, ''))
+        ON CONFLICT(user_id) DO UPDATE SET
+            display_name = COALESCE(?, profiles.display_name),
+            avatar_url = COALESCE(?, profiles.avatar_url),
+            status_text = COALESCE(?, profiles.status_text)`),
+		userId, displayName, avatarURL, statusText, displayName, avatarURL, statusText)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(rebind("UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = ?"), userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *sqlUserRepository) DeleteUser(userId string) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := keepUserManager(tx, userId); err != nil {
+		return err
+	}
+	queries := []string{
+		"DELETE FROM profiles WHERE user_id = ?",
+		"DELETE FROM email_changes WHERE user_id = ?",
+		"DELETE FROM password_resets WHERE user_id = ?",
+		"DELETE FROM recovery_codes WHERE user_id = ?",
+		"DELETE FROM two_factor WHERE user_id = ?",
+		"DELETE FROM user_identities WHERE user_id = ?",
+		"DELETE FROM api_keys WHERE owner_id = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(rebind(query), userId); err != nil {
+			return err
+		}
+	}
+	if err := removeIncomingWebhookRecipient(tx, userId); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"testing"
+
+	"userservice/internal/models"
+)
+
+func newTestUsers(t *testing.T, roles map[string]string) UserRepository {
+	t.Helper()
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	DB = db
+	users := NewUserRepository(db)
+	for id, role := range roles {
+		if err := users.CreateUser(models.User{ID: id, Email: id + "@example.com", Password: "x", Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return users
+}
+
+func TestUpdateRoleKeepsAUserManager(t *testing.T) {
+	users := newTestUsers(t, map[string]string{"alice": "admin", "bob": "user"})
+
+	if err := users.UpdateRole("alice", "moderator"); err != ErrLastUserManager {
+		t.Fatalf("demoting the only admin: got %v, want ErrLastUserManager", err)
+	}
+	if user, _ := users.GetUserById("alice"); user.Role != "admin" {
+		t.Fatalf("role changed to %q", user.Role)
+	}
+	if err := users.UpdateRole("bob", "moderator"); err != nil {
+		t.Fatalf("changing the role of a user who can't manage roles: %v", err)
+	}
+	if err := users.UpdateRole("alice", "admin"); err != nil {
+		t.Fatalf("assigning the only admin a role that manages roles: %v", err)
+	}
+
+	if err := users.UpdateRole("bob", "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.UpdateRole("alice", "user"); err != nil {
+		t.Fatalf("demoting an admin while another one is left: %v", err)
+	}
+	if err := users.UpdateRole("bob", "user"); err != ErrLastUserManager {
+		t.Fatalf("demoting the last admin: got %v, want ErrLastUserManager", err)
+	}
+}
+
+func TestDeleteUserKeepsAUserManager(t *testing.T) {
+	users := newTestUsers(t, map[string]string{"alice": "admin", "bob": "user"})
+
+	if err := users.DeleteUser("alice"); err != ErrLastUserManager {
+		t.Fatalf("deleting the only admin: got %v, want ErrLastUserManager", err)
+	}
+	if user, _ := users.GetUserById("alice"); user == nil {
+		t.Fatal("the only admin was deleted")
+	}
+	if err := users.DeleteUser("bob"); err != nil {
+		t.Fatalf("deleting a user who can't manage roles: %v", err)
+	}
+
+	if err := users.CreateUser(models.User{ID: "carol", Email: "carol@example.com", Password: "x", Role: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.DeleteUser("alice"); err != nil {
+		t.Fatalf("deleting an admin while another one is left: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package grpc
+
+import (
+	"chatapp/outbound"
+	"context"
+	"userservice/database"
+	"userservice/internal/apikeys"
+	"userservice/internal/incoming"
+	"userservice/internal/middleware"
+
+	pb "userservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+type server struct {
+	pb.UnimplementedAPIKeyServiceServer
+	logger *zap.Logger
+}
+
+func NewGRPCServer(logger *zap.Logger) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterAPIKeyServiceServer(s, &server{logger: logger})
+	pb.RegisterIncomingWebhookServiceServer(s, &incomingWebhookServer{logger: logger})
+	pb.RegisterCommandServiceServer(s, &commandServer{logger: logger})
+	pb.RegisterPermissionServiceServer(s, &permissionServer{logger: logger})
+	pb.RegisterTokenServiceServer(s, &tokenServer{logger: logger})
+	reflection.Register(s)
+	return s
+}
+
+func (s *server) VerifyAPIKey(ctx context.Context, req *pb.VerifyAPIKeyRequest) (*pb.VerifyAPIKeyResponse, error) {
+	principal, err := apikeys.Verify(req.GetKey())
+	if apikeys.IsRejected(err) {
+		return &pb.VerifyAPIKeyResponse{Valid: false, Reason: err.Error()}, nil
+	} else if err != nil {
+		s.logger.Error("Failed to verify API key", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to verify api key")
+	}
+	return &pb.VerifyAPIKeyResponse{
+		Valid:     true,
+		KeyId:     principal.KeyID,
+		Principal: principal.ID,
+		Scopes:    principal.Scopes,
+	}, nil
+}
+
+type permissionServer struct {
+	pb.UnimplementedPermissionServiceServer
+	logger *zap.Logger
+}
+
+func (s *permissionServer) CheckPermission(ctx context.Context, req *pb.CheckPermissionRequest) (*pb.CheckPermissionResponse, error) {
+	err := apikeys.Authorize(req.GetPrincipal(), req.GetPermission())
+	if apikeys.IsRejected(err) {
+		return &pb.CheckPermissionResponse{Allowed: false, Reason: err.Error()}, nil
+	} else if err != nil {
+		s.logger.Error("Failed to check permission", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to check permission")
+	}
+	return &pb.CheckPermissionResponse{Allowed: true}, nil
+}
+
+type tokenServer struct {
+	pb.UnimplementedTokenServiceServer
+	logger *zap.Logger
+}
+
+func (s *tokenServer) VerifyToken(ctx context.Context, req *pb.VerifyTokenRequest) (*pb.VerifyTokenResponse, error) {
+	userID, reason, err := middleware.VerifyToken(req.GetToken())
+	if err != nil {
+		s.logger.Error("Failed to verify token", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to verify token")
+	}
+	if reason != "" {
+		return &pb.VerifyTokenResponse{Valid: false, Reason: reason}, nil
+	}
+	return &pb.VerifyTokenResponse{Valid: true, UserId: userID}, nil
+}
+
+type incomingWebhookServer struct {
+	pb.UnimplementedIncomingWebhookServiceServer
+	logger *zap.Logger
+}
+
+func (s *incomingWebhookServer) VerifyIncomingWebhook(ctx context.Context, req *pb.VerifyIncomingWebhookRequest) (*pb.VerifyIncomingWebhookResponse, error) {
+	hook, err := incoming.Verify(req.GetId(), req.GetToken())
+	if err != nil {
+		s.logger.Error("Failed to verify incoming webhook", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to verify incoming webhook")
+	}
+	if hook == nil {
+		return &pb.VerifyIncomingWebhookResponse{Valid: false}, nil
+	}
+	return &pb.VerifyIncomingWebhookResponse{
+		Valid:      true,
+		Sender:     incoming.SenderPrefix + hook.Name,
+		Recipients: hook.Recipients,
+	}, nil
+}
+
+type commandServer struct {
+	pb.UnimplementedCommandServiceServer
+	logger *zap.Logger
+}
+
+func (s *commandServer) ListCommands(ctx context.Context, req *pb.ListCommandsRequest) (*pb.ListCommandsResponse, error) {
+	commands, err := database.ListCommands()
+	if err != nil {
+		s.logger.Error("Failed to list commands", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list commands")
+	}
+	resp := &pb.ListCommandsResponse{}
+	for _, cmd := range commands {
+		resp.Commands = append(resp.Commands, &pb.Command{
+			Name:        cmd.Name,
+			Url:         cmd.URL,
+			Description: cmd.Description,
+		})
+	}
+	return resp, nil
+}
+
+// SignCommand signs an invocation of a registered command, so that its secret
+// never leaves userservice.
+func (s *commandServer) SignCommand(ctx context.Context, req *pb.SignCommandRequest) (*pb.SignCommandResponse, error) {
+	secret, err := database.GetCommandSecret(req.GetName())
+	if err != nil {
+		s.logger.Error("Failed to get command secret", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to sign command")
+	}
+	if secret == "" {
+		return nil, status.Error(codes.NotFound, "command not found")
+	}
+	return &pb.SignCommandResponse{Signature: outbound.Sign(secret, req.GetTimestamp(), req.GetBody())}, nil
+}
@@ -0,0 +1,46 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	pb "userservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// AuditClient reads the audit trail kept by workerservice.
+type AuditClient struct {
+	conn   *grpc.ClientConn
+	client pb.AuditServiceClient
+}
+
+func NewAuditClient(address string) (*AuditClient, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &AuditClient{conn: conn, client: pb.NewAuditServiceClient(conn)}, nil
+}
+
+func (c *AuditClient) Close() {
+	c.conn.Close()
+}
+
+func (c *AuditClient) Query(req *pb.QueryAuditRequest) (*pb.QueryAuditResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.QueryAudit(ctx, req)
+}
+
+// Export streams every matching entry in chain order. The stream ends when ctx is done.
+func (c *AuditClient) Export(ctx context.Context, req *pb.QueryAuditRequest) (grpc.ServerStreamingClient[pb.AuditEntry], error) {
+	return c.client.ExportAudit(ctx, req)
+}
+
+func (c *AuditClient) Verify() (*pb.VerifyAuditResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return c.client.VerifyAudit(ctx, &pb.VerifyAuditRequest{})
+}
@@ -0,0 +1,48 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	pb "userservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// ScheduleClient manages the scheduled messages and reminders kept by workerservice.
+type ScheduleClient struct {
+	conn   *grpc.ClientConn
+	client pb.ScheduleServiceClient
+}
+
+func NewScheduleClient(address string) (*ScheduleClient, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &ScheduleClient{conn: conn, client: pb.NewScheduleServiceClient(conn)}, nil
+}
+
+func (c *ScheduleClient) Close() {
+	c.conn.Close()
+}
+
+func (c *ScheduleClient) Create(req *pb.CreateScheduleRequest) (*pb.Schedule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.CreateSchedule(ctx, req)
+}
+
+func (c *ScheduleClient) List(owner string) (*pb.ListSchedulesResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.ListSchedules(ctx, &pb.ListSchedulesRequest{Owner: owner})
+}
+
+func (c *ScheduleClient) Cancel(id int64, owner string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := c.client.CancelSchedule(ctx, &pb.CancelScheduleRequest{Id: id, Owner: owner})
+	return err
+}
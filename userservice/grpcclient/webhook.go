@@ -0,0 +1,60 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	pb "userservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// WebhookClient manages the outgoing webhooks delivered by workerservice.
+type WebhookClient struct {
+	conn   *grpc.ClientConn
+	client pb.WebhookServiceClient
+}
+
+func NewWebhookClient(address string) (*WebhookClient, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &WebhookClient{conn: conn, client: pb.NewWebhookServiceClient(conn)}, nil
+}
+
+func (c *WebhookClient) Close() {
+	c.conn.Close()
+}
+
+func (c *WebhookClient) Create(url string, events []string, secret string) (*pb.CreateWebhookResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.CreateWebhook(ctx, &pb.CreateWebhookRequest{Url: url, Events: events, Secret: secret})
+}
+
+func (c *WebhookClient) List() (*pb.ListWebhooksResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.ListWebhooks(ctx, &pb.ListWebhooksRequest{})
+}
+
+func (c *WebhookClient) SetEnabled(id int64, enabled bool) (*pb.Webhook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.SetWebhookEnabled(ctx, &pb.SetWebhookEnabledRequest{Id: id, Enabled: enabled})
+}
+
+func (c *WebhookClient) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := c.client.DeleteWebhook(ctx, &pb.DeleteWebhookRequest{Id: id})
+	return err
+}
+
+func (c *WebhookClient) Deliveries(webhookId int64, limit int) (*pb.ListDeliveriesResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.ListDeliveries(ctx, &pb.ListDeliveriesRequest{WebhookId: webhookId, Limit: int32(limit)})
+}
@@ -0,0 +1,166 @@
+// Package apikeys issues and verifies API keys for bots and integrations.
+// A key looks like cak_<id>_<secret>; only a hash of the secret is stored.
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/rbac"
+)
+
+const Prefix = "cak_"
+
+// BotPrefix marks the principal of a service key, which isn't a user.
+const BotPrefix = "bot:"
+
+var (
+	ErrInvalidKey = errors.New("invalid api key")
+	ErrRevoked    = errors.New("api key revoked")
+	ErrExpired    = errors.New("api key expired")
+	ErrOwner      = errors.New("api key owner missing or suspended")
+	ErrForbidden  = errors.New("permission not granted")
+)
+
+// IsRejected tells a refused key apart from a failure to check it.
+func IsRejected(err error) bool {
+	return err == ErrInvalidKey || err == ErrRevoked || err == ErrExpired || err == ErrOwner || err == ErrForbidden
+}
+
+// Principal is who a verified key acts as and what it may do.
+type Principal struct {
+	KeyID string
+	// ID is the owner's user id, or bot:<name> for service keys
+	ID     string
+	Scopes []string
+}
+
+// IsAPIKey tells API keys apart from JWTs.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Generate returns a new key id, the key to hand to the client and the hash to store.
+func Generate() (string, string, string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+	id := hex.EncodeToString(idBytes)
+	secret := hex.EncodeToString(secretBytes)
+	return id, Prefix + id + "_" + secret, hash(secret), nil
+}
+
+// Verify checks the key and resolves its effective scopes. A user key never
+// grants more than the owner's current role does.
+func Verify(key string) (*Principal, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(key, Prefix), "_")
+	if !IsAPIKey(key) || !ok || id == "" || secret == "" {
+		return nil, ErrInvalidKey
+	}
+	stored, keyHash, err := database.GetAPIKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil || subtle.ConstantTimeCompare([]byte(keyHash), []byte(hash(secret))) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if stored.Revoked {
+		return nil, ErrRevoked
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return nil, ErrExpired
+	}
+
+	// a service key is capped at rbac.ServiceKeyScopes, also if issued before the cap
+	principal := &Principal{KeyID: stored.ID, ID: BotPrefix + stored.Name, Scopes: intersect(stored.Scopes, rbac.ServiceKeyScopes)}
+	if stored.OwnerID != "" {
+		owner, err := database.Users.GetUserById(stored.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		if owner == nil || owner.Suspended {
+			return nil, ErrOwner
+		}
+		permissions, err := database.Roles.PermissionsForUser(stored.OwnerID)
+		if err != nil {
+			return nil, err
+		}
+		principal.ID = stored.OwnerID
+		principal.Scopes = intersect(stored.Scopes, permissions)
+	}
+
+	// last use is only recorded every config.APIKeyTouchInterval, so that busy
+	// keys don't cost a write per request
+	now := time.Now()
+	if stored.LastUsedAt == nil || now.Sub(*stored.LastUsedAt) >= config.APIKeyTouchInterval {
+		if err := database.TouchAPIKey(stored.ID, now); err != nil {
+			return nil, err
+		}
+	}
+	return principal, nil
+}
+
+// Authorize checks that a principal, a user id or the bot:<name> of a service
+// key, is still active and has the permission. Services that act for a
+// principal later, such as scheduled messages, check with it first.
+func Authorize(principal string, permission string) error {
+	if name, ok := strings.CutPrefix(principal, BotPrefix); ok {
+		key, err := database.GetServiceAPIKey(name)
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			return ErrRevoked
+		}
+		if time.Now().After(key.ExpiresAt) {
+			return ErrExpired
+		}
+		if !rbac.Has(intersect(key.Scopes, rbac.ServiceKeyScopes), permission) {
+			return ErrForbidden
+		}
+		return nil
+	}
+
+	user, err := database.Users.GetUserById(principal)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.Suspended {
+		return ErrOwner
+	}
+	permissions, err := database.Roles.PermissionsForUser(principal)
+	if err != nil {
+		return err
+	}
+	if !rbac.Has(permissions, permission) {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// intersect returns the scopes that allowed grants.
+func intersect(scopes []string, allowed []string) []string {
+	out := []string{}
+	for _, scope := range scopes {
+		if rbac.Has(allowed, scope) {
+			out = append(out, scope)
+		}
+	}
+	return out
+}
+
+func hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,37 @@
+// Package audit publishes this service's security audit events, defined in
+// chatapp/events, on the audit topic.
+package audit
+
+import (
+	"chatapp/events"
+	"userservice/internal/kafka"
+
+	"go.uber.org/zap"
+)
+
+const Service = "userservice"
+
+var producer *kafka.Producer
+var logger *zap.Logger
+
+func Init(p *kafka.Producer, l *zap.Logger) {
+	producer = p
+	logger = l
+}
+
+func NewEvent(eventType string, outcome string) events.Audit {
+	return events.NewAudit(eventType, outcome, Service)
+}
+
+// Publish sends the event to the audit topic, keyed by its target or else its
+// actor. Failures are only logged so that the action being audited isn't undone
+// by a broker outage.
+func Publish(event events.Audit) {
+	key := event.TargetID
+	if key == "" {
+		key = event.ActorID
+	}
+	if err := producer.SendMessageToAuditTopic(key, event); err != nil {
+		logger.Error("Failed to send audit event", zap.Error(err), zap.String("type", event.Type))
+	}
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"strconv"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const maxPageSize = 100
+
+// ListUsers returns users of every role, filtered by the role, q (substring of
+// id or email) and suspended query parameters and paginated with page and limit.
+func ListUsers(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 20)
+	if page < 1 || limit < 1 || limit > maxPageSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid page or limit"})
+	}
+	filter := models.UserFilter{
+		Role:   c.Query("role"),
+		Query:  c.Query("q"),
+		Limit:  limit,
+		Offset: (page - 1) * limit,
+	}
+	if s := c.Query("suspended"); s != "" {
+		suspended, err := strconv.ParseBool(s)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid suspended filter"})
+		}
+		filter.Suspended = &suspended
+	}
+
+	users, total, err := database.Users.ListUsers(filter)
+	if err != nil {
+		logger.Error("Failed to list users", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch users"})
+	}
+	return c.JSON(fiber.Map{"users": users, "total": total, "page": page, "limit": limit})
+}
+
+// SuspendUser blocks the user from logging in, ends their current sessions and
+// revokes their API keys, which stay revoked when the user is unsuspended. The
+// user.suspended event cancels their scheduled messages.
+func SuspendUser(c *fiber.Ctx) error {
+	return setSuspended(c, true)
+}
+
+func UnsuspendUser(c *fiber.Ctx) error {
+	return setSuspended(c, false)
+}
+
+func setSuspended(c *fiber.Ctx, suspended bool) error {
+	userID := c.Params("userID")
+	user, err := database.Users.GetUserById(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user"})
+	}
+	if user == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err := database.Users.SetSuspended(userID, suspended); err != nil {
+		logger.Error("Failed to update suspension", zap.Error(err), zap.String("userId", userID))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update user"})
+	}
+
+	if suspended {
+		revokeSessions(userID)
+		revokeUserAPIKeys(userID)
+		publishUserEvent(events.UserSuspended, userID)
+		recordAudit(c, events.AuditUserSuspended, userID, nil)
+		return c.JSON(fiber.Map{"message": "User suspended"})
+	}
+	recordAudit(c, events.AuditUserUnsuspended, userID, nil)
+	return c.JSON(fiber.Map{"message": "User unsuspended"})
+}
+
+// ForceLogout revokes every token and API key of the user and closes their
+// WebSocket connection.
+func ForceLogout(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	if err := blacklist.RevokeUser(userID, config.JWTExpiration); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke tokens"})
+	}
+	res, err := grpcClient.Logout(userID)
+	if err != nil {
+		logger.Error("Failed to call Logout RPC", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to logout"})
+	}
+	if !res.GetSuccess() {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Logout RPC call unsuccessful"})
+	}
+
+	revokeUserAPIKeys(userID)
+
+	publishUserEvent(events.UserLoggedOut, userID)
+	recordAudit(c, events.AuditUserForceLogout, userID, nil)
+	return c.JSON(fiber.Map{"message": "User logged out"})
+}
+
+func DeleteUser(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	user, err := database.Users.GetUserById(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user"})
+	}
+	if user == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err := database.Users.DeleteUser(userID); err == database.ErrLastUserManager {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "At least one user must keep " + rbac.RolesManage})
+	} else if err != nil {
+		logger.Error("Failed to delete user", zap.Error(err), zap.String("userId", userID))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete user"})
+	}
+	revokeSessions(userID)
+
+	publishUserEvent(events.UserDeleted, userID)
+
+	recordAudit(c, events.AuditUserDeleted, userID, nil)
+	return c.JSON(fiber.Map{"message": "User deleted"})
+}
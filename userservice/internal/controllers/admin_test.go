@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"userservice/database"
+	"userservice/grpcclient"
+	"userservice/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newAdminTestApp serves the admin routes to a caller signed in as admin.
+// Nothing listens on the notificationservice address, so its Logout calls
+// fail and are only logged.
+func newAdminTestApp(t *testing.T) *fiber.App {
+	t.Helper()
+	setupControllers(t)
+	client, err := grpcclient.NewClient("127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcClient = client
+	t.Cleanup(client.Close)
+
+	for id, role := range map[string]string{"admin": "admin", "alice": "user"} {
+		if err := database.Users.CreateUser(models.User{ID: id, Email: id + "@example.com", Password: "x", Role: role}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("user", &jwt.Token{Claims: jwt.MapClaims{"id": "admin"}, Valid: true})
+		return c.Next()
+	})
+	app.Post("/admin/users/:userID/suspend", SuspendUser)
+	app.Put("/admin/users/:userID/role", AssignRole)
+	app.Delete("/admin/users/:userID", DeleteUser)
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, method string, path string, body string) int {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestSuspendUserRevokesAPIKeys(t *testing.T) {
+	app := newAdminTestApp(t)
+	key := models.APIKey{ID: "k1", Name: "bot", OwnerID: "alice", CreatedBy: "alice", Scopes: []string{"messages:send"}, ExpiresAt: time.Now().Add(time.Hour)}
+	if err := database.CreateAPIKey(key, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := send(t, app, "POST", "/admin/users/alice/suspend", ""); status != fiber.StatusOK {
+		t.Fatalf("suspend status = %d", status)
+	}
+	stored, _, err := database.GetAPIKey("k1")
+	if err != nil || !stored.Revoked {
+		t.Fatalf("key after suspension = %+v, %v", stored, err)
+	}
+}
+
+func TestAdminKeepsARoleManager(t *testing.T) {
+	app := newAdminTestApp(t)
+
+	if status := send(t, app, "PUT", "/admin/users/admin/role", `{"role":"user"}`); status != fiber.StatusConflict {
+		t.Fatalf("demoting the last admin = %d, want %d", status, fiber.StatusConflict)
+	}
+	if status := send(t, app, "DELETE", "/admin/users/admin", ""); status != fiber.StatusConflict {
+		t.Fatalf("deleting the last admin = %d, want %d", status, fiber.StatusConflict)
+	}
+
+	if status := send(t, app, "PUT", "/admin/users/alice/role", `{"role":"admin"}`); status != fiber.StatusOK {
+		t.Fatalf("promoting alice = %d", status)
+	}
+	if status := send(t, app, "DELETE", "/admin/users/admin", ""); status != fiber.StatusOK {
+		t.Fatalf("deleting an admin while another one is left = %d", status)
+	}
+}
@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"strings"
+	"time"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/apikeys"
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+func ListMyAPIKeys(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	keys, err := database.ListAPIKeys(&userId)
+	if err != nil {
+		logger.Error("Failed to list API keys", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch API keys"})
+	}
+	return c.JSON(keys)
+}
+
+// CreateMyAPIKey issues a key that acts as the caller. It can only be given
+// scopes the caller's role grants.
+func CreateMyAPIKey(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	permissions, err := database.Roles.PermissionsForUser(userId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to resolve permissions"})
+	}
+	return createAPIKey(c, userId, permissions)
+}
+
+func RevokeMyAPIKey(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	key, _, err := database.GetAPIKey(c.Params("keyID"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch API key"})
+	}
+	if key == nil || key.OwnerID != userId {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	return revokeAPIKey(c, key)
+}
+
+// ListAPIKeys returns every key, or only those of the owner query parameter.
+func ListAPIKeys(c *fiber.Ctx) error {
+	var ownerId *string
+	if owner := c.Query("owner"); owner != "" {
+		ownerId = &owner
+	}
+	keys, err := database.ListAPIKeys(ownerId)
+	if err != nil {
+		logger.Error("Failed to list API keys", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch API keys"})
+	}
+	return c.JSON(keys)
+}
+
+// CreateServiceAPIKey issues a key that belongs to no user; it acts as bot:<name>
+// and can only have rbac.ServiceKeyScopes.
+func CreateServiceAPIKey(c *fiber.Ctx) error {
+	return createAPIKey(c, "", rbac.ServiceKeyScopes)
+}
+
+func RevokeAPIKey(c *fiber.Ctx) error {
+	key, _, err := database.GetAPIKey(c.Params("keyID"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch API key"})
+	}
+	if key == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "API key not found"})
+	}
+	return revokeAPIKey(c, key)
+}
+
+// createAPIKey responds with the key itself, which is never shown again.
+func createAPIKey(c *fiber.Ctx, ownerId string, grantable []string) error {
+	var req models.CreateAPIKeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || strings.ContainsAny(req.Name, ", ") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name is required and can't contain spaces or commas"})
+	}
+	if len(req.Scopes) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "At least one scope is required"})
+	}
+	for _, scope := range req.Scopes {
+		if !rbac.IsKeyScope(scope) || !rbac.Has(grantable, scope) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Scope not allowed: " + scope})
+		}
+	}
+	ttl := config.APIKeyDefaultTTL
+	if req.ExpiresInDays != 0 {
+		ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+	if ttl <= 0 || ttl > config.APIKeyMaxTTL {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid expiry"})
+	}
+
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	id, secret, keyHash, err := apikeys.Generate()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate API key"})
+	}
+	key := models.APIKey{
+		ID:        id,
+		Name:      req.Name,
+		OwnerID:   ownerId,
+		CreatedBy: claims["id"].(string),
+		Scopes:    req.Scopes,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := database.CreateAPIKey(key, keyHash); err == database.ErrAPIKeyNameTaken {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "A service key with this name exists; revoke it first or choose another name"})
+	} else if err != nil {
+		logger.Error("Failed to store API key", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot create API key"})
+	}
+
+	recordAudit(c, events.AuditAPIKeyCreated, ownerId, map[string]string{"keyId": id, "name": key.Name, "scopes": strings.Join(key.Scopes, ",")})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"key": secret, "api_key": key})
+}
+
+// revokeUserAPIKeys revokes every key the user owns, for when their password or
+// sessions may have been compromised.
+func revokeUserAPIKeys(userId string) {
+	n, err := database.RevokeUserAPIKeys(userId)
+	if err != nil {
+		logger.Error("Failed to revoke API keys", zap.Error(err), zap.String("userId", userId))
+	} else if n > 0 {
+		logger.Info("API keys revoked", zap.String("userId", userId), zap.Int64("count", n))
+	}
+}
+
+func revokeAPIKey(c *fiber.Ctx, key *models.APIKey) error {
+	if err := database.RevokeAPIKey(key.ID); err != nil {
+		logger.Error("Failed to revoke API key", zap.Error(err), zap.String("keyId", key.ID))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke API key"})
+	}
+
+	recordAudit(c, events.AuditAPIKeyRevoked, key.OwnerID, map[string]string{"keyId": key.ID, "name": key.Name})
+	return c.JSON(fiber.Map{"message": "API key revoked"})
+}
@@ -0,0 +1,131 @@
+package controllers
+
+import (
+	"bufio"
+	"chatapp/events"
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+	"userservice/internal/audit"
+	pb "userservice/proto"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+const auditExportTimeout = 10 * time.Minute
+
+// recordAudit publishes a successful action taken by the authenticated caller on
+// targetId, e.g. an admin suspending a user.
+func recordAudit(c *fiber.Ctx, eventType string, targetId string, details map[string]string) {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	event := audit.NewEvent(eventType, events.AuditSuccess)
+	event.ActorID, _ = claims["id"].(string)
+	event.TargetID = targetId
+	event.IP = c.IP()
+	event.Details = details
+	audit.Publish(event)
+}
+
+// QueryAuditLog returns one page of the audit trail, newest first, filtered by
+// the type, actor, target, since and until (RFC 3339) query parameters.
+func QueryAuditLog(c *fiber.Ctx) error {
+	page := c.QueryInt("page", 1)
+	limit := c.QueryInt("limit", 50)
+	if page < 1 || limit < 1 || limit > maxPageSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid page or limit"})
+	}
+	req, err := auditRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "since and until must be RFC 3339 timestamps"})
+	}
+	req.Limit = int32(limit)
+	req.Offset = int32((page - 1) * limit)
+
+	resp, err := auditClient.Query(req)
+	if err != nil {
+		logger.Error("Failed to call QueryAudit RPC", zap.Error(err))
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to fetch audit log"})
+	}
+	entries := resp.GetEntries()
+	if entries == nil {
+		entries = []*pb.AuditEntry{}
+	}
+	return c.JSON(fiber.Map{"entries": entries, "total": resp.GetTotal(), "page": page, "limit": limit})
+}
+
+// ExportAuditLog streams every matching entry in chain order as newline
+// delimited JSON, taking the same filters as QueryAuditLog.
+func ExportAuditLog(c *fiber.Ctx) error {
+	req, err := auditRequest(c)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "since and until must be RFC 3339 timestamps"})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), auditExportTimeout)
+	stream, err := auditClient.Export(ctx, req)
+	if err != nil {
+		cancel()
+		logger.Error("Failed to call ExportAudit RPC", zap.Error(err))
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to export audit log"})
+	}
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="audit.ndjson"`)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		enc := json.NewEncoder(w)
+		for {
+			entry, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				logger.Error("Audit export interrupted", zap.Error(err))
+				break
+			}
+			if err := enc.Encode(entry); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// VerifyAuditLog checks the hash chain of the whole trail.
+func VerifyAuditLog(c *fiber.Ctx) error {
+	resp, err := auditClient.Verify()
+	if err != nil {
+		logger.Error("Failed to call VerifyAudit RPC", zap.Error(err))
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Failed to verify audit log"})
+	}
+	result := fiber.Map{"valid": resp.GetValid(), "entries": resp.GetEntries()}
+	if !resp.GetValid() {
+		result["brokenSeq"] = resp.GetBrokenSeq()
+	}
+	return c.JSON(result)
+}
+
+func auditRequest(c *fiber.Ctx) (*pb.QueryAuditRequest, error) {
+	req := &pb.QueryAuditRequest{
+		Type:     c.Query("type"),
+		ActorId:  c.Query("actor"),
+		TargetId: c.Query("target"),
+		Since:    c.Query("since"),
+		Until:    c.Query("until"),
+	}
+	for _, ts := range []string{req.Since, req.Until} {
+		if ts == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, ts); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,88 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"chatapp/outbound"
+	"chatapp/slash"
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"strings"
+	"time"
+	"userservice/database"
+	"userservice/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+var commandName = regexp.MustCompile(`^[a-z][a-z0-9_-]{0,31}$`)
+
+func ListCommands(c *fiber.Ctx) error {
+	commands, err := database.ListCommands()
+	if err != nil {
+		logger.Error("Failed to list commands", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch commands"})
+	}
+	return c.JSON(commands)
+}
+
+// RegisterCommand responds with the signing secret, which is never shown again.
+func RegisterCommand(c *fiber.Ctx) error {
+	var req models.RegisterCommandRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	req.Name = strings.TrimPrefix(strings.TrimSpace(req.Name), "/")
+	if !commandName.MatchString(req.Name) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name must be lowercase letters, digits, - or _ (at most 32)"})
+	}
+	if slash.IsBuiltin(req.Name) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "/" + req.Name + " is a built-in command"})
+	}
+	u, err := outbound.CheckURL(req.URL)
+	if err == outbound.ErrForbiddenAddress {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "url must not point to a local or private address"})
+	} else if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate secret"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	cmd := models.SlashCommand{
+		Name:        req.Name,
+		URL:         u.String(),
+		Secret:      "cmdsec_" + hex.EncodeToString(b),
+		Description: strings.TrimSpace(req.Description),
+		CreatedBy:   claims["id"].(string),
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err := database.CreateCommand(cmd); err == database.ErrCommandExists {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Command already registered"})
+	} else if err != nil {
+		logger.Error("Failed to store command", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot register command"})
+	}
+
+	recordAudit(c, events.AuditCommandRegistered, "", map[string]string{"command": cmd.Name, "url": cmd.URL})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"secret": cmd.Secret, "command": cmd})
+}
+
+func DeleteCommand(c *fiber.Ctx) error {
+	name := c.Params("name")
+	found, err := database.DeleteCommand(name)
+	if err != nil {
+		logger.Error("Failed to delete command", zap.Error(err), zap.String("command", name))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete command"})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Command not found"})
+	}
+
+	recordAudit(c, events.AuditCommandDeleted, "", map[string]string{"command": name})
+	return c.JSON(fiber.Map{"message": "Command deleted"})
+}
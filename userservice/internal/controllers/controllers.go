@@ -1,12 +1,17 @@
 package controllers
 
 import (
+	"chatapp/events"
 	"time"
+	"userservice/config"
 	"userservice/database"
 	"userservice/grpcclient"
+	"userservice/internal/audit"
 	"userservice/internal/jwtpkg"
 	"userservice/internal/kafka"
 	"userservice/internal/models"
+	"userservice/internal/oidc"
+	"userservice/internal/rbac"
 	"userservice/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,18 +21,37 @@ import (
 )
 
 var grpcClient *grpcclient.Client
+var auditClient *grpcclient.AuditClient
+var webhookClient *grpcclient.WebhookClient
+var scheduleClient *grpcclient.ScheduleClient
 var logger *zap.Logger
 var producer *kafka.Producer
 var blacklist *utils.Blacklist
+var loginGuard *utils.LoginGuard
+var oidcProvider *oidc.Provider
 
-func InitAuthController(grpcAddress string, log *zap.Logger, p *kafka.Producer, b *utils.Blacklist) {
+func InitAuthController(grpcAddress string, workerAddress string, log *zap.Logger, p *kafka.Producer, b *utils.Blacklist, g *utils.LoginGuard, o *oidc.Provider) {
 	blacklist = b
+	oidcProvider = o
+	loginGuard = g
 	producer = p
 	var err error
 	grpcClient, err = grpcclient.NewClient(grpcAddress)
 	if err != nil {
 		log.Fatal("Failed to create gRPC client", zap.Error(err))
 	}
+	auditClient, err = grpcclient.NewAuditClient(workerAddress)
+	if err != nil {
+		log.Fatal("Failed to create audit gRPC client", zap.Error(err))
+	}
+	webhookClient, err = grpcclient.NewWebhookClient(workerAddress)
+	if err != nil {
+		log.Fatal("Failed to create webhook gRPC client", zap.Error(err))
+	}
+	scheduleClient, err = grpcclient.NewScheduleClient(workerAddress)
+	if err != nil {
+		log.Fatal("Failed to create schedule gRPC client", zap.Error(err))
+	}
 	logger = log
 }
 
@@ -42,33 +66,25 @@ func Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot hash password"})
 	}
 	user.Password = string(hashedPassword)
+	// Roles are granted by admins, never chosen by the client
+	user.Role = rbac.DefaultRole
 
-	// Insert user into database
-	if err := database.CreateUser(user); err != nil {
+	// The welcome email and user.registered are stored with the user and published by the outbox relay
+	outbox, err := registrationEvents(user)
+	if err != nil {
+		logger.Error("Failed to encode registration events", zap.Error(err), zap.String("userId", user.ID))
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot register user"})
 	}
-
-	emailEvent := map[string]string{
-		"userID":  user.ID,
-		"emailID": user.Email,
-	}
-	if err := producer.SendMessageToEmailTopic(user.Email, emailEvent); err != nil {
-		logger.Error("Failed to send email event ", zap.Error(err), zap.String("userId", user.ID))
-		logEvent := map[string]interface{}{
-			"message": "Failed to send email event",
-			"userId":  user.ID,
-			"error":   err.Error(),
-			"time":    time.Now().Format(time.RFC3339),
-		}
-		if logErr := producer.SendMessageToLogsTopic(user.ID, logEvent); logErr != nil {
-			logger.Error("Failed to send log event", zap.Error(logErr))
-		}
+	if err := database.Users.CreateUser(user, outbox...); err == database.ErrUserExists {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User ID or email already registered"})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot register user"})
 	}
 
-	registrationLog := map[string]interface{}{
-		"message": "User registered successfully",
-		"userId":  user.ID,
-		"time":    time.Now().Format(time.RFC3339),
+	registrationLog := events.Log{
+		Message: "User registered successfully",
+		UserID:  user.ID,
+		Time:    time.Now(),
 	}
 	if logErr := producer.SendMessageToLogsTopic(user.ID, registrationLog); logErr != nil {
 		logger.Error("Failed to send registration log", zap.Error(logErr))
@@ -82,33 +98,86 @@ func Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
 	}
 
-	var user models.User
-	err := database.DB.QueryRow("SELECT id, email, password, role FROM users WHERE id = ?", input.ID).Scan(&user.ID, &user.Email, &user.Password, &user.Role)
+	wait, err := loginGuard.LockedFor(input.ID, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check login attempts"})
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
+	found, err := database.Users.GetUserWithPassword(input.ID)
 	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	}
+	if found == nil {
+		return loginFailed(c, input.ID, "Invalid email or password")
+	}
+	user := *found
+	// checked before the password and answered like a wrong one, so a suspended
+	// account can't be used to confirm its password
+	if user.Suspended {
+		event := audit.NewEvent(events.AuditLoginFailed, events.AuditDenied)
+		event.ActorID = user.ID
+		event.IP = c.IP()
+		event.Details = map[string]string{"reason": "suspended"}
+		audit.Publish(event)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid email or password"})
 	}
 
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(input.Password))
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid email or password"})
+		return loginFailed(c, input.ID, "Invalid email or password")
 	}
-	if user.Role != input.Role || user.Email != input.Email || user.ID != input.ID {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user"})
+	if (input.Role != "" && user.Role != input.Role) || user.Email != input.Email || user.ID != input.ID {
+		return loginFailed(c, input.ID, "Invalid user")
 	}
+
+	return startSession(c, user)
+}
+
+// startSession continues a login whose first factor has been checked: users with
+// 2FA get a challenge token for LoginTwoFactor, everyone else an access token.
+func startSession(c *fiber.Ctx, user models.User) error {
+	tf, err := database.GetTwoFactor(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch 2FA settings"})
+	}
+	if tf != nil && tf.Enabled {
+		challenge, err := jwtpkg.GenerateChallengeToken(user.ID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate token"})
+		}
+		return c.JSON(fiber.Map{"two_factor_required": true, "challenge_token": challenge})
+	}
+
+	return completeLogin(c, user)
+}
+
+// completeLogin issues the access token once every login factor has been checked.
+func completeLogin(c *fiber.Ctx, user models.User) error {
+	if user.Suspended {
+		event := audit.NewEvent(events.AuditLoginFailed, events.AuditDenied)
+		event.ActorID = user.ID
+		event.IP = c.IP()
+		event.Details = map[string]string{"reason": "suspended"}
+		audit.Publish(event)
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Account suspended"})
+	}
+	if err := loginGuard.Reset(user.ID); err != nil {
+		logger.Error("Failed to reset failed login count", zap.Error(err), zap.String("userId", user.ID))
+	}
+
 	// Generate JWT token
 	token, err := jwtpkg.GenerateJWT(user)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate token"})
 	}
 
-	loginSuccessLog := map[string]interface{}{
-		"message": "User logged in successfully",
-		"userId":  input.ID,
-		"time":    time.Now().Format(time.RFC3339),
-	}
-	if logErr := producer.SendMessageToLogsTopic(input.ID, loginSuccessLog); logErr != nil {
-		logger.Error("Failed to send login success log", zap.Error(logErr))
-	}
+	event := audit.NewEvent(events.AuditLoginSucceeded, events.AuditSuccess)
+	event.ActorID = user.ID
+	event.IP = c.IP()
+	audit.Publish(event)
 
 	return c.JSON(fiber.Map{"token": token})
 }
@@ -128,7 +197,7 @@ func GetProfile(c *fiber.Ctx) error {
 	if is {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
-	user, err := database.GetUserById(userId)
+	user, err := database.Users.GetUserById(userId)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
 	}
@@ -175,14 +244,8 @@ func Logout(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Logout RPC call unsuccessful"})
 	}
 
-	logEvent := map[string]interface{}{
-		"message": "User logged out successfully",
-		"userId":  userId,
-		"time":    time.Now().Format(time.RFC3339),
-	}
-	if logErr := producer.SendMessageToLogsTopic(userId, logEvent); logErr != nil {
-		logger.Error("Failed to send logout success log", zap.Error(logErr))
-	}
+	publishUserEvent(events.UserLoggedOut, userId)
+	recordAudit(c, events.AuditLogout, userId, nil)
 
 	return c.JSON(fiber.Map{"message": "Logged out successfully"})
 }
@@ -197,7 +260,7 @@ func GetUsersWithUserRole(c *fiber.Ctx) error {
 	if is {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
-	users, err := database.GetUsersWithRole("user")
+	users, err := database.Users.GetUsersWithRole("user")
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch users"})
 	}
@@ -221,10 +284,36 @@ func GetUserByID(c *fiber.Ctx) error {
 	if userID != userId {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token and userID mismatch"})
 	}
-	user, err := database.GetUserById(userID)
+	user, err := database.Users.GetUserById(userID)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user"})
 	}
 
 	return c.JSON(user)
 }
+
+// publishUserEvent announces an account lifecycle event such as user.registered
+// on the users topic, where workerservice forwards it to webhooks.
+func publishUserEvent(eventType string, userId string) {
+	if err := producer.SendMessageToUserEventsTopic(userId, events.NewUserEvent(eventType, userId)); err != nil {
+		logger.Error("Failed to send user event", zap.Error(err), zap.String("type", eventType), zap.String("userId", userId))
+	}
+}
+
+// registrationEvents are the outbox events written together with a new user.
+func registrationEvents(user models.User) ([]database.OutboxEvent, error) {
+	emailEvent := events.Email{
+		Type:   events.EmailRegistration,
+		UserID: user.ID,
+		To:     user.Email,
+	}
+	email, err := database.NewOutboxEvent(config.EmailTopic, user.Email, emailEvent)
+	if err != nil {
+		return nil, err
+	}
+	registered, err := database.NewOutboxEvent(config.UserEventsTopic, user.ID, events.NewUserEvent(events.UserRegistered, user.ID))
+	if err != nil {
+		return nil, err
+	}
+	return []database.OutboxEvent{email, registered}, nil
+}
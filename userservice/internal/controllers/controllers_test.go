@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"chatapp/broker"
+	"userservice/database"
+	"userservice/internal/audit"
+	"userservice/internal/kafka"
+	"userservice/internal/utils"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+// setupControllers points the controllers at a fresh SQLite database in a
+// temporary directory, a miniredis and the memory broker, and returns the
+// miniredis for the services that need Redis too.
+func setupControllers(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// config.UserDBPath is relative to the working directory
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	logger = zap.NewNop()
+	database.Init(logger)
+	t.Cleanup(func() { database.DB.Close() })
+
+	redis := miniredis.RunT(t)
+	policy := utils.LockoutPolicy{Threshold: 100, Window: time.Hour, BaseLockout: time.Minute, MaxLockout: time.Minute}
+	loginGuard = utils.NewLoginGuard(redis.Addr(), policy, policy, logger)
+	blacklist = utils.NewBlacklist(redis.Addr(), logger)
+
+	producer = kafka.NewProducer(broker.NewMemory(), logger)
+	t.Cleanup(func() { producer.Close() })
+	audit.Init(producer, logger)
+	return redis
+}
@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"fmt"
+	"strings"
+	"time"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/incoming"
+	"userservice/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+func ListIncomingWebhooks(c *fiber.Ctx) error {
+	hooks, err := database.ListIncomingWebhooks()
+	if err != nil {
+		logger.Error("Failed to list incoming webhooks", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch incoming webhooks"})
+	}
+	return c.JSON(hooks)
+}
+
+// CreateIncomingWebhook responds with the webhook URL, which holds the secret
+// token and is never shown again.
+func CreateIncomingWebhook(c *fiber.Ctx) error {
+	var req models.CreateIncomingWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || strings.ContainsAny(req.Name, ", ") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Name is required and can't contain spaces or commas"})
+	}
+	if len(req.Recipients) == 0 || len(req.Recipients) > config.IncomingWebhookMaxRecipients {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("Between 1 and %d recipients are required", config.IncomingWebhookMaxRecipients)})
+	}
+	recipients := []string{}
+	seen := map[string]bool{}
+	for _, id := range req.Recipients {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		user, err := database.Users.GetUserById(id)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user"})
+		}
+		if user == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown recipient: " + id})
+		}
+		recipients = append(recipients, id)
+	}
+
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	id, token, tokenHash, err := incoming.Generate()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate token"})
+	}
+	hook := models.IncomingWebhook{
+		ID:         id,
+		Name:       req.Name,
+		Recipients: recipients,
+		CreatedBy:  claims["id"].(string),
+		CreatedAt:  time.Now().UTC(),
+	}
+	if err := database.CreateIncomingWebhook(hook, tokenHash); err == database.ErrIncomingWebhookNameTaken {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "An incoming webhook with this name exists"})
+	} else if err != nil {
+		logger.Error("Failed to store incoming webhook", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot create incoming webhook"})
+	}
+
+	recordAudit(c, events.AuditIncomingWebhookCreated, "", map[string]string{"webhookId": id, "name": hook.Name, "recipients": strings.Join(recipients, ",")})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"url": config.IncomingWebhookURL + "/" + id + "/" + token, "webhook": hook})
+}
+
+func DeleteIncomingWebhook(c *fiber.Ctx) error {
+	id := c.Params("hookID")
+	found, err := database.DeleteIncomingWebhook(id)
+	if err != nil {
+		logger.Error("Failed to delete incoming webhook", zap.Error(err), zap.String("webhookId", id))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete incoming webhook"})
+	}
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Incoming webhook not found"})
+	}
+
+	recordAudit(c, events.AuditIncomingWebhookDeleted, "", map[string]string{"webhookId": id})
+	return c.JSON(fiber.Map{"message": "Incoming webhook deleted"})
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"fmt"
+	"math"
+	"time"
+	"userservice/internal/audit"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// loginFailed records a failed login for the account and client IP, publishes the
+// security events and answers with the given error.
+func loginFailed(c *fiber.Ctx, userId string, message string) error {
+	ip := c.IP()
+	event := audit.NewEvent(events.AuditLoginFailed, events.AuditFailure)
+	event.ActorID = userId
+	event.IP = ip
+	event.Details = map[string]string{"reason": message}
+	audit.Publish(event)
+
+	lockout, err := loginGuard.RecordFailure(userId, ip)
+	if err != nil {
+		logger.Error("Failed to record failed login", zap.Error(err), zap.String("userId", userId))
+	}
+	if lockout.Account > 0 {
+		event := audit.NewEvent(events.AuditLoginLocked, events.AuditDenied)
+		event.TargetID = userId
+		event.IP = ip
+		event.Details = map[string]string{"scope": "account", "duration": lockout.Account.String()}
+		audit.Publish(event)
+	}
+	if lockout.IP > 0 {
+		event := audit.NewEvent(events.AuditLoginLocked, events.AuditDenied)
+		event.IP = ip
+		event.Details = map[string]string{"scope": "ip", "duration": lockout.IP.String()}
+		audit.Publish(event)
+	}
+
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": message})
+}
+
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many failed login attempts, try again later"})
+}
+
+// UnlockUser lets an admin lift an account lockout before it expires.
+func UnlockUser(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	if err := loginGuard.Reset(userID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to unlock user"})
+	}
+
+	recordAudit(c, events.AuditUserUnlocked, userID, nil)
+	return c.JSON(fiber.Map{"message": "User unlocked"})
+}
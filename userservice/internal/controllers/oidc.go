@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/audit"
+	"userservice/internal/models"
+	"userservice/internal/oidc"
+	"userservice/internal/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+// errLinkRequired means a local user has the identity's email but hasn't
+// verified it, so the user has to sign in and link the identity with OIDCLink.
+var errLinkRequired = errors.New("identity must be linked by the signed in user")
+
+// OIDCLogin redirects the browser to the identity provider.
+func OIDCLogin(c *fiber.Ctx) error {
+	url, state, err := oidcProvider.AuthURL(c.Context(), "")
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Identity provider unavailable"})
+	}
+	setStateCookie(c, state)
+	return c.Redirect(url, fiber.StatusFound)
+}
+
+// OIDCLink starts a provider login that links the identity to the signed in user
+// instead of signing in. The client sends the browser to the returned URL.
+func OIDCLink(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	url, state, err := oidcProvider.AuthURL(c.Context(), userId)
+	if err != nil {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Identity provider unavailable"})
+	}
+	setStateCookie(c, state)
+	return c.JSON(fiber.Map{"url": url})
+}
+
+func setStateCookie(c *fiber.Ctx, state string) {
+	c.Cookie(&fiber.Cookie{
+		Name:     oidc.StateCookie,
+		Value:    oidcProvider.SignState(state),
+		Path:     "/api/v1/oidc",
+		MaxAge:   int(config.OIDCStateTTL.Seconds()),
+		Secure:   strings.HasPrefix(config.OIDCRedirectURL, "https://"),
+		HTTPOnly: true,
+		// Lax still sends it on the provider's redirect back to the callback
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// OIDCCallback is where the provider sends the browser back. The provider's
+// identity is linked to an existing user with the same verified email, or a new
+// user is created, and the login continues like a password login. A login
+// started with OIDCLink links the identity to that user instead.
+func OIDCCallback(c *fiber.Ctx) error {
+	if providerErr := c.Query("error"); providerErr != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Login cancelled by identity provider", "reason": providerErr})
+	}
+	code, state := c.Query("code"), c.Query("state")
+	if code == "" || state == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Code and state are required"})
+	}
+	if !oidcProvider.CheckState(c.Cookies(oidc.StateCookie), state) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Login was not started in this browser"})
+	}
+	c.ClearCookie(oidc.StateCookie)
+
+	identity, linkUserId, err := oidcProvider.Exchange(c.Context(), code, state)
+	if err == oidc.ErrInvalidState {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired login state"})
+	} else if err != nil {
+		logger.Error("OIDC login failed", zap.Error(err))
+		event := audit.NewEvent(events.AuditLoginFailed, events.AuditFailure)
+		event.IP = c.IP()
+		event.Details = map[string]string{"method": "oidc", "provider": oidcProvider.Name(), "reason": err.Error()}
+		audit.Publish(event)
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Identity provider login failed"})
+	}
+
+	if linkUserId != "" {
+		return linkIdentity(c, linkUserId, identity)
+	}
+
+	user, err := identityUser(c, identity)
+	if err == errLinkRequired {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "An account with this email exists; sign in and link the identity provider from your account"})
+	} else if err != nil {
+		logger.Error("Failed to resolve OIDC identity", zap.Error(err), zap.String("subject", identity.Subject))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	}
+	if user == nil {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Identity provider did not return a verified email"})
+	}
+	return startSession(c, *user)
+}
+
+// linkIdentity links the identity to the user that started the login with OIDCLink.
+func linkIdentity(c *fiber.Ctx, userId string, identity *oidc.Identity) error {
+	linked, err := database.GetIdentityUser(identity.Issuer, identity.Subject)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to link identity"})
+	}
+	if linked == userId {
+		return c.JSON(fiber.Map{"message": "Identity already linked"})
+	} else if linked != "" {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Identity is linked to another account"})
+	}
+	if err := database.LinkIdentity(userId, identity.Issuer, identity.Subject, identity.Email); err != nil {
+		logger.Error("Failed to link identity", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to link identity"})
+	}
+	auditIdentityLinked(c, userId, identity)
+	return c.JSON(fiber.Map{"message": "Identity linked"})
+}
+
+// identityUser returns the user linked to the identity, linking or creating one
+// by verified email on first login. It returns nil if there is nothing to link
+// by, and errLinkRequired if the local user with that email hasn't verified it.
+func identityUser(c *fiber.Ctx, identity *oidc.Identity) (*models.User, error) {
+	userId, err := database.GetIdentityUser(identity.Issuer, identity.Subject)
+	if err != nil {
+		return nil, err
+	}
+	if userId != "" {
+		return database.Users.GetUserById(userId)
+	}
+
+	// Linking by an unverified email would let anyone who controls the provider
+	// account take over the local user with that address
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, nil
+	}
+	user, err := database.Users.GetUserByEmail(identity.Email)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		if user, err = createIdentityUser(identity); err != nil {
+			return nil, err
+		}
+	} else if !user.EmailVerified {
+		// whoever registered the address may not own it
+		return nil, errLinkRequired
+	}
+
+	if err := database.LinkIdentity(user.ID, identity.Issuer, identity.Subject, identity.Email); err != nil {
+		return nil, err
+	}
+	auditIdentityLinked(c, user.ID, identity)
+	return user, nil
+}
+
+func auditIdentityLinked(c *fiber.Ctx, userId string, identity *oidc.Identity) {
+	event := audit.NewEvent(events.AuditIdentityLinked, events.AuditSuccess)
+	event.TargetID = userId
+	event.IP = c.IP()
+	event.Details = map[string]string{"provider": oidcProvider.Name(), "issuer": identity.Issuer, "subject": identity.Subject}
+	audit.Publish(event)
+}
+
+// createIdentityUser registers a user for a provider identity. It has no password,
+// so it can only log in through the provider until one is set with ForgotPassword.
+func createIdentityUser(identity *oidc.Identity) (*models.User, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	user := models.User{
+		ID:    "oidc-" + hex.EncodeToString(b),
+		Email: identity.Email,
+		// the provider verified it
+		EmailVerified: true,
+		Role:          rbac.DefaultRole,
+	}
+	outbox, err := registrationEvents(user)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.Users.CreateUser(user, outbox...); err != nil {
+		return nil, err
+	}
+	if identity.Name != "" {
+		if err := database.Users.UpdateProfile(user.ID, &identity.Name, nil, nil); err != nil {
+			logger.Error("Failed to store display name", zap.Error(err), zap.String("userId", user.ID))
+		}
+	}
+	return database.Users.GetUserById(user.ID)
+}
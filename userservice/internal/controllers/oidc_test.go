@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"userservice/database"
+	"userservice/internal/models"
+	"userservice/internal/oidc"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testIssuer is an OpenID Connect provider serving discovery, its JWKS and a
+// token endpoint that checks the PKCE verifier of every code it hands out.
+type testIssuer struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	logins map[string]issuedCode
+}
+
+type issuedCode struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &testIssuer{key: key, logins: map[string]issuedCode{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                iss.server.URL,
+			"authorization_endpoint":                iss.server.URL + "/authorize",
+			"token_endpoint":                        iss.server.URL + "/token",
+			"jwks_uri":                              iss.server.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", iss.token)
+	iss.server = httptest.NewServer(mux)
+	t.Cleanup(iss.server.Close)
+	return iss
+}
+
+func (iss *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	iss.mu.Lock()
+	login, ok := iss.logins[r.PostForm.Get("code")]
+	delete(iss.logins, r.PostForm.Get("code"))
+	iss.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != login.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss": iss.server.URL,
+		"aud": "chatapp",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	for k, v := range login.claims {
+		claims[k] = v
+	}
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	raw, err := idToken.SignedString(iss.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "at", "token_type": "Bearer", "id_token": raw})
+}
+
+// authorize plays the user signing in at the provider: it hands out a code for
+// the login of authURL whose ID token has the given claims, and the nonce of
+// the login unless the claims set one.
+func (iss *testIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("login doesn't use PKCE: %s", authURL)
+	}
+	if _, ok := claims["nonce"]; !ok {
+		claims["nonce"] = query.Get("nonce")
+	}
+	code := "code-" + query.Get("state")
+	iss.mu.Lock()
+	iss.logins[code] = issuedCode{challenge: query.Get("code_challenge"), claims: claims}
+	iss.mu.Unlock()
+	return code
+}
+
+func newOIDCTestApp(t *testing.T) (*fiber.App, *testIssuer) {
+	t.Helper()
+	redis := setupControllers(t)
+	iss := newTestIssuer(t)
+	oidcProvider = oidc.NewProvider(oidc.Config{
+		Name:         "test",
+		IssuerURL:    iss.server.URL,
+		ClientID:     "chatapp",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/api/v1/oidc/callback",
+		StateTTL:     time.Minute,
+		StateSecret:  "state-secret",
+	}, redis.Addr(), logger)
+
+	app := fiber.New()
+	app.Get("/api/v1/oidc/login", OIDCLogin)
+	app.Get("/api/v1/oidc/callback", OIDCCallback)
+	return app, iss
+}
+
+// startLogin starts a login and returns the provider URL and the state cookie.
+func startLogin(t *testing.T, app *fiber.App) (string, *http.Cookie) {
+	t.Helper()
+	resp, err := app.Test(httptest.NewRequest("GET", "/api/v1/oidc/login", nil), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusFound {
+		t.Fatalf("login status = %d", resp.StatusCode)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == oidc.StateCookie {
+			return resp.Header.Get("Location"), cookie
+		}
+	}
+	t.Fatal("login set no state cookie")
+	return "", nil
+}
+
+// callback returns the response of the callback for the code and the state of authURL.
+func callback(t *testing.T, app *fiber.App, authURL string, code string, cookie *http.Cookie) (int, map[string]interface{}) {
+	t.Helper()
+	u, _ := url.Parse(authURL)
+	query := url.Values{"code": {code}, "state": {u.Query().Get("state")}}
+	req := httptest.NewRequest("GET", "/api/v1/oidc/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return resp.StatusCode, body
+}
+
+func identityClaims(subject string, email string) jwt.MapClaims {
+	return jwt.MapClaims{"sub": subject, "email": email, "email_verified": true, "name": "Alice"}
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	authURL, cookie := startLogin(t, app)
+	status, body := callback(t, app, authURL, iss.authorize(t, authURL, identityClaims("sub-1", "alice@example.com")), cookie)
+	if status != fiber.StatusOK || body["token"] == nil {
+		t.Fatalf("callback = %d %v", status, body)
+	}
+
+	userId, err := database.GetIdentityUser(iss.server.URL, "sub-1")
+	if err != nil || !strings.HasPrefix(userId, "oidc-") {
+		t.Fatalf("identity linked to %q, %v", userId, err)
+	}
+	user, err := database.Users.GetUserWithPassword(userId)
+	if err != nil || user.Email != "alice@example.com" || user.Password != "" {
+		t.Fatalf("created user = %+v, %v", user, err)
+	}
+}
+
+func TestOIDCLoginLinksVerifiedEmail(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	if err := database.Users.CreateUser(models.User{ID: "alice", Email: "Alice@example.com", EmailVerified: true, Password: "hash", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	authURL, cookie := startLogin(t, app)
+	status, body := callback(t, app, authURL, iss.authorize(t, authURL, identityClaims("sub-1", "alice@example.com")), cookie)
+	if status != fiber.StatusOK || body["token"] == nil {
+		t.Fatalf("callback = %d %v", status, body)
+	}
+	if userId, err := database.GetIdentityUser(iss.server.URL, "sub-1"); err != nil || userId != "alice" {
+		t.Fatalf("identity linked to %q, %v", userId, err)
+	}
+}
+
+func TestOIDCLoginRefusesUnverifiedEmail(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	if err := database.Users.CreateUser(models.User{ID: "alice", Email: "alice@example.com", Password: "hash", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the local user hasn't verified the address
+	authURL, cookie := startLogin(t, app)
+	status, _ := callback(t, app, authURL, iss.authorize(t, authURL, identityClaims("sub-1", "alice@example.com")), cookie)
+	if status != fiber.StatusConflict {
+		t.Fatalf("callback status = %d, want %d", status, fiber.StatusConflict)
+	}
+
+	// the provider hasn't verified it
+	claims := identityClaims("sub-2", "bob@example.com")
+	claims["email_verified"] = false
+	authURL, cookie = startLogin(t, app)
+	status, _ = callback(t, app, authURL, iss.authorize(t, authURL, claims), cookie)
+	if status != fiber.StatusForbidden {
+		t.Fatalf("callback status = %d, want %d", status, fiber.StatusForbidden)
+	}
+	for _, subject := range []string{"sub-1", "sub-2"} {
+		if userId, _ := database.GetIdentityUser(iss.server.URL, subject); userId != "" {
+			t.Fatalf("%s linked to %q", subject, userId)
+		}
+	}
+}
+
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	authURL, _ := startLogin(t, app)
+	_, otherCookie := startLogin(t, app)
+	code := iss.authorize(t, authURL, identityClaims("sub-1", "alice@example.com"))
+
+	if status, _ := callback(t, app, authURL, code, nil); status != fiber.StatusBadRequest {
+		t.Fatalf("callback without cookie = %d", status)
+	}
+	// the cookie of a login started by someone else
+	if status, _ := callback(t, app, authURL, code, otherCookie); status != fiber.StatusBadRequest {
+		t.Fatalf("callback with another login's cookie = %d", status)
+	}
+}
+
+func TestOIDCCallbackStateUsedOnce(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	authURL, cookie := startLogin(t, app)
+	code := iss.authorize(t, authURL, identityClaims("sub-1", "alice@example.com"))
+	if status, _ := callback(t, app, authURL, code, cookie); status != fiber.StatusOK {
+		t.Fatalf("first callback = %d", status)
+	}
+	if status, body := callback(t, app, authURL, code, cookie); status != fiber.StatusBadRequest {
+		t.Fatalf("replayed callback = %d %v", status, body)
+	}
+}
+
+func TestOIDCCallbackNonceMismatch(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	authURL, cookie := startLogin(t, app)
+	claims := identityClaims("sub-1", "alice@example.com")
+	claims["nonce"] = "nonce-of-another-login"
+	if status, _ := callback(t, app, authURL, iss.authorize(t, authURL, claims), cookie); status != fiber.StatusUnauthorized {
+		t.Fatalf("callback status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+	if userId, _ := database.GetIdentityUser(iss.server.URL, "sub-1"); userId != "" {
+		t.Fatalf("identity linked to %q", userId)
+	}
+}
+
+func TestOIDCCallbackPKCE(t *testing.T) {
+	app, iss := newOIDCTestApp(t)
+	authURL, cookie := startLogin(t, app)
+	code := iss.authorize(t, authURL, identityClaims("sub-1", "alice@example.com"))
+
+	// a code issued to a login with another verifier, as an intercepted one would be
+	iss.mu.Lock()
+	login := iss.logins[code]
+	sum := sha256.Sum256([]byte("attacker-verifier"))
+	login.challenge = base64.RawURLEncoding.EncodeToString(sum[:])
+	iss.logins[code] = login
+	iss.mu.Unlock()
+
+	if status, _ := callback(t, app, authURL, code, cookie); status != fiber.StatusUnauthorized {
+		t.Fatalf("callback status = %d, want %d", status, fiber.StatusUnauthorized)
+	}
+}
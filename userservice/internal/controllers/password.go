@@ -0,0 +1,126 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/audit"
+	"userservice/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ForgotPassword always answers with the same message so that it can't be used
+// to probe which accounts exist.
+func ForgotPassword(c *fiber.Ctx) error {
+	var req models.ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	response := fiber.Map{"message": "If the account exists, a password reset email has been sent"}
+
+	user, err := database.Users.GetUserById(req.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	}
+	if user == nil || user.Email != req.Email {
+		return c.JSON(response)
+	}
+
+	token, tokenHash, err := generateOneTimeToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate reset token"})
+	}
+
+	// the email is stored with the token and published by the outbox relay
+	emailEvent := events.Email{
+		Type:   events.EmailPasswordReset,
+		UserID: user.ID,
+		To:     user.Email,
+		Token:  token,
+	}
+	email, err := database.NewOutboxEvent(config.EmailTopic, user.Email, emailEvent)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot create password reset"})
+	}
+	if err := database.CreatePasswordReset(tokenHash, user.ID, time.Now().Add(config.PasswordResetTTL), email); err != nil {
+		logger.Error("Failed to store password reset", zap.Error(err), zap.String("userId", user.ID))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot create password reset"})
+	}
+
+	logEvent := events.Log{
+		Message: "Password reset requested",
+		UserID:  user.ID,
+		Time:    time.Now(),
+	}
+	if logErr := producer.SendMessageToLogsTopic(user.ID, logEvent); logErr != nil {
+		logger.Error("Failed to send password reset log", zap.Error(logErr))
+	}
+	return c.JSON(response)
+}
+
+func ResetPassword(c *fiber.Ctx) error {
+	var req models.ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if req.Token == "" || req.Password == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Token and password are required"})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot hash password"})
+	}
+	userId, err := database.ResetPassword(hashOneTimeToken(req.Token), string(hashedPassword))
+	if err != nil {
+		logger.Error("Failed to reset password", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to reset password"})
+	}
+	if userId == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired token"})
+	}
+
+	revokeSessions(userId)
+	revokeUserAPIKeys(userId)
+	if err := loginGuard.Reset(userId); err != nil {
+		logger.Error("Failed to reset failed login count", zap.Error(err), zap.String("userId", userId))
+	}
+
+	event := audit.NewEvent(events.AuditPasswordReset, events.AuditSuccess)
+	event.ActorID = userId
+	event.IP = c.IP()
+	audit.Publish(event)
+	return c.JSON(fiber.Map{"message": "Password reset successfully"})
+}
+
+// revokeSessions invalidates every outstanding token of the user and drops their
+// WebSocket connection. Failures are logged; the password change itself has already happened.
+func revokeSessions(userId string) {
+	if err := blacklist.RevokeUser(userId, config.JWTExpiration); err != nil {
+		logger.Error("Failed to revoke user tokens", zap.Error(err), zap.String("userId", userId))
+	}
+	if _, err := grpcClient.Logout(userId); err != nil {
+		logger.Error("Failed to call Logout RPC", zap.Error(err), zap.String("userId", userId))
+	}
+}
+
+func generateOneTimeToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashOneTimeToken(token), nil
+}
+
+func hashOneTimeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
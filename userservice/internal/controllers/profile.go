@@ -0,0 +1,199 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"time"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/audit"
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func UpdateProfile(c *fiber.Ctx) error {
+	var req models.UpdateProfileRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	if err := database.Users.UpdateProfile(userId, req.DisplayName, req.AvatarURL, req.StatusText); err != nil {
+		logger.Error("Failed to update profile", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update profile"})
+	}
+	user, err := database.Users.GetUserById(userId)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	}
+	return c.JSON(user)
+}
+
+// ChangeEmail starts an email change. The address is only updated once the
+// token sent to the new address is confirmed through VerifyEmail.
+func ChangeEmail(c *fiber.Ctx) error {
+	var req models.ChangeEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Email is required"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	if reason, err := reauthenticate(claims, req.Password); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	} else if reason != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": reason})
+	}
+	// checked again on confirmation, as the address can be taken in between
+	if existing, err := database.Users.GetUserByEmail(req.Email); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot change email"})
+	} else if existing != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already registered"})
+	}
+
+	token, tokenHash, err := generateOneTimeToken()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate verification token"})
+	}
+
+	// the email is stored with the change and published by the outbox relay
+	emailEvent := events.Email{
+		Type:   events.EmailVerification,
+		UserID: userId,
+		To:     req.Email,
+		Token:  token,
+	}
+	email, err := database.NewOutboxEvent(config.EmailTopic, req.Email, emailEvent)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot change email"})
+	}
+	if err := database.CreateEmailChange(tokenHash, userId, req.Email, time.Now().Add(config.EmailVerificationTTL), email); err != nil {
+		logger.Error("Failed to store email change", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot change email"})
+	}
+	return c.JSON(fiber.Map{"message": "Verification email sent to the new address"})
+}
+
+func VerifyEmail(c *fiber.Ctx) error {
+	var req models.VerifyEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+
+	userId, newEmail, err := database.ConfirmEmailChange(hashOneTimeToken(req.Token))
+	if err == database.ErrUserExists {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email already registered"})
+	} else if err != nil {
+		logger.Error("Failed to confirm email change", zap.Error(err))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify email"})
+	}
+	if userId == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired token"})
+	}
+
+	event := audit.NewEvent(events.AuditEmailChanged, events.AuditSuccess)
+	event.ActorID = userId
+	event.IP = c.IP()
+	event.Details = map[string]string{"email": newEmail}
+	audit.Publish(event)
+	return c.JSON(fiber.Map{"message": "Email updated"})
+}
+
+// ChangePassword requires the current password, or a fresh sign-in for users who
+// don't have one yet, and signs the user out everywhere,
+// including the token used for this request, and revokes their API keys.
+func ChangePassword(c *fiber.Ctx) error {
+	var req models.ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if req.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "New password is required"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	if reason, err := reauthenticate(claims, req.CurrentPassword); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	} else if reason != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": reason})
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot hash password"})
+	}
+	if err := database.Users.UpdatePassword(userId, string(hashedPassword)); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to change password"})
+	}
+
+	revokeSessions(userId)
+	revokeUserAPIKeys(userId)
+
+	recordAudit(c, events.AuditPasswordChanged, userId, nil)
+	return c.JSON(fiber.Map{"message": "Password changed, please login again"})
+}
+
+// DeleteAccount removes the caller's account, ends all their sessions and tells
+// other services through a user.deleted event.
+func DeleteAccount(c *fiber.Ctx) error {
+	var req models.DeleteAccountRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	if reason, err := reauthenticate(claims, req.Password); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user information"})
+	} else if reason != "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": reason})
+	}
+
+	if err := database.Users.DeleteUser(userId); err == database.ErrLastUserManager {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "At least one user must keep " + rbac.RolesManage})
+	} else if err != nil {
+		logger.Error("Failed to delete user", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to delete account"})
+	}
+	revokeSessions(userId)
+
+	publishUserEvent(events.UserDeleted, userId)
+
+	recordAudit(c, events.AuditUserDeleted, userId, nil)
+	return c.JSON(fiber.Map{"message": "Account deleted"})
+}
+
+// reauthenticate confirms that the caller may make a sensitive change to their
+// account and returns why not otherwise. Users with a password give it again.
+// Users who only sign in through the identity provider have none, so they sign
+// in there again instead, and must use a token from the last few minutes.
+func reauthenticate(claims jwt.MapClaims, password string) (string, error) {
+	userId := claims["id"].(string)
+	user, err := database.Users.GetUserWithPassword(userId)
+	if err != nil {
+		return "", err
+	}
+	if user == nil {
+		return "Invalid password", nil
+	}
+	if user.Password == "" {
+		issued, _ := claims["iat"].(float64)
+		if time.Since(time.UnixMilli(int64(issued*1000))) > config.OIDCReauthMaxAge {
+			return "Sign in again with " + config.OIDCProviderName + " to confirm this change", nil
+		}
+		return "", nil
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+		return "Invalid password", nil
+	}
+	return "", nil
+}
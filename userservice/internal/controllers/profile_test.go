@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"userservice/database"
+	"userservice/internal/models"
+
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func tokenClaims(userId string, issued time.Time) jwt.MapClaims {
+	return jwt.MapClaims{"id": userId, "iat": float64(issued.UnixMilli()) / 1000}
+}
+
+func TestReauthenticateWithPassword(t *testing.T) {
+	setupControllers(t)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err := database.Users.CreateUser(models.User{ID: "alice", Email: "alice@example.com", Password: string(hash), Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason, err := reauthenticate(tokenClaims("alice", time.Now()), "wrong"); err != nil || reason == "" {
+		t.Fatalf("wrong password = %q, %v", reason, err)
+	}
+	// a fresh token doesn't replace the password
+	if reason, err := reauthenticate(tokenClaims("alice", time.Now()), ""); err != nil || reason == "" {
+		t.Fatalf("no password = %q, %v", reason, err)
+	}
+	if reason, err := reauthenticate(tokenClaims("alice", time.Now().Add(-time.Hour)), "secret"); err != nil || reason != "" {
+		t.Fatalf("right password = %q, %v", reason, err)
+	}
+}
+
+func TestReauthenticateWithoutPassword(t *testing.T) {
+	setupControllers(t)
+	if err := database.Users.CreateUser(models.User{ID: "oidc-1", Email: "alice@example.com", EmailVerified: true, Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if reason, err := reauthenticate(tokenClaims("oidc-1", time.Now()), ""); err != nil || reason != "" {
+		t.Fatalf("fresh sign-in = %q, %v", reason, err)
+	}
+	if reason, err := reauthenticate(tokenClaims("oidc-1", time.Now().Add(-time.Hour)), ""); err != nil || reason == "" {
+		t.Fatalf("old sign-in = %q, %v", reason, err)
+	}
+}
@@ -0,0 +1,74 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"strings"
+	"userservice/database"
+	"userservice/internal/models"
+	"userservice/internal/rbac"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+func ListRoles(c *fiber.Ctx) error {
+	roles, err := database.Roles.ListRoles()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch roles"})
+	}
+	return c.JSON(fiber.Map{"roles": roles, "permissions": rbac.All})
+}
+
+// SaveRole creates a role or replaces its permissions.
+func SaveRole(c *fiber.Ctx) error {
+	var role models.Role
+	if err := c.BodyParser(&role); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	role.Name = c.Params("role")
+	for _, permission := range role.Permissions {
+		if !rbac.IsValid(permission) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown permission " + permission})
+		}
+	}
+	if err := database.Roles.SaveRole(role); err == database.ErrLastRoleManager {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "At least one role must keep " + rbac.RolesManage})
+	} else if err != nil {
+		logger.Error("Failed to save role", zap.Error(err), zap.String("role", role.Name))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save role"})
+	}
+
+	recordAudit(c, events.AuditRoleSaved, role.Name, map[string]string{"permissions": strings.Join(role.Permissions, ",")})
+	return c.JSON(role)
+}
+
+func AssignRole(c *fiber.Ctx) error {
+	var req models.AssignRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	userID := c.Params("userID")
+
+	exists, err := database.Roles.RoleExists(req.Role)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch roles"})
+	}
+	if !exists {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown role"})
+	}
+	user, err := database.Users.GetUserById(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch user"})
+	}
+	if user == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+	if err := database.Users.UpdateRole(userID, req.Role); err == database.ErrLastUserManager {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "At least one user must keep " + rbac.RolesManage})
+	} else if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to assign role"})
+	}
+
+	recordAudit(c, events.AuditUserRoleChanged, userID, map[string]string{"oldRole": user.Role, "newRole": req.Role})
+	return c.JSON(fiber.Map{"message": "Role assigned", "id": userID, "role": req.Role})
+}
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"chatapp/slash"
+	"time"
+	"userservice/internal/models"
+	pb "userservice/proto"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// CreateSchedule stores a message or reminder that workerservice sends later.
+func CreateSchedule(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	var req models.ScheduleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	if req.Kind == "" {
+		req.Kind = "message"
+	}
+	sendAt := req.SendAt
+	if req.In != "" {
+		delay, err := slash.ParseDelay(req.In)
+		if err != nil || req.SendAt != "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Give either send_at or a delay such as 2h or 1d in in"})
+		}
+		sendAt = time.Now().Add(delay).UTC().Format(time.RFC3339)
+	}
+
+	sched, err := scheduleClient.Create(&pb.CreateScheduleRequest{
+		Owner:      userId,
+		Kind:       req.Kind,
+		Recipients: req.Recipients,
+		Message:    req.Message,
+		SendAt:     sendAt,
+	})
+	if err != nil {
+		return scheduleError(c, err, "Failed to create schedule")
+	}
+	return c.Status(fiber.StatusCreated).JSON(sched)
+}
+
+// ListSchedules returns the caller's pending schedules, soonest first.
+func ListSchedules(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	resp, err := scheduleClient.List(claims["id"].(string))
+	if err != nil {
+		return scheduleError(c, err, "Failed to fetch schedules")
+	}
+	schedules := resp.GetSchedules()
+	if schedules == nil {
+		schedules = []*pb.Schedule{}
+	}
+	return c.JSON(schedules)
+}
+
+func CancelSchedule(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	id, err := c.ParamsInt("scheduleID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid schedule id"})
+	}
+	if err := scheduleClient.Cancel(int64(id), claims["id"].(string)); err != nil {
+		return scheduleError(c, err, "Failed to cancel schedule")
+	}
+	return c.JSON(fiber.Map{"message": "Schedule canceled"})
+}
+
+func scheduleError(c *fiber.Ctx, err error, message string) error {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": status.Convert(err).Message()})
+	case codes.ResourceExhausted:
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many pending schedules"})
+	case codes.NotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Schedule not found"})
+	case codes.PermissionDenied:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Forbidden"})
+	}
+	logger.Error(message, zap.Error(err))
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": message})
+}
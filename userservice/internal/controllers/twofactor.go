@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+	"userservice/config"
+	"userservice/database"
+	"userservice/internal/jwtpkg"
+	"userservice/internal/models"
+	"userservice/internal/totp"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+// EnrollTwoFactor generates a new TOTP secret for the caller. 2FA stays inactive
+// until the first code is confirmed through VerifyTwoFactor.
+func EnrollTwoFactor(c *fiber.Ctx) error {
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate secret"})
+	}
+	saved, err := database.SaveTwoFactorSecret(userId, secret)
+	if err != nil {
+		logger.Error("Failed to store 2FA secret", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot start 2FA enrollment"})
+	}
+	if !saved {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "2FA is already enabled"})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(config.TOTPIssuer, userId, secret),
+	})
+}
+
+// VerifyTwoFactor activates 2FA once the caller proves their authenticator works,
+// and returns the recovery codes. They are shown only this once.
+func VerifyTwoFactor(c *fiber.Ctx) error {
+	var req models.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	tf, err := database.GetTwoFactor(userId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch 2FA settings"})
+	}
+	if tf == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "2FA enrollment not started"})
+	}
+	if tf.Enabled {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "2FA is already enabled"})
+	}
+	step, ok := totp.Validate(tf.Secret, req.Code, time.Now(), 1)
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	codes, hashes, err := generateRecoveryCodes(config.RecoveryCodeCount)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot generate recovery codes"})
+	}
+	if err := database.EnableTwoFactor(userId, step, hashes); err != nil {
+		logger.Error("Failed to enable 2FA", zap.Error(err), zap.String("userId", userId))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot enable 2FA"})
+	}
+
+	recordAudit(c, events.AuditTwoFactorEnabled, userId, nil)
+	return c.JSON(fiber.Map{"message": "2FA enabled", "recovery_codes": codes})
+}
+
+// DisableTwoFactor turns 2FA off; it requires a current TOTP or recovery code.
+func DisableTwoFactor(c *fiber.Ctx) error {
+	var req models.TwoFactorCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+	userId := claims["id"].(string)
+
+	tf, err := database.GetTwoFactor(userId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch 2FA settings"})
+	}
+	if tf == nil || !tf.Enabled {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "2FA is not enabled"})
+	}
+	ok, err := checkSecondFactor(tf, req.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !ok {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+	}
+	if err := database.DisableTwoFactor(userId); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot disable 2FA"})
+	}
+
+	recordAudit(c, events.AuditTwoFactorDisabled, userId, nil)
+	return c.JSON(fiber.Map{"message": "2FA disabled"})
+}
+
+// LoginTwoFactor is the second Login step: it exchanges the challenge token and
+// a TOTP or recovery code for the real JWT.
+func LoginTwoFactor(c *fiber.Ctx) error {
+	var req models.TwoFactorLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	userId, challengeID, err := jwtpkg.ParseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired challenge"})
+	}
+
+	wait, err := loginGuard.LockedFor(userId, c.IP())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to check login attempts"})
+	}
+	if wait > 0 {
+		return tooManyAttempts(c, wait)
+	}
+
+	tf, err := database.GetTwoFactor(userId)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch 2FA settings"})
+	}
+	if tf == nil || !tf.Enabled {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired challenge"})
+	}
+	ok, err := checkSecondFactor(tf, req.Code)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !ok {
+		return loginFailed(c, userId, "Invalid code")
+	}
+	// a challenge can be retried after a wrong code but only exchanged once
+	fresh, err := blacklist.UseOnce("challenge:"+challengeID, config.TwoFactorChallengeTTL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to verify challenge"})
+	}
+	if !fresh {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired challenge"})
+	}
+
+	user, err := database.Users.GetUserById(userId)
+	if err != nil || user == nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user"})
+	}
+	return completeLogin(c, *user)
+}
+
+// checkSecondFactor accepts either a TOTP code that has not been used before or
+// an unused recovery code, burning whichever one matched.
+func checkSecondFactor(tf *database.TwoFactor, code string) (bool, error) {
+	if step, ok := totp.Validate(tf.Secret, code, time.Now(), 1); ok {
+		return database.UseTwoFactorStep(tf.UserID, step)
+	}
+	return database.UseRecoveryCode(tf.UserID, hashRecoveryCode(code))
+}
+
+func generateRecoveryCodes(n int) ([]string, []string, error) {
+	codes := make([]string, n)
+	hashes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := hex.EncodeToString(b)
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
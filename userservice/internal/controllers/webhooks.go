@@ -0,0 +1,108 @@
+package controllers
+
+import (
+	"chatapp/events"
+	"strconv"
+	"userservice/internal/models"
+	pb "userservice/proto"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func ListWebhooks(c *fiber.Ctx) error {
+	resp, err := webhookClient.List()
+	if err != nil {
+		return webhookError(c, err, "Failed to fetch webhooks")
+	}
+	webhooks := resp.GetWebhooks()
+	if webhooks == nil {
+		webhooks = []*pb.Webhook{}
+	}
+	return c.JSON(webhooks)
+}
+
+// CreateWebhook responds with the signing secret, which is never shown again.
+func CreateWebhook(c *fiber.Ctx) error {
+	var req models.CreateWebhookRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse JSON"})
+	}
+	resp, err := webhookClient.Create(req.URL, req.Events, req.Secret)
+	if err != nil {
+		return webhookError(c, err, "Failed to create webhook")
+	}
+
+	webhook := resp.GetWebhook()
+	recordAudit(c, events.AuditWebhookCreated, "", map[string]string{"webhookId": strconv.FormatInt(webhook.GetId(), 10), "url": webhook.GetUrl()})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"secret": resp.GetSecret(), "webhook": webhook})
+}
+
+// UpdateWebhook enables or disables a webhook. Enabling one that was disabled
+// for failing resets its failure count.
+func UpdateWebhook(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("webhookID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid webhook id"})
+	}
+	var req models.UpdateWebhookRequest
+	if err := c.BodyParser(&req); err != nil || req.Enabled == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "enabled is required"})
+	}
+	webhook, err := webhookClient.SetEnabled(int64(id), *req.Enabled)
+	if err != nil {
+		return webhookError(c, err, "Failed to update webhook")
+	}
+
+	recordAudit(c, events.AuditWebhookUpdated, "", map[string]string{"webhookId": c.Params("webhookID"), "enabled": strconv.FormatBool(*req.Enabled)})
+	return c.JSON(webhook)
+}
+
+func DeleteWebhook(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("webhookID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid webhook id"})
+	}
+	if err := webhookClient.Delete(int64(id)); err != nil {
+		return webhookError(c, err, "Failed to delete webhook")
+	}
+
+	recordAudit(c, events.AuditWebhookDeleted, "", map[string]string{"webhookId": c.Params("webhookID")})
+	return c.JSON(fiber.Map{"message": "Webhook deleted"})
+}
+
+// ListWebhookDeliveries returns the most recent deliveries of a webhook, newest first.
+func ListWebhookDeliveries(c *fiber.Ctx) error {
+	id, err := c.ParamsInt("webhookID")
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid webhook id"})
+	}
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > maxPageSize {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid limit"})
+	}
+	resp, err := webhookClient.Deliveries(int64(id), limit)
+	if err != nil {
+		return webhookError(c, err, "Failed to fetch deliveries")
+	}
+	deliveries := resp.GetDeliveries()
+	if deliveries == nil {
+		deliveries = []*pb.Delivery{}
+	}
+	return c.JSON(deliveries)
+}
+
+// webhookError passes validation and not found errors from workerservice on to
+// the client; anything else is reported as a gateway failure.
+func webhookError(c *fiber.Ctx, err error, message string) error {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": status.Convert(err).Message()})
+	case codes.NotFound:
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Webhook not found"})
+	}
+	logger.Error(message, zap.Error(err))
+	return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": message})
+}
@@ -0,0 +1,49 @@
+// Package incoming issues and checks the secret tokens of incoming webhooks.
+// The token is part of the webhook URL; only a hash of it is stored.
+package incoming
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"userservice/database"
+	"userservice/internal/models"
+)
+
+// SenderPrefix marks messages posted through an incoming webhook.
+const SenderPrefix = "hook:"
+
+// Generate returns a new webhook id, the token to put in its URL and the hash to store.
+func Generate() (string, string, string, error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	tokenBytes := make([]byte, 24)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+	return hex.EncodeToString(idBytes), token, hash(token), nil
+}
+
+// Verify returns the webhook when the token matches, or nil when it doesn't.
+func Verify(id string, token string) (*models.IncomingWebhook, error) {
+	if id == "" || token == "" {
+		return nil, nil
+	}
+	hook, tokenHash, err := database.GetIncomingWebhook(id)
+	if err != nil || hook == nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(tokenHash), []byte(hash(token))) != 1 {
+		return nil, nil
+	}
+	return hook, nil
+}
+
+func hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -1,6 +1,9 @@
 package jwtpkg
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"time"
 	"userservice/config"
 	"userservice/internal/models"
@@ -9,13 +12,61 @@ import (
 )
 
 func GenerateJWT(user models.User) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"id":    user.ID,
 		"email": user.Email,
 		"role":  user.Role,
-		"exp":   time.Now().Add(time.Hour * 72).Unix(),
+		// in fractional seconds, so that a token issued in the same second as a
+		// revocation of the user's sessions can be told apart from older ones
+		"iat": float64(now.UnixMilli()) / 1000,
+		"exp": now.Add(config.JWTExpiration).Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(config.JWTSecret))
 }
+
+// GenerateChallengeToken issues the short-lived token returned by the first Login
+// step of a 2FA user. It is signed with its own secret so it can never be used
+// as an access token, and its jti lets it be exchanged only once.
+func GenerateChallengeToken(userId string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"id":  userId,
+		"jti": hex.EncodeToString(jti),
+		"iat": now.Unix(),
+		"exp": now.Add(config.TwoFactorChallengeTTL).Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString([]byte(config.TwoFactorChallengeSecret))
+}
+
+// ParseChallengeToken validates a challenge token and returns the user ID it was
+// issued for and its ID.
+func ParseChallengeToken(tokenString string) (string, string, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+		}
+		return []byte(config.TwoFactorChallengeSecret), nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return "", "", fmt.Errorf("invalid challenge token")
+	}
+	userId, _ := claims["id"].(string)
+	jti, _ := claims["jti"].(string)
+	if userId == "" || jti == "" {
+		return "", "", fmt.Errorf("challenge token has no user or id")
+	}
+	return userId, jti, nil
+}
@@ -0,0 +1,148 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"userservice/config"
+	"userservice/database"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// OutboxRelay publishes the events in the outbox table and marks them sent.
+// Events are published in the order they were written and a failure stops the
+// batch, so events for the same key never overtake each other. With several
+// replicas, the relay that holds the claims publishes and the others wait, see
+// database.ClaimOutbox. An event can be published twice if marking it sent
+// fails; consumers drop the copy by its ID.
+type OutboxRelay struct {
+	// id names the relay in the claims it holds
+	id       string
+	producer *Producer
+	logger   *zap.Logger
+}
+
+// NewOutboxRelay also exports the size and age of the outbox backlog.
+func NewOutboxRelay(producer *Producer, logger *zap.Logger) *OutboxRelay {
+	host, _ := os.Hostname()
+	r := &OutboxRelay{id: fmt.Sprintf("%s-%d", host, os.Getpid()), producer: producer, logger: logger}
+	if err := r.registerMetrics(); err != nil {
+		logger.Error("Failed to register outbox metrics", zap.Error(err))
+	}
+	return r
+}
+
+// registerMetrics exports the backlog, which only grows while the relay can't
+// publish, for alerting.
+func (r *OutboxRelay) registerMetrics() error {
+	labels := prometheus.Labels{"service": "userservice"}
+	backlog := func() (int64, time.Time) {
+		pending, oldest, err := database.OutboxBacklog()
+		if err != nil {
+			r.logger.Error("Failed to read outbox backlog", zap.Error(err))
+		}
+		return pending, oldest
+	}
+	collectors := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "outbox_pending_events",
+			Help:        "Outbox events not yet published.",
+			ConstLabels: labels,
+		}, func() float64 {
+			pending, _ := backlog()
+			return float64(pending)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "outbox_oldest_pending_seconds",
+			Help:        "Age of the oldest outbox event not yet published, zero when there is none.",
+			ConstLabels: labels,
+		}, func() float64 {
+			if _, oldest := backlog(); !oldest.IsZero() {
+				return time.Since(oldest).Seconds()
+			}
+			return 0
+		}),
+	}
+	var errs []error
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Run relays pending events until ctx is canceled, then releases its claims.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	poll := time.NewTicker(config.OutboxPollInterval)
+	defer poll.Stop()
+	prune := time.NewTicker(config.OutboxPruneInterval)
+	defer prune.Stop()
+	defer func() {
+		if err := database.ReleaseOutbox(r.id); err != nil {
+			r.logger.Error("Failed to release outbox claims", zap.Error(err))
+		}
+	}()
+
+	// after a failure the relay waits backoff before trying again
+	var backoff time.Duration
+	var next time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+			if time.Now().Before(next) {
+				continue
+			}
+			if r.relayPending(ctx) {
+				backoff = 0
+				continue
+			}
+			backoff = min(max(2*backoff, config.OutboxPollInterval), config.OutboxBackoffMax)
+			next = time.Now().Add(backoff)
+		case <-prune.C:
+			n, err := database.PruneOutbox(time.Now().Add(-config.OutboxRetention))
+			if err != nil {
+				r.logger.Error("Failed to prune outbox", zap.Error(err))
+			} else if n > 0 {
+				r.logger.Info("Pruned outbox", zap.Int64("deleted", n))
+			}
+		}
+	}
+}
+
+// relayPending publishes the claimed events batch by batch and reports whether
+// it got through them without a failure.
+func (r *OutboxRelay) relayPending(ctx context.Context) bool {
+	for ctx.Err() == nil {
+		pending, err := database.ClaimOutbox(r.id, config.OutboxBatchSize, config.OutboxClaimLease)
+		if err != nil {
+			r.logger.Error("Failed to claim outbox events", zap.Error(err))
+			return false
+		}
+		for _, event := range pending {
+			if err := r.producer.PublishOutbox(event); err != nil {
+				r.logger.Error("Failed to publish outbox event", zap.Error(err),
+					zap.Int64("id", event.ID), zap.String("topic", event.Topic), zap.Int("attempts", event.Attempts+1))
+				if err := database.MarkOutboxFailed(event.ID, err.Error()); err != nil {
+					r.logger.Error("Failed to record outbox failure", zap.Error(err), zap.Int64("id", event.ID))
+				}
+				return false
+			}
+			if err := database.MarkOutboxSent(event.ID); err != nil {
+				r.logger.Error("Failed to mark outbox event sent", zap.Error(err), zap.Int64("id", event.ID))
+				return false
+			}
+		}
+		if len(pending) < config.OutboxBatchSize {
+			return true
+		}
+	}
+	return true
+}
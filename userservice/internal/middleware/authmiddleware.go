@@ -1,9 +1,17 @@
 package middleware
 
 import (
+	"chatapp/events"
+	"fmt"
+	"math"
+	"strings"
 	"time"
 	"userservice/config"
-	"userservice/internal/kafka"
+	"userservice/database"
+	"userservice/internal/apikeys"
+	"userservice/internal/audit"
+	"userservice/internal/rbac"
+	"userservice/internal/utils"
 
 	"github.com/gofiber/fiber/v2"
 	jwtware "github.com/gofiber/jwt/v2"
@@ -11,74 +19,168 @@ import (
 	"go.uber.org/zap"
 )
 
-var producer *kafka.Producer
 var logger *zap.Logger
+var blacklist *utils.Blacklist
 
-func InitMiddleware(p *kafka.Producer, l *zap.Logger) {
-	producer = p
+func InitMiddleware(b *utils.Blacklist, l *zap.Logger) {
+	blacklist = b
 	logger = l
 }
 
 func JWTProtected() fiber.Handler {
 	return jwtware.New(jwtware.Config{
-		SigningKey:   []byte(config.JWTSecret),
-		ContextKey:   "user",
-		ErrorHandler: jwtError,
-		TokenLookup:  "header:Authorization",
-		AuthScheme:   "Bearer",
+		SigningKey:     []byte(config.JWTSecret),
+		ContextKey:     "user",
+		ErrorHandler:   jwtError,
+		SuccessHandler: rejectRevoked,
+		TokenLookup:    "header:Authorization",
+		AuthScheme:     "Bearer",
 	})
 }
 
+// Authenticated accepts an API key as well as a JWT. Routes that manage the
+// account itself (password, 2FA, keys) use JWTProtected so a leaked key can't
+// take the account over.
+func Authenticated() fiber.Handler {
+	jwtHandler := JWTProtected()
+	return func(c *fiber.Ctx) error {
+		raw := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !apikeys.IsAPIKey(raw) {
+			return jwtHandler(c)
+		}
+
+		principal, err := apikeys.Verify(raw)
+		if err != nil {
+			if !apikeys.IsRejected(err) {
+				logger.Error("Failed to verify API key", zap.Error(err))
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to validate token"})
+			}
+			event := audit.NewEvent(events.AuditTokenInvalid, events.AuditDenied)
+			event.IP = c.IP()
+			event.Details = map[string]string{"reason": err.Error(), "method": c.Method(), "path": c.Path()}
+			audit.Publish(event)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+		}
+		// Handlers read the caller from the token claims, so keys get an equivalent one
+		c.Locals("user", &jwt.Token{Claims: jwt.MapClaims{"id": principal.ID}, Valid: true})
+		c.Locals("apiKey", principal)
+		return c.Next()
+	}
+}
+
 func jwtError(c *fiber.Ctx, err error) error {
 	if err != nil {
+		// A token that is present but fails validation may be forged, so it is audited
+		if raw := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); raw != "" {
+			auditInvalidToken(c, raw, err.Error())
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
 	}
 	return nil
 }
 
-func AdminOnly(c *fiber.Ctx) error {
-	user := c.Locals("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	userId := claims["id"].(string)
-	role := claims["role"].(string)
-
-	if role != "admin" {
-		logEvent := map[string]interface{}{
-			"message": "Forbidden access attempt by non-admin user",
-			"userId":  userId,
-			"role":    role,
-			"time":    time.Now().Format(time.RFC3339),
-		}
-		if logErr := producer.SendMessageToLogsTopic(userId, logEvent); logErr != nil {
-			logger.Error("Failed to send log event", zap.Error(logErr))
-		}
+// rejectRevoked refuses tokens that were logged out or issued before the user's
+// sessions were revoked, e.g. by a password reset.
+func rejectRevoked(c *fiber.Ctx) error {
+	token := c.Locals("user").(*jwt.Token)
+	revoked, err := isRevoked(token)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to validate token"})
+	}
+	if revoked {
+		auditInvalidToken(c, token.Raw, "revoked")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Unauthorized"})
+	}
+	return c.Next()
+}
 
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Forbidden"})
+func isRevoked(token *jwt.Token) (bool, error) {
+	claims := token.Claims.(jwt.MapClaims)
+	userId, _ := claims["id"].(string)
+	issuedAt, _ := claims["iat"].(float64)
+
+	loggedOut, err := blacklist.Get(token.Raw)
+	if err != nil || loggedOut {
+		return loggedOut, err
 	}
+	return blacklist.IsRevoked(userId, time.UnixMilli(int64(math.Round(issuedAt*1000))))
+}
 
-	return c.Next()
+// VerifyToken checks a JWT as JWTProtected does, for the other services, and
+// returns its user id. A refused token gives the reason instead; err is only
+// set when the token couldn't be checked.
+func VerifyToken(raw string) (userId string, reason string, err error) {
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(config.JWTSecret), nil
+	})
+	if err != nil {
+		return "", err.Error(), nil
+	}
+	userId, _ = token.Claims.(jwt.MapClaims)["id"].(string)
+	if userId == "" {
+		return "", "token has no user id", nil
+	}
+	revoked, err := isRevoked(token)
+	if err != nil {
+		return "", "", err
+	}
+	if revoked {
+		return "", "revoked", nil
+	}
+	return userId, "", nil
 }
 
-func UserOnly(c *fiber.Ctx) error {
-	user := c.Locals("user").(*jwt.Token)
-	claims := user.Claims.(jwt.MapClaims)
-	userId := claims["id"].(string)
-	role := claims["role"].(string)
-
-	if role != "user" {
-		logEvent := map[string]interface{}{
-			"message": "Forbidden access attempt by admin user",
-			"userId":  userId,
-			"role":    role,
-			"time":    time.Now().Format(time.RFC3339),
+// RequirePermission allows the request only if the caller's current role grants
+// the permission. Permissions are resolved per request so role changes apply
+// immediately, and are left in c.Locals("permissions") for handlers.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := c.Locals("user").(*jwt.Token).Claims.(jwt.MapClaims)
+		userId := claims["id"].(string)
+
+		var permissions []string
+		var err error
+		if principal, ok := c.Locals("apiKey").(*apikeys.Principal); ok {
+			permissions = principal.Scopes
+		} else {
+			permissions, err = database.Roles.PermissionsForUser(userId)
 		}
-		if logErr := producer.SendMessageToLogsTopic(userId, logEvent); logErr != nil {
-			logger.Error("Failed to send log event", zap.Error(logErr))
+		if err != nil {
+			logger.Error("Failed to resolve permissions", zap.String("userId", userId), zap.Error(err))
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to resolve permissions"})
 		}
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Access denied"})
+
+		if !rbac.Has(permissions, permission) {
+			event := audit.NewEvent(events.AuditAccessForbidden, events.AuditDenied)
+			event.ActorID = userId
+			event.IP = c.IP()
+			event.Details = map[string]string{"permission": permission, "method": c.Method(), "path": c.Path()}
+			audit.Publish(event)
+
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Forbidden"})
+		}
+
+		c.Locals("permissions", permissions)
+		return c.Next()
 	}
+}
 
-	return c.Next()
+// auditInvalidToken records a rejected token. The claimed user id is read
+// without verification and only serves to tell whose identity was presented.
+func auditInvalidToken(c *fiber.Ctx, raw string, reason string) {
+	event := audit.NewEvent(events.AuditTokenInvalid, events.AuditDenied)
+	event.IP = c.IP()
+	event.Details = map[string]string{"reason": reason, "method": c.Method(), "path": c.Path()}
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(raw, claims); err == nil {
+		if claimedId, ok := claims["id"].(string); ok {
+			event.TargetID = claimedId
+		}
+	}
+	audit.Publish(event)
 }
 
 // func BlacklistCheckMiddleware() fiber.Handler {
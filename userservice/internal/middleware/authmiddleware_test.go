@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"userservice/internal/jwtpkg"
+	"userservice/internal/models"
+	"userservice/internal/utils"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"go.uber.org/zap"
+)
+
+func newTestBlacklist(t *testing.T) *utils.Blacklist {
+	t.Helper()
+	redis := miniredis.RunT(t)
+	b := utils.NewBlacklist(redis.Addr(), zap.NewNop())
+	InitMiddleware(b, zap.NewNop())
+	return b
+}
+
+func signToken(t *testing.T, userId string) string {
+	t.Helper()
+	raw, err := jwtpkg.GenerateJWT(models.User{ID: userId, Email: userId + "@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestVerifyToken(t *testing.T) {
+	newTestBlacklist(t)
+	raw := signToken(t, "u1")
+	userId, reason, err := VerifyToken(raw)
+	if err != nil || reason != "" || userId != "u1" {
+		t.Fatalf("VerifyToken = %q, %q, %v", userId, reason, err)
+	}
+
+	forged, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"id": "u1"}).SignedString([]byte("other-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, reason, err := VerifyToken(forged); err != nil || reason == "" {
+		t.Fatalf("a token signed with another secret was accepted: %q, %v", reason, err)
+	}
+}
+
+func TestVerifyTokenRefusesLoggedOut(t *testing.T) {
+	b := newTestBlacklist(t)
+	raw := signToken(t, "u1")
+	if err := b.Set(raw, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, reason, err := VerifyToken(raw); err != nil || reason != "revoked" {
+		t.Fatalf("VerifyToken of a logged out token = %q, %v", reason, err)
+	}
+}
+
+func TestVerifyTokenRefusesRevokedUser(t *testing.T) {
+	b := newTestBlacklist(t)
+	old := signToken(t, "u1")
+	time.Sleep(2 * time.Millisecond)
+	if err := b.RevokeUser("u1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if _, reason, err := VerifyToken(old); err != nil || reason != "revoked" {
+		t.Fatalf("VerifyToken of a token issued before the revocation = %q, %v", reason, err)
+	}
+	// a token issued after the revocation, as by a new login, is accepted
+	fresh := signToken(t, "u1")
+	if userId, reason, err := VerifyToken(fresh); err != nil || userId != "u1" {
+		t.Fatalf("VerifyToken of a new token = %q, %q, %v", userId, reason, err)
+	}
+}
@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// SlashCommand is a chat command handled by an external HTTP endpoint.
+// notificationservice POSTs each invocation to URL, signed with Secret.
+type SlashCommand struct {
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Secret      string    `json:"-"`
+	Description string    `json:"description"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type RegisterCommandRequest struct {
+	Name        string `json:"name"`
+	URL         string `json:"url"`
+	Description string `json:"description"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// ScheduleRequest schedules a message to the recipients, or with kind "reminder"
+// a reminder to the caller. The time is either send_at (RFC 3339) or in, a delay
+// such as "90m" or "2h".
+type ScheduleRequest struct {
+	Kind       string   `json:"kind"`
+	Recipients []string `json:"recipients"`
+	Message    string   `json:"message"`
+	SendAt     string   `json:"send_at"`
+	In         string   `json:"in"`
+}
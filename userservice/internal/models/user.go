@@ -1,12 +1,83 @@
 package models
 
+import "time"
+
 type User struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"password,omitempty"`
-	Role     string `json:"role"`
+	ID    string `json:"id"`
+	Email string `json:"email"`
+	// EmailVerified is set once the user has received mail at Email
+	EmailVerified bool      `json:"email_verified"`
+	Password      string    `json:"password,omitempty"`
+	Role          string    `json:"role"`
+	Suspended     bool      `json:"suspended"`
+	DisplayName   string    `json:"display_name,omitempty"`
+	AvatarURL     string    `json:"avatar_url,omitempty"`
+	StatusText    string    `json:"status_text,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 type LogoutRequest struct {
 	UserID string `json:"id"`
 }
+
+type ForgotPasswordRequest struct {
+	UserID string `json:"id"`
+	Email  string `json:"email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name"`
+	AvatarURL   *string `json:"avatar_url"`
+	StatusText  *string `json:"status_text"`
+}
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type UserFilter struct {
+	Role      string
+	Query     string
+	Suspended *bool
+	Limit     int
+	Offset    int
+}
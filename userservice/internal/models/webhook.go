@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// CreateWebhookRequest subscribes URL to the given event types, e.g.
+// message.created. A signing secret is generated when none is given.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url"`
+	Events []string `json:"events"`
+	Secret string   `json:"secret"`
+}
+
+type UpdateWebhookRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// IncomingWebhook lets scripts and CI systems post messages as hook:<name>
+// to a fixed set of recipients, without an account or API key.
+type IncomingWebhook struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Recipients []string  `json:"recipients"`
+	CreatedBy  string    `json:"created_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type CreateIncomingWebhookRequest struct {
+	Name       string   `json:"name"`
+	Recipients []string `json:"recipients"`
+}
@@ -0,0 +1,234 @@
+// Package oidc implements login through an external OpenID Connect provider
+// using the authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+// ErrInvalidState is returned for a callback whose state is unknown, expired or already used.
+var ErrInvalidState = errors.New("invalid or expired login state")
+
+// StateCookie ties a login to the browser that started it, so a callback URL
+// with someone else's state can't log the victim into the attacker's account.
+const StateCookie = "oidc_state"
+
+type Config struct {
+	Name         string
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	StateTTL     time.Duration
+	// StateSecret signs the StateCookie
+	StateSecret string
+}
+
+// Identity is the verified result of a provider login.
+type Identity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Provider talks to one OpenID Connect issuer. Discovery happens on first use
+// and is retried until it succeeds, so the issuer doesn't have to be up when
+// the service starts.
+type Provider struct {
+	config Config
+	client *redis.Client
+	logger *zap.Logger
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// loginState is kept in Redis between the redirect and the callback.
+type loginState struct {
+	Verifier string `json:"verifier"`
+	Nonce    string `json:"nonce"`
+	// LinkUserID is the signed in user that started the login to link the identity
+	LinkUserID string `json:"linkUserId,omitempty"`
+}
+
+func NewProvider(config Config, redisAddr string, logger *zap.Logger) *Provider {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	return &Provider{
+		config: config,
+		client: client,
+		logger: logger,
+	}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthURL starts a login and returns the provider URL to send the browser to,
+// and the state to put in its StateCookie with SignState. A login started with
+// linkUserId links the identity to that user instead of signing in.
+func (p *Provider) AuthURL(ctx context.Context, linkUserId string) (string, string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	data, err := json.Marshal(loginState{Verifier: verifier, Nonce: nonce, LinkUserID: linkUserId})
+	if err != nil {
+		return "", "", err
+	}
+	if err := p.client.Set(ctx, stateKey(state), data, p.config.StateTTL).Err(); err != nil {
+		p.logger.Error("Failed to store OIDC login state", zap.Error(err))
+		return "", "", err
+	}
+
+	return oauth.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), gooidc.Nonce(nonce)), state, nil
+}
+
+// SignState returns the StateCookie value for a state from AuthURL.
+func (p *Provider) SignState(state string) string {
+	return state + "." + p.stateMAC(state)
+}
+
+// CheckState reports whether cookie is the StateCookie value for state.
+func (p *Provider) CheckState(cookie string, state string) bool {
+	signed, mac, ok := strings.Cut(cookie, ".")
+	return ok && hmac.Equal([]byte(signed), []byte(state)) && hmac.Equal([]byte(mac), []byte(p.stateMAC(state)))
+}
+
+func (p *Provider) stateMAC(state string) string {
+	mac := hmac.New(sha256.New, []byte(p.config.StateSecret))
+	mac.Write([]byte(state))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Exchange completes a login: it redeems the code with the PKCE verifier of the
+// state and verifies the returned ID token, including its nonce. It also
+// returns the user the login was started to link the identity to, if any.
+func (p *Provider) Exchange(ctx context.Context, code string, state string) (*Identity, string, error) {
+	oauth, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// GetDel makes every state usable only once
+	data, err := p.client.GetDel(ctx, stateKey(state)).Bytes()
+	if err == redis.Nil {
+		return nil, "", ErrInvalidState
+	} else if err != nil {
+		p.logger.Error("Failed to load OIDC login state", zap.Error(err))
+		return nil, "", err
+	}
+	var ls loginState
+	if err := json.Unmarshal(data, &ls); err != nil {
+		return nil, "", err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(ls.Verifier))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", errors.New("token response has no id_token")
+	}
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to verify id_token: %w", err)
+	}
+	if idToken.Nonce != ls.Nonce {
+		return nil, "", errors.New("id_token nonce mismatch")
+	}
+
+	var claims struct {
+		Email         string      `json:"email"`
+		EmailVerified interface{} `json:"email_verified"`
+		Name          string      `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, "", fmt.Errorf("failed to parse id_token claims: %w", err)
+	}
+	return &Identity{
+		Issuer:        idToken.Issuer,
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: isTrue(claims.EmailVerified),
+		Name:          claims.Name,
+	}, ls.LinkUserID, nil
+}
+
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := gooidc.NewProvider(ctx, p.config.IssuerURL)
+	if err != nil {
+		p.logger.Error("OIDC discovery failed", zap.String("issuer", p.config.IssuerURL), zap.Error(err))
+		return nil, nil, err
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{gooidc.ScopeOpenID, "email", "profile"},
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.config.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+// isTrue accepts email_verified as a boolean or, as some providers send it, a string.
+func isTrue(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func stateKey(state string) string {
+	return "oidc:state:" + state
+}
@@ -0,0 +1,44 @@
+// Package rbac defines the permissions that roles grant. Roles themselves and
+// their permission sets live in the database and can be changed by admins.
+package rbac
+
+const (
+	ProfileRead    = "profile:read"
+	UsersRead      = "users:read"
+	UsersManage    = "users:manage"
+	RolesManage    = "roles:manage"
+	LogsRead       = "logs:read"
+	MessagesSend   = "messages:send"
+	APIKeysManage  = "apikeys:manage"
+	WebhooksManage = "webhooks:manage"
+	CommandsManage = "commands:manage"
+)
+
+// DefaultRole is given to every self-registered user; it grants the least privileges.
+const DefaultRole = "user"
+
+// All lists every permission the services check for.
+var All = []string{ProfileRead, UsersRead, UsersManage, RolesManage, LogsRead, MessagesSend, APIKeysManage, WebhooksManage, CommandsManage}
+
+// ServiceKeyScopes are the only scopes a service key can have. It acts for no
+// user, so it gets nothing that administers users, roles, keys or integrations.
+var ServiceKeyScopes = []string{ProfileRead, MessagesSend}
+
+// IsKeyScope reports whether an API key may carry the permission. Keys can't
+// manage keys, so a leaked key can't be used to mint more.
+func IsKeyScope(permission string) bool {
+	return IsValid(permission) && permission != APIKeysManage
+}
+
+func IsValid(permission string) bool {
+	return Has(All, permission)
+}
+
+func Has(permissions []string, permission string) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package routes
 import (
 	"userservice/internal/controllers"
 	"userservice/internal/middleware"
+	"userservice/internal/rbac"
 
 	"github.com/ansrivas/fiberprometheus/v2"
 	swagger "github.com/arsmn/fiber-swagger/v2"
@@ -21,16 +22,71 @@ func Setup(app *fiber.App) {
 
 	api.Post("/register", controllers.Register)
 	api.Post("/login", controllers.Login)
+	api.Post("/login/2fa", controllers.LoginTwoFactor)
+	api.Get("/oidc/login", controllers.OIDCLogin)
+	api.Get("/oidc/callback", controllers.OIDCCallback)
+	api.Post("/oidc/link", middleware.JWTProtected(), controllers.OIDCLink)
 	api.Post("/logout", middleware.JWTProtected(), controllers.Logout)
+	api.Post("/forgot-password", controllers.ForgotPassword)
+	api.Post("/reset-password", controllers.ResetPassword)
+	api.Post("/verify-email", controllers.VerifyEmail)
 
 	protected := api.Group("/profile")
 	protected.Use(middleware.JWTProtected())
 	protected.Get("/", controllers.GetProfile)
+	protected.Patch("/", controllers.UpdateProfile)
+	protected.Delete("/", controllers.DeleteAccount)
+	protected.Post("/email", controllers.ChangeEmail)
+	protected.Post("/password", controllers.ChangePassword)
+
+	twoFactor := api.Group("/2fa")
+	twoFactor.Use(middleware.JWTProtected())
+	twoFactor.Post("/enroll", controllers.EnrollTwoFactor)
+	twoFactor.Post("/verify", controllers.VerifyTwoFactor)
+	twoFactor.Post("/disable", controllers.DisableTwoFactor)
+
+	apiKeys := api.Group("/api-keys")
+	apiKeys.Use(middleware.JWTProtected())
+	apiKeys.Get("/", controllers.ListMyAPIKeys)
+	apiKeys.Post("/", controllers.CreateMyAPIKey)
+	apiKeys.Delete("/:keyID", controllers.RevokeMyAPIKey)
+
+	schedules := api.Group("/schedules")
+	schedules.Use(middleware.JWTProtected(), middleware.RequirePermission(rbac.MessagesSend))
+	schedules.Get("/", controllers.ListSchedules)
+	schedules.Post("/", controllers.CreateSchedule)
+	schedules.Delete("/:scheduleID", controllers.CancelSchedule)
 
 	admin := api.Group("/admin")
-	admin.Use(middleware.JWTProtected(), middleware.AdminOnly)
-	admin.Get("/", controllers.GetUsersWithUserRole)
+	admin.Use(middleware.Authenticated())
+	admin.Get("/", middleware.RequirePermission(rbac.UsersRead), controllers.GetUsersWithUserRole)
+	admin.Get("/users", middleware.RequirePermission(rbac.UsersRead), controllers.ListUsers)
+	admin.Post("/users/:userID/unlock", middleware.RequirePermission(rbac.UsersManage), controllers.UnlockUser)
+	admin.Post("/users/:userID/suspend", middleware.RequirePermission(rbac.UsersManage), controllers.SuspendUser)
+	admin.Post("/users/:userID/unsuspend", middleware.RequirePermission(rbac.UsersManage), controllers.UnsuspendUser)
+	admin.Post("/users/:userID/logout", middleware.RequirePermission(rbac.UsersManage), controllers.ForceLogout)
+	admin.Delete("/users/:userID", middleware.RequirePermission(rbac.UsersManage), controllers.DeleteUser)
+	admin.Put("/users/:userID/role", middleware.RequirePermission(rbac.RolesManage), controllers.AssignRole)
+	admin.Get("/roles", middleware.RequirePermission(rbac.RolesManage), controllers.ListRoles)
+	admin.Put("/roles/:role", middleware.RequirePermission(rbac.RolesManage), controllers.SaveRole)
+	admin.Get("/api-keys", middleware.RequirePermission(rbac.APIKeysManage), controllers.ListAPIKeys)
+	admin.Post("/api-keys", middleware.RequirePermission(rbac.APIKeysManage), controllers.CreateServiceAPIKey)
+	admin.Delete("/api-keys/:keyID", middleware.RequirePermission(rbac.APIKeysManage), controllers.RevokeAPIKey)
+	admin.Get("/audit", middleware.RequirePermission(rbac.LogsRead), controllers.QueryAuditLog)
+	admin.Get("/audit/export", middleware.RequirePermission(rbac.LogsRead), controllers.ExportAuditLog)
+	admin.Get("/audit/verify", middleware.RequirePermission(rbac.LogsRead), controllers.VerifyAuditLog)
+	admin.Get("/webhooks", middleware.RequirePermission(rbac.WebhooksManage), controllers.ListWebhooks)
+	admin.Post("/webhooks", middleware.RequirePermission(rbac.WebhooksManage), controllers.CreateWebhook)
+	admin.Patch("/webhooks/:webhookID", middleware.RequirePermission(rbac.WebhooksManage), controllers.UpdateWebhook)
+	admin.Delete("/webhooks/:webhookID", middleware.RequirePermission(rbac.WebhooksManage), controllers.DeleteWebhook)
+	admin.Get("/webhooks/:webhookID/deliveries", middleware.RequirePermission(rbac.WebhooksManage), controllers.ListWebhookDeliveries)
+	admin.Get("/incoming-webhooks", middleware.RequirePermission(rbac.WebhooksManage), controllers.ListIncomingWebhooks)
+	admin.Post("/incoming-webhooks", middleware.RequirePermission(rbac.WebhooksManage), controllers.CreateIncomingWebhook)
+	admin.Delete("/incoming-webhooks/:hookID", middleware.RequirePermission(rbac.WebhooksManage), controllers.DeleteIncomingWebhook)
+	admin.Get("/commands", middleware.RequirePermission(rbac.CommandsManage), controllers.ListCommands)
+	admin.Post("/commands", middleware.RequirePermission(rbac.CommandsManage), controllers.RegisterCommand)
+	admin.Delete("/commands/:name", middleware.RequirePermission(rbac.CommandsManage), controllers.DeleteCommand)
 	user := api.Group("/user-data")
-	user.Use(middleware.JWTProtected(), middleware.UserOnly)
+	user.Use(middleware.Authenticated(), middleware.RequirePermission(rbac.ProfileRead))
 	user.Get("/:userID", controllers.GetUserByID)
 }
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+// LockoutPolicy describes when repeated login failures lock a subject out.
+// Once Threshold failures happen within Window of the first, every further
+// failure locks the subject for BaseLockout, doubled per extra failure and
+// capped at MaxLockout.
+type LockoutPolicy struct {
+	Threshold   int64
+	Window      time.Duration
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+}
+
+// LoginGuard tracks failed logins per account and per client IP in Redis.
+type LoginGuard struct {
+	client  *redis.Client
+	logger  *zap.Logger
+	account LockoutPolicy
+	ip      LockoutPolicy
+}
+
+// Lockout is the result of recording a failure; a zero duration means not locked.
+type Lockout struct {
+	Account time.Duration
+	IP      time.Duration
+}
+
+func NewLoginGuard(redisAddr string, account LockoutPolicy, ip LockoutPolicy, logger *zap.Logger) *LoginGuard {
+	client := redis.NewClient(&redis.Options{
+		Addr: redisAddr,
+	})
+	return &LoginGuard{
+		client:  client,
+		logger:  logger,
+		account: account,
+		ip:      ip,
+	}
+}
+
+// LockedFor returns how much longer logins for the account or from the IP are
+// refused, whichever is longer.
+func (g *LoginGuard) LockedFor(userID string, ip string) (time.Duration, error) {
+	ctx := context.Background()
+	var longest time.Duration
+	for _, key := range []string{lockKey("acct", userID), lockKey("ip", ip)} {
+		ttl, err := g.client.PTTL(ctx, key).Result()
+		if err != nil {
+			g.logger.Error("Failed to check login lockout", zap.Error(err))
+			return 0, err
+		}
+		if ttl > longest {
+			longest = ttl
+		}
+	}
+	return longest, nil
+}
+
+// RecordFailure counts a failed login against both the account and the IP and
+// applies any lockout that results.
+func (g *LoginGuard) RecordFailure(userID string, ip string) (Lockout, error) {
+	var lockout Lockout
+	var err error
+	if lockout.Account, err = g.recordFailure("acct", userID, g.account); err != nil {
+		return lockout, err
+	}
+	if lockout.IP, err = g.recordFailure("ip", ip, g.ip); err != nil {
+		return lockout, err
+	}
+	return lockout, nil
+}
+
+// countFailure increments a failure counter and starts its window with the
+// first failure only, so that failing now and then doesn't keep the count
+// alive. The script runs atomically, so a counter can't be left without one.
+var countFailure = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+func (g *LoginGuard) recordFailure(scope string, id string, policy LockoutPolicy) (time.Duration, error) {
+	ctx := context.Background()
+	key := failKey(scope, id)
+	count, err := countFailure.Run(ctx, g.client, []string{key}, policy.Window.Milliseconds()).Int64()
+	if err != nil {
+		g.logger.Error("Failed to record login failure", zap.String("scope", scope), zap.Error(err))
+		return 0, err
+	}
+	if count < policy.Threshold {
+		return 0, nil
+	}
+
+	lockout := policy.BaseLockout
+	for i := policy.Threshold; i < count && lockout < policy.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > policy.MaxLockout {
+		lockout = policy.MaxLockout
+	}
+	if err := g.client.Set(ctx, lockKey(scope, id), count, lockout).Err(); err != nil {
+		return 0, err
+	}
+	return lockout, nil
+}
+
+// Reset forgets the failures and any lockout of an account, e.g. after a
+// successful login or when an admin unlocks it. IP counters are left alone.
+func (g *LoginGuard) Reset(userID string) error {
+	ctx := context.Background()
+	if err := g.client.Del(ctx, failKey("acct", userID), lockKey("acct", userID)).Err(); err != nil {
+		g.logger.Error("Failed to reset login failures", zap.String("userId", userID), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func failKey(scope string, id string) string {
+	return "login:fail:" + scope + ":" + id
+}
+
+func lockKey(scope string, id string) string {
+	return "login:lock:" + scope + ":" + id
+}
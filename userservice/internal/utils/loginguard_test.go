@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"go.uber.org/zap"
+)
+
+var testPolicy = LockoutPolicy{Threshold: 3, Window: time.Hour, BaseLockout: time.Minute, MaxLockout: 5 * time.Minute}
+
+func newTestGuard(t *testing.T) (*LoginGuard, *miniredis.Miniredis) {
+	t.Helper()
+	redis := miniredis.RunT(t)
+	return NewLoginGuard(redis.Addr(), testPolicy, LockoutPolicy{Threshold: 100, Window: time.Hour, BaseLockout: time.Minute,
+		MaxLockout: time.Minute}, zap.NewNop()), redis
+}
+
+func TestLoginGuardLocksAtThreshold(t *testing.T) {
+	g, _ := newTestGuard(t)
+	for i := 1; i < 3; i++ {
+		lockout, err := g.RecordFailure("alice", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lockout.Account != 0 {
+			t.Fatalf("locked for %s after %d failures", lockout.Account, i)
+		}
+	}
+	if locked, err := g.LockedFor("alice", "10.0.0.1"); err != nil || locked != 0 {
+		t.Fatalf("LockedFor below the threshold = %s, %v", locked, err)
+	}
+
+	lockout, err := g.RecordFailure("alice", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout.Account != time.Minute || lockout.IP != 0 {
+		t.Fatalf("lockout at the threshold = %+v", lockout)
+	}
+	if locked, err := g.LockedFor("alice", "10.0.0.2"); err != nil || locked != time.Minute {
+		t.Fatalf("LockedFor from another IP = %s, %v", locked, err)
+	}
+}
+
+func TestLoginGuardDoublesUpToMax(t *testing.T) {
+	g, _ := newTestGuard(t)
+	var got []time.Duration
+	for i := 0; i < 7; i++ {
+		lockout, err := g.RecordFailure("alice", "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, lockout.Account)
+	}
+	want := []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("lockouts = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLoginGuardResetOnSuccess(t *testing.T) {
+	g, _ := newTestGuard(t)
+	for i := 0; i < 3; i++ {
+		if _, err := g.RecordFailure("alice", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := g.Reset("alice"); err != nil {
+		t.Fatal(err)
+	}
+	if locked, err := g.LockedFor("alice", "10.0.0.1"); err != nil || locked != 0 {
+		t.Fatalf("LockedFor after a reset = %s, %v", locked, err)
+	}
+	// the count starts over
+	if lockout, err := g.RecordFailure("alice", "10.0.0.1"); err != nil || lockout.Account != 0 {
+		t.Fatalf("first failure after a reset = %+v, %v", lockout, err)
+	}
+}
+
+func TestLoginGuardWindowStartsAtFirstFailure(t *testing.T) {
+	g, redis := newTestGuard(t)
+	// two failures spread over most of the window don't extend it
+	if _, err := g.RecordFailure("alice", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	redis.FastForward(50 * time.Minute)
+	if _, err := g.RecordFailure("alice", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	redis.FastForward(11 * time.Minute)
+
+	// the window of the first failure is over, so this is the first of a new one
+	lockout, err := g.RecordFailure("alice", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lockout.Account != 0 {
+		t.Fatalf("locked for %s by failures from an expired window", lockout.Account)
+	}
+	if ttl := redis.TTL(failKey("acct", "alice")); ttl != time.Hour {
+		t.Fatalf("new window lasts %s, want an hour", ttl)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
     "context"
+    "strconv"
     "time"
 
     "github.com/go-redis/redis/v8"
@@ -50,4 +51,48 @@ func (b *Blacklist) Get(token string) (bool, error) {
         return true, nil
     }
     return false, nil
-}
\ No newline at end of file
+}
+
+// UseOnce records that the one-time token with the given ID was used and reports
+// whether this is its first use. The record only needs to outlive the token.
+func (b *Blacklist) UseOnce(id string, expiration time.Duration) (bool, error) {
+    ctx := context.Background()
+    fresh, err := b.client.SetNX(ctx, "used:"+id, 1, expiration).Result()
+    if err != nil {
+        b.logger.Error("Failed to record one-time token use", zap.Error(err))
+        return false, err
+    }
+    return fresh, nil
+}
+
+// RevokeUser invalidates every token issued to the user up to now. The marker only
+// needs to outlive the longest-lived token, hence the expiration.
+func (b *Blacklist) RevokeUser(userID string, expiration time.Duration) error {
+    ctx := context.Background()
+    err := b.client.Set(ctx, "revoked:"+userID, time.Now().UnixMilli(), expiration).Err()
+    if err != nil {
+        b.logger.Error("Failed to revoke user tokens", zap.String("userId", userID), zap.Error(err))
+        return err
+    }
+    b.logger.Info("Revoked all tokens for user", zap.String("userId", userID))
+    return nil
+}
+
+// IsRevoked reports whether a token issued to the user at issuedAt predates the
+// last RevokeUser call. Both are compared in milliseconds, so a token issued
+// right after a revocation is not caught by it.
+func (b *Blacklist) IsRevoked(userID string, issuedAt time.Time) (bool, error) {
+    ctx := context.Background()
+    val, err := b.client.Get(ctx, "revoked:"+userID).Result()
+    if err == redis.Nil {
+        return false, nil
+    } else if err != nil {
+        b.logger.Error("Failed to check user revocation", zap.Error(err))
+        return false, err
+    }
+    revokedAt, err := strconv.ParseInt(val, 10, 64)
+    if err != nil {
+        return false, err
+    }
+    return issuedAt.UnixMilli() <= revokedAt, nil
+}
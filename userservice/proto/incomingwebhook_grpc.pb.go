@@ -0,0 +1,128 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: proto/incomingwebhook.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	IncomingWebhookService_VerifyIncomingWebhook_FullMethodName = "/proto.IncomingWebhookService/VerifyIncomingWebhook"
+)
+
+// IncomingWebhookServiceClient is the client API for IncomingWebhookService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// IncomingWebhookService checks the tokens of incoming webhook URLs, which let
+// scripts and CI systems post messages to a fixed set of recipients.
+type IncomingWebhookServiceClient interface {
+	VerifyIncomingWebhook(ctx context.Context, in *VerifyIncomingWebhookRequest, opts ...grpc.CallOption) (*VerifyIncomingWebhookResponse, error)
+}
+
+type incomingWebhookServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewIncomingWebhookServiceClient(cc grpc.ClientConnInterface) IncomingWebhookServiceClient {
+	return &incomingWebhookServiceClient{cc}
+}
+
+func (c *incomingWebhookServiceClient) VerifyIncomingWebhook(ctx context.Context, in *VerifyIncomingWebhookRequest, opts ...grpc.CallOption) (*VerifyIncomingWebhookResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(VerifyIncomingWebhookResponse)
+	err := c.cc.Invoke(ctx, IncomingWebhookService_VerifyIncomingWebhook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// IncomingWebhookServiceServer is the server API for IncomingWebhookService service.
+// All implementations must embed UnimplementedIncomingWebhookServiceServer
+// for forward compatibility.
+//
+// IncomingWebhookService checks the tokens of incoming webhook URLs, which let
+// scripts and CI systems post messages to a fixed set of recipients.
+type IncomingWebhookServiceServer interface {
+	VerifyIncomingWebhook(context.Context, *VerifyIncomingWebhookRequest) (*VerifyIncomingWebhookResponse, error)
+	mustEmbedUnimplementedIncomingWebhookServiceServer()
+}
+
+// UnimplementedIncomingWebhookServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedIncomingWebhookServiceServer struct{}
+
+func (UnimplementedIncomingWebhookServiceServer) VerifyIncomingWebhook(context.Context, *VerifyIncomingWebhookRequest) (*VerifyIncomingWebhookResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method VerifyIncomingWebhook not implemented")
+}
+func (UnimplementedIncomingWebhookServiceServer) mustEmbedUnimplementedIncomingWebhookServiceServer() {
+}
+func (UnimplementedIncomingWebhookServiceServer) testEmbeddedByValue() {}
+
+// UnsafeIncomingWebhookServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to IncomingWebhookServiceServer will
+// result in compilation errors.
+type UnsafeIncomingWebhookServiceServer interface {
+	mustEmbedUnimplementedIncomingWebhookServiceServer()
+}
+
+func RegisterIncomingWebhookServiceServer(s grpc.ServiceRegistrar, srv IncomingWebhookServiceServer) {
+	// If the following call pancis, it indicates UnimplementedIncomingWebhookServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&IncomingWebhookService_ServiceDesc, srv)
+}
+
+func _IncomingWebhookService_VerifyIncomingWebhook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(VerifyIncomingWebhookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(IncomingWebhookServiceServer).VerifyIncomingWebhook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: IncomingWebhookService_VerifyIncomingWebhook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(IncomingWebhookServiceServer).VerifyIncomingWebhook(ctx, req.(*VerifyIncomingWebhookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// IncomingWebhookService_ServiceDesc is the grpc.ServiceDesc for IncomingWebhookService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var IncomingWebhookService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "proto.IncomingWebhookService",
+	HandlerType: (*IncomingWebhookServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "VerifyIncomingWebhook",
+			Handler:    _IncomingWebhookService_VerifyIncomingWebhook_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/incomingwebhook.proto",
+}
@@ -0,0 +1,155 @@
+// Command deadletters lists and replays the messages the worker gave up on.
+//
+//	deadletters [-addr host:port] list [-topic email] [-limit 50] [-all]
+//	deadletters [-addr host:port] show <id>
+//	deadletters [-addr host:port] replay <id>...
+//
+// Inside the workerservice container run it as ./deadletters.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	pb "workerservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:50052", "workerservice gRPC address")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fatal(err)
+	}
+	defer conn.Close()
+	client := pb.NewDeadLetterServiceClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	args := flag.Args()
+	switch args[0] {
+	case "list":
+		list(ctx, client, args[1:])
+	case "show":
+		if len(args) != 2 {
+			usage()
+			os.Exit(2)
+		}
+		d, err := client.GetDeadLetter(ctx, &pb.GetDeadLetterRequest{Id: parseID(args[1])})
+		if err != nil {
+			fatal(err)
+		}
+		show(d)
+	case "replay":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		failed := false
+		for _, arg := range args[1:] {
+			d, err := client.ReplayDeadLetter(ctx, &pb.ReplayDeadLetterRequest{Id: parseID(arg)})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+				failed = true
+				continue
+			}
+			fmt.Printf("%d: replayed to the retry topic of %s for %s\n", d.GetId(), d.GetTopic(), d.GetHandler())
+		}
+		if failed {
+			os.Exit(1)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func list(ctx context.Context, client pb.DeadLetterServiceClient, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	topic := fs.String("topic", "", "only dead letters from this topic")
+	limit := fs.Int("limit", 50, "maximum number of dead letters")
+	all := fs.Bool("all", false, "include replayed dead letters")
+	fs.Parse(args)
+
+	resp, err := client.ListDeadLetters(ctx, &pb.ListDeadLettersRequest{
+		Topic:           *topic,
+		IncludeReplayed: *all,
+		Limit:           int32(*limit),
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTOPIC\tHANDLER\tATTEMPTS\tFAILED AT\tREPLAYED AT\tERROR")
+	for _, d := range resp.GetDeadLetters() {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\n", d.GetId(), d.GetTopic(), d.GetHandler(), d.GetAttempts(),
+			d.GetFailedAt(), dash(d.GetReplayedAt()), truncate(d.GetError(), 80))
+	}
+	w.Flush()
+}
+
+func show(d *pb.DeadLetter) {
+	fmt.Printf("ID:          %d\n", d.GetId())
+	fmt.Printf("Topic:       %s (partition %d, offset %d)\n", d.GetTopic(), d.GetPartition(), d.GetOffset())
+	fmt.Printf("Handler:     %s\n", d.GetHandler())
+	fmt.Printf("Event ID:    %s\n", dash(d.GetEventId()))
+	if d.GetEventType() != "" {
+		fmt.Printf("Schema:      %s version %d\n", d.GetEventType(), d.GetVersion())
+	}
+	fmt.Printf("Key:         %s\n", dash(d.GetKey()))
+	fmt.Printf("Attempts:    %d\n", d.GetAttempts())
+	fmt.Printf("Failed at:   %s\n", d.GetFailedAt())
+	fmt.Printf("Replayed at: %s\n", dash(d.GetReplayedAt()))
+	fmt.Printf("Error:       %s\n", d.GetError())
+	fmt.Printf("Payload:\n%s\n", d.GetPayload())
+}
+
+func parseID(s string) int64 {
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		fatal(fmt.Errorf("invalid id %q", s))
+	}
+	return id
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-3] + "..."
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  deadletters [-addr host:port] list [-topic topic] [-limit n] [-all]
+  deadletters [-addr host:port] show <id>
+  deadletters [-addr host:port] replay <id>...`)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
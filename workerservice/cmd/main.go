@@ -2,18 +2,30 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 
 	"workerservice/config"
+	"workerservice/grpc"
+	"workerservice/grpcclient"
+	"workerservice/internal/audit"
+	"workerservice/internal/deadletter"
+	"workerservice/internal/dedup"
 	email "workerservice/internal/email"
+	"workerservice/internal/health"
 	"workerservice/internal/kafka"
 	logs "workerservice/internal/logs"
 	"workerservice/internal/message"
+	"workerservice/internal/schedule"
+	"workerservice/internal/webhook"
 )
 
 func main() {
@@ -23,8 +35,12 @@ func main() {
 	}
 	defer logger.Sync()
 
-	if err := kafka.CreateTopics(logger); err != nil {
-		logger.Fatal("Failed to create Kafka topics", zap.Error(err))
+	msgBroker, err := kafka.Connect()
+	if err != nil {
+		logger.Fatal("Failed to connect to the message broker", zap.Error(err))
+	}
+	if err := kafka.ReconcileTopics(msgBroker, logger); err != nil {
+		logger.Fatal("Failed to provision topics", zap.Error(err))
 	}
 
 	logRepo, err := logs.NewLogRepository(logger)
@@ -33,22 +49,94 @@ func main() {
 	}
 	defer logRepo.Close()
 
+	auditRepo, err := audit.NewRepository(logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize audit repository", zap.Error(err))
+	}
+	defer auditRepo.Close()
+
+	webhookRepo, err := webhook.NewRepository()
+	if err != nil {
+		logger.Fatal("Failed to initialize webhook repository", zap.Error(err))
+	}
+	defer webhookRepo.Close()
+	dispatcher := webhook.NewDispatcher(webhookRepo, logger)
+
+	scheduleRepo, err := schedule.NewRepository()
+	if err != nil {
+		logger.Fatal("Failed to initialize schedule repository", zap.Error(err))
+	}
+	defer scheduleRepo.Close()
+	scheduler := schedule.NewScheduler(scheduleRepo, config.GRPCAddress, logger)
+
+	deadLetterRepo, err := deadletter.NewRepository()
+	if err != nil {
+		logger.Fatal("Failed to initialize dead letter repository", zap.Error(err))
+	}
+	defer deadLetterRepo.Close()
+
 	emailSender := email.NewEmailSender(logger)
 	msgHandler := message.NewMessageHandler(logger, config.GRPCAddress)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	consumer, err := kafka.NewConsumer(logger)
+	dedupStore, err := dedup.NewStore(logger)
+	if err != nil {
+		logger.Fatal("Failed to initialize deduplication store", zap.Error(err))
+	}
+	defer dedupStore.Close()
+
+	producer := kafka.NewProducer(msgBroker, logger)
+	defer producer.Close()
+
+	consumer, err := kafka.NewConsumer(msgBroker, producer, dedupStore, logger)
 	if err != nil {
 		logger.Fatal("Failed to create consumer", zap.Error(err))
 	}
 
-	consumer.RegisterHandler(config.EmailTopic, emailSender.SendRegistrationEmail)
-	consumer.RegisterHandler(config.LogsTopic, logRepo.StoreLog)
-	consumer.RegisterHandler(config.MessageTopic, msgHandler.HandleMessage)
+	consumer.RegisterHandler(config.EmailTopic, "email", emailSender.HandleEmail)
+	consumer.RegisterHandler(config.LogsTopic, "logs", logRepo.StoreLog)
+	consumer.RegisterHandler(config.MessageTopic, "delivery", msgHandler.HandleMessage)
+	consumer.RegisterHandler(config.AuditTopic, "audit", auditRepo.StoreEvent)
+	consumer.RegisterHandler(config.MessageTopic, "webhooks", dispatcher.HandleMessage)
+	consumer.RegisterHandler(config.UserEventsTopic, "webhooks", dispatcher.HandleUserEvent)
+	consumer.RegisterHandler(config.UserEventsTopic, "schedules", scheduler.HandleUserEvent)
+	consumer.RegisterHandler(config.DeadLetterTopic, "dead-letters", deadLetterRepo.Store)
 
 	consumer.Start(ctx)
+	go dedupStore.Run(ctx)
+	go dispatcher.Run(ctx)
+	go scheduler.Run(ctx)
+
+	lis, err := net.Listen("tcp", config.GRPCServerAddress)
+	if err != nil {
+		logger.Fatal("Failed to listen on port "+config.GRPCServerAddress, zap.Error(err))
+	}
+	permissionClient, err := grpcclient.NewPermissionClient(config.UserServiceGRPCAddress)
+	if err != nil {
+		logger.Fatal("Failed to create permission gRPC client", zap.Error(err))
+	}
+	defer permissionClient.Close()
+	grpcServer := grpc.NewGRPCServer(auditRepo, webhookRepo, scheduleRepo, permissionClient, deadLetterRepo, producer, logger)
+	go func() {
+		logger.Info("Starting gRPC server on port " + config.GRPCServerAddress)
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Fatal("Failed to start gRPC server", zap.Error(err))
+		}
+	}()
+
+	// the broker is left out of liveness so that an outage doesn't restart the workers
+	healthServer := health.NewServer(config.HealthAddress,
+		map[string]health.Check{"database": dedupStore.Ping},
+		map[string]health.Check{"broker": msgBroker.Ping},
+		logger)
+	go func() {
+		logger.Info("Starting health server on port " + config.HealthAddress)
+		if err := healthServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal("Failed to start health server", zap.Error(err))
+		}
+	}()
 
 	logger.Info("Worker service started. Press Ctrl+C to exit.")
 
@@ -57,9 +145,15 @@ func main() {
 	<-sigChan
 
 	logger.Info("Shutting down...")
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := healthServer.Shutdown(shutdownCtx); err != nil {
+		logger.Error("Failed to stop health server", zap.Error(err))
+	}
+	grpcServer.GracefulStop()
 	cancel()
 	if err := consumer.Close(); err != nil {
-		logger.Error("Failed to close Kafka consumer", zap.Error(err))
+		logger.Error("Failed to close consumer", zap.Error(err))
 	}
 	logger.Info("Worker service stopped gracefully")
 }
@@ -0,0 +1,228 @@
+// Command topics lists, describes and alters the topics on the message broker,
+// and applies the specs in config.TopicSpecs as the worker does at startup.
+// alter only changes the partitions of the topics with a spec; their retention
+// and compaction are changed in TopicSpecs.
+//
+//	topics [-driver kafka] [-brokers host:port] [-nats url] list
+//	topics [-driver kafka] [-brokers host:port] [-nats url] describe <topic>...
+//	topics [-driver kafka] [-brokers host:port] [-nats url] alter [-partitions n] [-retention 72h] [-compact=false] <topic>
+//	topics [-driver kafka] [-brokers host:port] [-nats url] apply
+//
+// Inside the workerservice container run it as ./topics.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"chatapp/broker"
+	"workerservice/config"
+	"workerservice/internal/kafka"
+
+	"go.uber.org/zap"
+)
+
+func main() {
+	defaults := broker.FromEnv(broker.Config{Driver: config.BrokerDriver, KafkaBrokers: config.KafkaBrokers, NATSURL: config.NATSURL})
+	driver := flag.String("driver", defaults.Driver, "broker driver: kafka or nats")
+	brokers := flag.String("brokers", defaults.KafkaBrokers, "comma separated Kafka brokers")
+	natsURL := flag.String("nats", defaults.NATSURL, "NATS server URL")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	b, err := broker.Open(broker.Config{Driver: *driver, KafkaBrokers: *brokers, NATSURL: *natsURL})
+	if err != nil {
+		fatal(err)
+	}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	args := flag.Args()
+	switch args[0] {
+	case "list":
+		list(ctx, b)
+	case "describe":
+		if len(args) < 2 {
+			usage()
+			os.Exit(2)
+		}
+		describe(ctx, b, args[1:])
+	case "alter":
+		alter(ctx, b, args[1:])
+	case "apply":
+		logger, err := zap.NewDevelopment()
+		if err != nil {
+			fatal(err)
+		}
+		if err := kafka.ReconcileTopics(b, logger); err != nil {
+			fatal(err)
+		}
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func list(ctx context.Context, b broker.Broker) {
+	topics, err := b.DescribeTopics(ctx)
+	if err != nil {
+		fatal(err)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITIONS\tREPLICATION\tRETENTION\tCOMPACT")
+	for _, t := range topics {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%t\n", t.Topic, partitions(t.NumPartitions), t.ReplicationFactor,
+			kafka.FormatRetention(t.Retention), t.Compact)
+	}
+	w.Flush()
+}
+
+func describe(ctx context.Context, b broker.Broker, names []string) {
+	topics, err := b.DescribeTopics(ctx, names...)
+	if err != nil {
+		fatal(err)
+	}
+	found := map[string]bool{}
+	for i, t := range topics {
+		found[t.Topic] = true
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("Topic:       %s\n", t.Topic)
+		fmt.Printf("Partitions:  %s\n", partitions(t.NumPartitions))
+		fmt.Printf("Replication: %d\n", t.ReplicationFactor)
+		fmt.Printf("Retention:   %s\n", kafka.FormatRetention(t.Retention))
+		fmt.Printf("Compact:     %t\n", t.Compact)
+		if slices.Contains(kafka.Topics(), t.Topic) {
+			spec := kafka.TopicConfig(t.Topic)
+			fmt.Printf("Spec:        %d partitions, replication %d, retention %s, compact %t\n", spec.NumPartitions,
+				spec.ReplicationFactor, kafka.FormatRetention(spec.Retention), spec.Compact)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PARTITION\tLEADER\tREPLICAS\tIN SYNC")
+		for _, p := range t.Partitions {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", p.ID, dash(p.Leader), dash(strings.Join(p.Replicas, ",")), dash(strings.Join(p.InSync, ",")))
+		}
+		w.Flush()
+	}
+
+	failed := false
+	for _, name := range names {
+		if !found[name] {
+			fmt.Fprintf(os.Stderr, "%s: no such topic\n", name)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func alter(ctx context.Context, b broker.Broker, args []string) {
+	fs := flag.NewFlagSet("alter", flag.ExitOnError)
+	numPartitions := fs.Int("partitions", 0, "number of partitions; they can only be added")
+	retention := fs.String("retention", "", `how long messages are kept, like 72h or 7d, "forever" or "default"`)
+	compact := fs.Bool("compact", false, "keep the last message of each key instead of deleting old messages")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	topics, err := b.DescribeTopics(ctx, fs.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+	if len(topics) == 0 {
+		fatal(fmt.Errorf("%s: no such topic", fs.Arg(0)))
+	}
+	cfg := topics[0].TopicConfig
+	var parseErr error
+	var specified []string
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "partitions":
+			cfg.NumPartitions = *numPartitions
+		case "retention":
+			cfg.Retention, parseErr = parseRetention(*retention)
+			specified = append(specified, kafka.SettingRetention)
+		case "compact":
+			cfg.Compact = *compact
+			specified = append(specified, kafka.SettingCompaction)
+		}
+	})
+	if parseErr != nil {
+		fatal(parseErr)
+	}
+	if err := kafka.CheckSpecOwned(cfg.Topic, specified); err != nil {
+		fatal(err)
+	}
+
+	if err := b.AlterTopic(ctx, cfg); err != nil {
+		fatal(err)
+	}
+	fmt.Printf("%s: %s partitions, retention %s, compact %t\n", cfg.Topic, partitions(cfg.NumPartitions),
+		kafka.FormatRetention(cfg.Retention), cfg.Compact)
+}
+
+func parseRetention(s string) (time.Duration, error) {
+	switch s {
+	case "forever":
+		return -1, nil
+	case "default":
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, errors.New("invalid retention " + strconv.Quote(s))
+}
+
+// partitions formats a partition count, which is zero on brokers without
+// partitions.
+func partitions(n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return strconv.Itoa(n)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `Usage:
+  topics [-driver kafka|nats] [-brokers host:port] [-nats url] list
+  topics [-driver kafka|nats] [-brokers host:port] [-nats url] describe <topic>...
+  topics [-driver kafka|nats] [-brokers host:port] [-nats url] alter [-partitions n] [-retention 72h|7d|forever|default] [-compact=true|false] <topic>
+  topics [-driver kafka|nats] [-brokers host:port] [-nats url] apply`)
+}
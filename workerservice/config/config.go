@@ -1,14 +1,79 @@
 package config
 
+import "time"
+
 const (
+	// BrokerDriver selects the message broker: "kafka" uses KafkaBrokers and
+	// "nats" uses NATSURL. The BROKER_DRIVER, KAFKA_BROKERS and NATS_URL
+	// environment variables override them.
+	BrokerDriver = "kafka"
+	NATSURL      = "nats://nats:4222"
 	KafkaBrokers = "kafka:9093"
 	//KafkaBrokers = "localhost:9092"
 	KafkaGroupID = "chatapp-consumer-group"
 	KafkaWorkers = 10
-	GRPCAddress  = "notificationservice:50051"
-	EmailTopic   = "email"
-	LogsTopic    = "logs"
-	MessageTopic = "message"
+	// KafkaWorkerQueue is how many fetched messages wait for each worker
+	KafkaWorkerQueue = 100
+	// A message whose event id a handler has processed within DedupWindow is
+	// skipped by that handler. Older entries are pruned every DedupPruneInterval.
+	DedupWindow        = 7 * 24 * time.Hour
+	DedupPruneInterval = time.Hour
+
+	GRPCAddress = "notificationservice:50051"
+	// UserServiceGRPCAddress checks that the owner of a new schedule may send
+	// messages, as MessagesSendPermission
+	UserServiceGRPCAddress = "userservice:50053"
+	MessagesSendPermission = "messages:send"
+	EmailTopic             = "email"
+	LogsTopic              = "logs"
+	MessageTopic           = "message"
+	// UserEventsTopic carries account lifecycle events such as user.deleted
+	UserEventsTopic = "users"
+	// AuditTopic carries security audit events, stored in the audit_log table
+	AuditTopic = "audit"
+	// DeadLetterTopic receives messages that failed every attempt, wrapped with
+	// the failure details. Each consumed topic also has a "<topic>.retry.<delay>"
+	// topic per backoff delay of its RetryPolicy, such as "email.retry.2m".
+	DeadLetterTopic = "dead-letters"
+	// GRPCServerAddress serves the audit trail and webhook management
+	GRPCServerAddress = ":50052"
+	// HealthAddress serves /metrics, /healthz and /readyz. The checks of a
+	// request must finish within HealthCheckTimeout.
+	HealthAddress      = ":9102"
+	HealthCheckTimeout = 2 * time.Second
+	// LagInterval is how often the consumer group's lag is asked from the broker
+	LagInterval = 15 * time.Second
+
+	// Webhook deliveries are retried with exponential backoff, from
+	// WebhookBackoffBase up to WebhookBackoffMax, WebhookMaxAttempts times.
+	// An endpoint is disabled after WebhookDisableAfter failures in a row.
+	WebhookMaxAttempts  = 8
+	WebhookBackoffBase  = 30 * time.Second
+	WebhookBackoffMax   = time.Hour
+	WebhookDisableAfter = 20
+	WebhookTimeout      = 10 * time.Second
+	WebhookPollInterval = 5 * time.Second
+	WebhookBatchSize    = 50
+	// WebhookSecretKey encrypts the signing secrets stored in the database
+	WebhookSecretKey = "webhook-secret-key"
+	// A delivery's body is dropped once it succeeds or fails for good; the
+	// delivery itself is pruned WebhookDeliveryRetention after its last attempt,
+	// checked every WebhookPruneInterval.
+	WebhookDeliveryRetention = 30 * 24 * time.Hour
+	WebhookPruneInterval     = time.Hour
+
+	// Schedules are checked every SchedulePollInterval. A failed delivery, or
+	// one whose outcome couldn't be recorded, is retried after
+	// ScheduleRetryDelay, up to ScheduleMaxAttempts attempts in all.
+	SchedulePollInterval = time.Second
+	ScheduleBatchSize    = 100
+	ScheduleRetryDelay   = 30 * time.Second
+	ScheduleMaxAttempts  = 5
+	// ScheduleMaxPending caps the pending schedules per user, ScheduleMaxAhead how
+	// far ahead they can be and ScheduleMaxLength their text
+	ScheduleMaxPending = 100
+	ScheduleMaxAhead   = 365 * 24 * time.Hour
+	ScheduleMaxLength  = 4000
 
 	SMTPHost     = "smtp.gmail.com"
 	SMTPPort     = 587
@@ -17,4 +82,59 @@ const (
 	FromEmail    = "bikram.7js@gmail.com"
 
 	DatabasePath = "logs.db"
+	// DatabaseDSN is how every repository opens DatabasePath. In WAL mode readers
+	// don't block the writer, and a write waits up to 5s for another's lock
+	// instead of failing with "database is locked".
+	DatabaseDSN = DatabasePath + "?_busy_timeout=5000&_journal_mode=WAL"
 )
+
+// ConsumedTopics are the topics the consumer reads and that get retry topics.
+var ConsumedTopics = []string{EmailTopic, LogsTopic, MessageTopic, UserEventsTopic, AuditTopic}
+
+// RetryPolicy says how a topic's failed messages are retried. MaxAttempts counts
+// the first attempt; the wait before each retry doubles from BackoffBase up to
+// BackoffMax.
+type RetryPolicy struct {
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+}
+
+// RetryPolicies holds the policy of each consumed topic. A message from a topic
+// without one goes to the dead-letter topic after its first failure.
+var RetryPolicies = map[string]RetryPolicy{
+	EmailTopic:      {MaxAttempts: 6, BackoffBase: 30 * time.Second, BackoffMax: 15 * time.Minute},
+	LogsTopic:       {MaxAttempts: 3, BackoffBase: 5 * time.Second, BackoffMax: time.Minute},
+	MessageTopic:    {MaxAttempts: 4, BackoffBase: 2 * time.Second, BackoffMax: 30 * time.Second},
+	UserEventsTopic: {MaxAttempts: 6, BackoffBase: 10 * time.Second, BackoffMax: 10 * time.Minute},
+	AuditTopic:      {MaxAttempts: 8, BackoffBase: 5 * time.Second, BackoffMax: 10 * time.Minute},
+}
+
+// TopicSpec is how a topic should be set up. The worker creates the topics it
+// reads with their spec at startup and alters existing ones that differ from
+// it. Retention zero leaves the broker's default, negative keeps messages
+// forever, and Compact keeps the last message of each key instead.
+type TopicSpec struct {
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration
+	Compact           bool
+}
+
+// DefaultTopicSpec applies to topics without an entry in TopicSpecs.
+var DefaultTopicSpec = TopicSpec{Partitions: 3, ReplicationFactor: 1, Retention: 7 * 24 * time.Hour}
+
+// TopicSpecs holds the spec of each consumed topic and the dead-letter topic.
+// A retry topic gets the spec of its topic. Partitions can be added but not
+// removed, and the replication factor of an existing Kafka topic isn't changed.
+// Adding partitions moves keys to other partitions, so messages with the same
+// key sent just before and after can be handled out of order. The topics
+// tool's alter refuses to change the retention or compaction these set.
+var TopicSpecs = map[string]TopicSpec{
+	EmailTopic:      DefaultTopicSpec,
+	LogsTopic:       {Partitions: 3, ReplicationFactor: 1, Retention: 3 * 24 * time.Hour},
+	MessageTopic:    DefaultTopicSpec,
+	UserEventsTopic: DefaultTopicSpec,
+	AuditTopic:      {Partitions: 3, ReplicationFactor: 1, Retention: 30 * 24 * time.Hour},
+	DeadLetterTopic: {Partitions: 3, ReplicationFactor: 1, Retention: 30 * 24 * time.Hour},
+}
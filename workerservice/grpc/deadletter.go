@@ -0,0 +1,111 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"workerservice/internal/deadletter"
+	"workerservice/internal/kafka"
+	pb "workerservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type deadLetterServer struct {
+	pb.UnimplementedDeadLetterServiceServer
+	repo     *deadletter.Repository
+	producer *kafka.Producer
+	logger   *zap.Logger
+}
+
+func (s *deadLetterServer) ListDeadLetters(ctx context.Context, req *pb.ListDeadLettersRequest) (*pb.ListDeadLettersResponse, error) {
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > maxQueryLimit {
+		limit = maxQueryLimit
+	}
+	entries, err := s.repo.List(req.GetTopic(), req.GetIncludeReplayed(), limit)
+	if err != nil {
+		s.logger.Error("Failed to list dead letters", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list dead letters")
+	}
+	resp := &pb.ListDeadLettersResponse{}
+	for _, e := range entries {
+		resp.DeadLetters = append(resp.DeadLetters, toDeadLetterProto(e))
+	}
+	return resp, nil
+}
+
+func (s *deadLetterServer) GetDeadLetter(ctx context.Context, req *pb.GetDeadLetterRequest) (*pb.DeadLetter, error) {
+	entry, err := s.get(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toDeadLetterProto(*entry), nil
+}
+
+func (s *deadLetterServer) ReplayDeadLetter(ctx context.Context, req *pb.ReplayDeadLetterRequest) (*pb.DeadLetter, error) {
+	entry, err := s.get(req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if entry.ReplayedAt.Valid {
+		return nil, status.Error(codes.FailedPrecondition, "dead letter was already replayed")
+	}
+
+	letter := kafka.DeadLetter{
+		EventID:       entry.EventID,
+		EventType:     entry.EventType,
+		SchemaVersion: entry.Version,
+		Topic:         entry.Topic,
+		Handler:       entry.Handler,
+		Key:           entry.Key,
+		Payload:       entry.Payload,
+	}
+	if err := s.producer.Replay(letter); err != nil {
+		s.logger.Error("Failed to replay dead letter", zap.Int64("id", entry.ID), zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "failed to publish the message")
+	}
+	if err := s.repo.MarkReplayed(entry.ID); err != nil {
+		s.logger.Error("Failed to mark dead letter replayed", zap.Int64("id", entry.ID), zap.Error(err))
+	}
+	s.logger.Info("Dead letter replayed", zap.Int64("id", entry.ID), zap.String("topic", entry.Topic), zap.String("handler", entry.Handler))
+
+	entry.ReplayedAt.Time, entry.ReplayedAt.Valid = time.Now(), true
+	return toDeadLetterProto(*entry), nil
+}
+
+func (s *deadLetterServer) get(id int64) (*deadletter.Entry, error) {
+	entry, err := s.repo.Get(id)
+	if err != nil {
+		s.logger.Error("Failed to get dead letter", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to get dead letter")
+	}
+	if entry == nil {
+		return nil, status.Error(codes.NotFound, "dead letter not found")
+	}
+	return entry, nil
+}
+
+func toDeadLetterProto(e deadletter.Entry) *pb.DeadLetter {
+	d := &pb.DeadLetter{
+		Id:        e.ID,
+		EventId:   e.EventID,
+		EventType: e.EventType,
+		Version:   int32(e.Version),
+		Topic:     e.Topic,
+		Handler:   e.Handler,
+		Key:       e.Key,
+		Payload:   e.Payload,
+		Error:     e.Error,
+		Attempts:  int32(e.Attempts),
+		Partition: int32(e.Partition),
+		Offset:    e.Offset,
+		FailedAt:  e.FailedAt.UTC().Format(time.RFC3339),
+	}
+	if e.ReplayedAt.Valid {
+		d.ReplayedAt = e.ReplayedAt.Time.UTC().Format(time.RFC3339)
+	}
+	return d
+}
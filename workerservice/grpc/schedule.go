@@ -0,0 +1,127 @@
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"workerservice/config"
+	"workerservice/grpcclient"
+	"workerservice/internal/schedule"
+	pb "workerservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type scheduleServer struct {
+	pb.UnimplementedScheduleServiceServer
+	repo        *schedule.Repository
+	permissions *grpcclient.PermissionClient
+	logger      *zap.Logger
+}
+
+// CreateSchedule stores a message from the owner to the recipients, or a
+// reminder that is sent to the owner alone. The message is sent as the owner,
+// so userservice must confirm that the owner is active and may send messages.
+func (s *scheduleServer) CreateSchedule(ctx context.Context, req *pb.CreateScheduleRequest) (*pb.Schedule, error) {
+	if req.GetOwner() == "" {
+		return nil, status.Error(codes.InvalidArgument, "owner is required")
+	}
+	message := strings.TrimSpace(req.GetMessage())
+	if message == "" || len(message) > config.ScheduleMaxLength {
+		return nil, status.Errorf(codes.InvalidArgument, "message is required and at most %d characters", config.ScheduleMaxLength)
+	}
+	sendAt, err := time.Parse(time.RFC3339, req.GetSendAt())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, "send_at must be an RFC 3339 timestamp")
+	}
+	if !sendAt.After(time.Now()) || sendAt.After(time.Now().Add(config.ScheduleMaxAhead)) {
+		return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("send_at must be in the future and within %d days", config.ScheduleMaxAhead/(24*time.Hour)))
+	}
+
+	sched := schedule.Schedule{Owner: req.GetOwner(), Kind: req.GetKind(), Message: message, SendAt: sendAt.Unix()}
+	switch req.GetKind() {
+	case schedule.KindReminder:
+		sched.Sender = "reminder"
+		sched.Recipients = req.GetOwner()
+	case schedule.KindMessage:
+		if len(req.GetRecipients()) == 0 {
+			return nil, status.Error(codes.InvalidArgument, "recipients are required")
+		}
+		for _, r := range req.GetRecipients() {
+			if r == "" || strings.Contains(r, ",") {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid recipient %q", r)
+			}
+		}
+		sched.Sender = req.GetOwner()
+		sched.Recipients = strings.Join(req.GetRecipients(), ",")
+	default:
+		return nil, status.Error(codes.InvalidArgument, "kind must be message or reminder")
+	}
+
+	check, err := s.permissions.CheckPermission(req.GetOwner(), config.MessagesSendPermission)
+	if err != nil {
+		s.logger.Error("Failed to call CheckPermission RPC", zap.Error(err))
+		return nil, status.Error(codes.Unavailable, "failed to check the owner's permissions")
+	}
+	if !check.GetAllowed() {
+		return nil, status.Error(codes.PermissionDenied, "owner can't send messages: "+check.GetReason())
+	}
+
+	pending, err := s.repo.CountPending(req.GetOwner())
+	if err != nil {
+		s.logger.Error("Failed to count schedules", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to create schedule")
+	}
+	if pending >= config.ScheduleMaxPending {
+		return nil, status.Error(codes.ResourceExhausted, "too many pending schedules")
+	}
+
+	created, err := s.repo.Create(sched)
+	if err != nil {
+		s.logger.Error("Failed to create schedule", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to create schedule")
+	}
+	return toScheduleProto(created), nil
+}
+
+func (s *scheduleServer) ListSchedules(ctx context.Context, req *pb.ListSchedulesRequest) (*pb.ListSchedulesResponse, error) {
+	schedules, err := s.repo.Pending(req.GetOwner())
+	if err != nil {
+		s.logger.Error("Failed to list schedules", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list schedules")
+	}
+	resp := &pb.ListSchedulesResponse{}
+	for _, sched := range schedules {
+		resp.Schedules = append(resp.Schedules, toScheduleProto(sched))
+	}
+	return resp, nil
+}
+
+func (s *scheduleServer) CancelSchedule(ctx context.Context, req *pb.CancelScheduleRequest) (*pb.CancelScheduleResponse, error) {
+	found, err := s.repo.Cancel(req.GetId(), req.GetOwner())
+	if err != nil {
+		s.logger.Error("Failed to cancel schedule", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to cancel schedule")
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "no pending schedule with that id")
+	}
+	return &pb.CancelScheduleResponse{}, nil
+}
+
+func toScheduleProto(s schedule.Schedule) *pb.Schedule {
+	return &pb.Schedule{
+		Id:         s.ID,
+		Owner:      s.Owner,
+		Kind:       s.Kind,
+		Sender:     s.Sender,
+		Recipients: s.RecipientList(),
+		Message:    s.Message,
+		SendAt:     time.Unix(s.SendAt, 0).UTC().Format(time.RFC3339),
+		CreatedAt:  s.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,125 @@
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"workerservice/grpcclient"
+	"workerservice/internal/audit"
+	"workerservice/internal/deadletter"
+	"workerservice/internal/kafka"
+	"workerservice/internal/schedule"
+	"workerservice/internal/webhook"
+	pb "workerservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+const maxQueryLimit = 500
+
+type server struct {
+	pb.UnimplementedAuditServiceServer
+	repo   *audit.Repository
+	logger *zap.Logger
+}
+
+func NewGRPCServer(repo *audit.Repository, webhooks *webhook.Repository, schedules *schedule.Repository,
+	permissions *grpcclient.PermissionClient, deadLetters *deadletter.Repository, producer *kafka.Producer, logger *zap.Logger) *grpc.Server {
+	s := grpc.NewServer()
+	pb.RegisterAuditServiceServer(s, &server{repo: repo, logger: logger})
+	pb.RegisterWebhookServiceServer(s, &webhookServer{repo: webhooks, logger: logger})
+	pb.RegisterScheduleServiceServer(s, &scheduleServer{repo: schedules, permissions: permissions, logger: logger})
+	pb.RegisterDeadLetterServiceServer(s, &deadLetterServer{repo: deadLetters, producer: producer, logger: logger})
+	reflection.Register(s)
+	return s
+}
+
+func (s *server) QueryAudit(ctx context.Context, req *pb.QueryAuditRequest) (*pb.QueryAuditResponse, error) {
+	filter, err := toFilter(req)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = int(req.GetLimit())
+	if filter.Limit <= 0 || filter.Limit > maxQueryLimit {
+		filter.Limit = maxQueryLimit
+	}
+	filter.Offset = int(req.GetOffset())
+
+	entries, total, err := s.repo.Query(filter)
+	if err != nil {
+		s.logger.Error("Failed to query audit log", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to query audit log")
+	}
+	resp := &pb.QueryAuditResponse{Total: int64(total)}
+	for _, entry := range entries {
+		resp.Entries = append(resp.Entries, toProto(entry))
+	}
+	return resp, nil
+}
+
+func (s *server) ExportAudit(req *pb.QueryAuditRequest, stream grpc.ServerStreamingServer[pb.AuditEntry]) error {
+	filter, err := toFilter(req)
+	if err != nil {
+		return err
+	}
+	err = s.repo.Export(filter, func(entry audit.Entry) error {
+		return stream.Send(toProto(entry))
+	})
+	if err != nil {
+		s.logger.Error("Failed to export audit log", zap.Error(err))
+		return status.Error(codes.Internal, "failed to export audit log")
+	}
+	return nil
+}
+
+func (s *server) VerifyAudit(ctx context.Context, req *pb.VerifyAuditRequest) (*pb.VerifyAuditResponse, error) {
+	count, broken, err := s.repo.Verify()
+	if err != nil {
+		s.logger.Error("Failed to verify audit log", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to verify audit log")
+	}
+	return &pb.VerifyAuditResponse{Valid: broken == 0, Entries: count, BrokenSeq: broken}, nil
+}
+
+func toFilter(req *pb.QueryAuditRequest) (audit.Filter, error) {
+	filter := audit.Filter{
+		Type:     req.GetType(),
+		ActorID:  req.GetActorId(),
+		TargetID: req.GetTargetId(),
+	}
+	var err error
+	if req.GetSince() != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, req.GetSince()); err != nil {
+			return filter, status.Error(codes.InvalidArgument, "since must be an RFC 3339 timestamp")
+		}
+	}
+	if req.GetUntil() != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, req.GetUntil()); err != nil {
+			return filter, status.Error(codes.InvalidArgument, "until must be an RFC 3339 timestamp")
+		}
+	}
+	return filter, nil
+}
+
+func toProto(entry audit.Entry) *pb.AuditEntry {
+	var details map[string]string
+	json.Unmarshal([]byte(entry.Details), &details)
+	return &pb.AuditEntry{
+		Seq:      entry.Seq,
+		Type:     entry.Type,
+		ActorId:  entry.ActorID,
+		TargetId: entry.TargetID,
+		Ip:       entry.IP,
+		Outcome:  entry.Outcome,
+		Service:  entry.Service,
+		Details:  details,
+		Time:     entry.Time,
+		PrevHash: entry.PrevHash,
+		Hash:     entry.Hash,
+	}
+}
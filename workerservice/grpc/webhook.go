@@ -0,0 +1,151 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"chatapp/outbound"
+
+	"workerservice/internal/webhook"
+	pb "workerservice/proto"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const maxDeliveriesLimit = 200
+
+type webhookServer struct {
+	pb.UnimplementedWebhookServiceServer
+	repo   *webhook.Repository
+	logger *zap.Logger
+}
+
+func (s *webhookServer) CreateWebhook(ctx context.Context, req *pb.CreateWebhookRequest) (*pb.CreateWebhookResponse, error) {
+	u, err := outbound.CheckURL(req.GetUrl())
+	if err == outbound.ErrForbiddenAddress {
+		return nil, status.Error(codes.InvalidArgument, "url must not point to a local or private address")
+	} else if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if len(req.GetEvents()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one event type is required")
+	}
+	for _, event := range req.GetEvents() {
+		if !webhook.EventTypes[event] {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown event type %q", event)
+		}
+	}
+	secret := req.GetSecret()
+	if secret == "" {
+		if secret, err = webhook.NewSecret(); err != nil {
+			return nil, status.Error(codes.Internal, "failed to generate secret")
+		}
+	}
+
+	created, err := s.repo.Create(webhook.Webhook{URL: u.String(), Secret: secret, Events: strings.Join(req.GetEvents(), ",")})
+	if err != nil {
+		s.logger.Error("Failed to create webhook", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to create webhook")
+	}
+	return &pb.CreateWebhookResponse{Webhook: toWebhookProto(created), Secret: secret}, nil
+}
+
+func (s *webhookServer) ListWebhooks(ctx context.Context, req *pb.ListWebhooksRequest) (*pb.ListWebhooksResponse, error) {
+	webhooks, err := s.repo.List()
+	if err != nil {
+		s.logger.Error("Failed to list webhooks", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list webhooks")
+	}
+	resp := &pb.ListWebhooksResponse{}
+	for _, w := range webhooks {
+		resp.Webhooks = append(resp.Webhooks, toWebhookProto(w))
+	}
+	return resp, nil
+}
+
+func (s *webhookServer) SetWebhookEnabled(ctx context.Context, req *pb.SetWebhookEnabledRequest) (*pb.Webhook, error) {
+	reason := ""
+	if !req.GetEnabled() {
+		reason = "disabled by an administrator"
+	}
+	found, err := s.repo.SetEnabled(req.GetId(), req.GetEnabled(), reason)
+	if err != nil {
+		s.logger.Error("Failed to update webhook", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to update webhook")
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+	w, err := s.repo.Get(req.GetId())
+	if err != nil || w == nil {
+		return nil, status.Error(codes.Internal, "failed to load webhook")
+	}
+	return toWebhookProto(*w), nil
+}
+
+func (s *webhookServer) DeleteWebhook(ctx context.Context, req *pb.DeleteWebhookRequest) (*pb.DeleteWebhookResponse, error) {
+	found, err := s.repo.Delete(req.GetId())
+	if err != nil {
+		s.logger.Error("Failed to delete webhook", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to delete webhook")
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+	return &pb.DeleteWebhookResponse{}, nil
+}
+
+func (s *webhookServer) ListDeliveries(ctx context.Context, req *pb.ListDeliveriesRequest) (*pb.ListDeliveriesResponse, error) {
+	w, err := s.repo.Get(req.GetWebhookId())
+	if err != nil {
+		return nil, status.Error(codes.Internal, "failed to load webhook")
+	}
+	if w == nil {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+	limit := int(req.GetLimit())
+	if limit <= 0 || limit > maxDeliveriesLimit {
+		limit = maxDeliveriesLimit
+	}
+	deliveries, err := s.repo.Deliveries(w.ID, limit)
+	if err != nil {
+		s.logger.Error("Failed to list webhook deliveries", zap.Error(err))
+		return nil, status.Error(codes.Internal, "failed to list deliveries")
+	}
+	resp := &pb.ListDeliveriesResponse{}
+	for _, d := range deliveries {
+		delivery := &pb.Delivery{
+			Id:             d.ID,
+			WebhookId:      d.WebhookID,
+			EventId:        d.EventID,
+			EventType:      d.EventType,
+			PayloadSha256:  d.PayloadSHA256,
+			Status:         d.Status,
+			Attempts:       int32(d.Attempts),
+			LastStatusCode: int32(d.LastStatusCode),
+			LastError:      d.LastError,
+			CreatedAt:      d.CreatedAt.UTC().Format(time.RFC3339),
+			UpdatedAt:      d.UpdatedAt.UTC().Format(time.RFC3339),
+		}
+		if d.Status == webhook.StatusPending {
+			delivery.NextAttemptAt = time.Unix(d.NextAttemptAt, 0).UTC().Format(time.RFC3339)
+		}
+		resp.Deliveries = append(resp.Deliveries, delivery)
+	}
+	return resp, nil
+}
+
+func toWebhookProto(w webhook.Webhook) *pb.Webhook {
+	return &pb.Webhook{
+		Id:                  w.ID,
+		Url:                 w.URL,
+		Events:              w.EventTypes(),
+		Enabled:             w.Enabled,
+		ConsecutiveFailures: int32(w.ConsecutiveFailures),
+		DisabledReason:      w.DisabledReason,
+		CreatedAt:           w.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
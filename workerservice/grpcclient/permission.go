@@ -0,0 +1,35 @@
+package grpcclient
+
+import (
+	"context"
+	"time"
+
+	pb "workerservice/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// PermissionClient asks userservice what a principal may do.
+type PermissionClient struct {
+	conn   *grpc.ClientConn
+	client pb.PermissionServiceClient
+}
+
+func NewPermissionClient(address string) (*PermissionClient, error) {
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, err
+	}
+	return &PermissionClient{conn: conn, client: pb.NewPermissionServiceClient(conn)}, nil
+}
+
+func (c *PermissionClient) Close() {
+	c.conn.Close()
+}
+
+func (c *PermissionClient) CheckPermission(principal string, permission string) (*pb.CheckPermissionResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return c.client.CheckPermission(ctx, &pb.CheckPermissionRequest{Principal: principal, Permission: permission})
+}
@@ -0,0 +1,274 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"chatapp/events"
+	"workerservice/config"
+	"workerservice/internal/kafka"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+// timeLayout is fixed width so that stored times compare correctly as text.
+const timeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// Repository is the append-only audit trail. Every entry carries the hash of the
+// one before it, so altering or removing an entry breaks the chain from there on.
+type Repository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+	// mu serializes appends so that each entry chains to the latest one
+	mu sync.Mutex
+}
+
+type Entry struct {
+	Seq      int64  `db:"seq"`
+	Type     string `db:"type"`
+	ActorID  string `db:"actor_id"`
+	TargetID string `db:"target_id"`
+	IP       string `db:"ip"`
+	Outcome  string `db:"outcome"`
+	Service  string `db:"service"`
+	Details  string `db:"details"`
+	Time     string `db:"time"`
+	PrevHash string `db:"prev_hash"`
+	Hash     string `db:"hash"`
+}
+
+// Filter selects entries; zero fields match everything.
+type Filter struct {
+	Type     string
+	ActorID  string
+	TargetID string
+	Since    time.Time
+	Until    time.Time
+	Limit    int
+	Offset   int
+}
+
+func NewRepository(logger *zap.Logger) (*Repository, error) {
+	db, err := sqlx.Connect("sqlite3", config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	return newRepository(db, logger)
+}
+
+func newRepository(db *sqlx.DB, logger *zap.Logger) (*Repository, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS audit_log (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			type TEXT NOT NULL,
+			actor_id TEXT NOT NULL DEFAULT '',
+			target_id TEXT NOT NULL DEFAULT '',
+			ip TEXT NOT NULL DEFAULT '',
+			outcome TEXT NOT NULL,
+			service TEXT NOT NULL,
+			details TEXT NOT NULL DEFAULT '{}',
+			time TEXT NOT NULL,
+			prev_hash TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_type ON audit_log (type)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log (actor_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_time ON audit_log (time)`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create audit_log table: %w", err)
+		}
+	}
+
+	return &Repository{db: db, logger: logger}, nil
+}
+
+// StoreEvent is the audit topic handler.
+func (r *Repository) StoreEvent(data []byte) error {
+	var event events.Audit
+	if err := json.Unmarshal(data, &event); err != nil {
+		return kafka.Permanent(fmt.Errorf("failed to unmarshal audit event: %w", err))
+	}
+	if err := event.Validate(); err != nil {
+		return kafka.Permanent(err)
+	}
+	entry, err := r.Append(event)
+	if err != nil {
+		return err
+	}
+
+	r.logger.Info("Audit event stored", zap.String("type", entry.Type), zap.Int64("seq", entry.Seq))
+	return nil
+}
+
+// Append chains the event to the latest entry and stores it.
+func (r *Repository) Append(event events.Audit) (Entry, error) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	details := event.Details
+	if details == nil {
+		details = map[string]string{}
+	}
+	detailsJSON, err := json.Marshal(details)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to marshal audit details: %w", err)
+	}
+	entry := Entry{
+		Type:     event.Type,
+		ActorID:  event.ActorID,
+		TargetID: event.TargetID,
+		IP:       event.IP,
+		Outcome:  event.Outcome,
+		Service:  event.Service,
+		Details:  string(detailsJSON),
+		Time:     event.Time.UTC().Format(timeLayout),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to begin audit transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&entry.PrevHash, "SELECT hash FROM audit_log ORDER BY seq DESC LIMIT 1")
+	if err != nil && err != sql.ErrNoRows {
+		return Entry{}, fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+	entry.Hash = hashEntry(entry)
+
+	res, err := tx.Exec(`INSERT INTO audit_log (type, actor_id, target_id, ip, outcome, service, details, time, prev_hash, hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Type, entry.ActorID, entry.TargetID, entry.IP, entry.Outcome, entry.Service,
+		entry.Details, entry.Time, entry.PrevHash, entry.Hash)
+	if err != nil {
+		return Entry{}, fmt.Errorf("failed to insert audit entry: %w", err)
+	}
+	if entry.Seq, err = res.LastInsertId(); err != nil {
+		return Entry{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Entry{}, fmt.Errorf("failed to commit audit entry: %w", err)
+	}
+	return entry, nil
+}
+
+// Query returns one page of matching entries, newest first, and the total number of matches.
+func (r *Repository) Query(filter Filter) ([]Entry, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := r.db.Get(&total, "SELECT COUNT(*) FROM audit_log"+where, args...); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit entries: %w", err)
+	}
+
+	entries := []Entry{}
+	query := "SELECT * FROM audit_log" + where + " ORDER BY seq DESC LIMIT ? OFFSET ?"
+	if err := r.db.Select(&entries, query, append(args, filter.Limit, filter.Offset)...); err != nil {
+		return nil, 0, fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	return entries, total, nil
+}
+
+// Export calls fn for every matching entry in chain order.
+func (r *Repository) Export(filter Filter, fn func(Entry) error) error {
+	where, args := filter.where()
+	rows, err := r.db.Queryx("SELECT * FROM audit_log"+where+" ORDER BY seq", args...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry Entry
+		if err := rows.StructScan(&entry); err != nil {
+			return fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Verify walks the whole chain and returns the number of entries checked and the
+// seq of the first entry that doesn't match its hash or predecessor, 0 if none.
+func (r *Repository) Verify() (int64, int64, error) {
+	var count int64
+	var prevHash string
+	var broken int64
+	err := r.Export(Filter{}, func(entry Entry) error {
+		count++
+		if broken == 0 && (entry.PrevHash != prevHash || hashEntry(entry) != entry.Hash) {
+			broken = entry.Seq
+		}
+		prevHash = entry.Hash
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if broken != 0 {
+		r.logger.Error("Audit chain is broken", zap.Int64("seq", broken))
+	}
+	return count, broken, nil
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
+
+func (f Filter) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Type != "" {
+		conds = append(conds, "type = ?")
+		args = append(args, f.Type)
+	}
+	if f.ActorID != "" {
+		conds = append(conds, "actor_id = ?")
+		args = append(args, f.ActorID)
+	}
+	if f.TargetID != "" {
+		conds = append(conds, "target_id = ?")
+		args = append(args, f.TargetID)
+	}
+	if !f.Since.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, f.Since.UTC().Format(timeLayout))
+	}
+	if !f.Until.IsZero() {
+		conds = append(conds, "time < ?")
+		args = append(args, f.Until.UTC().Format(timeLayout))
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// hashEntry covers every stored field except seq and hash itself. Details are
+// kept as the JSON that was hashed, so the input is reproduced exactly.
+func hashEntry(e Entry) string {
+	fields := []string{e.PrevHash, e.Type, e.ActorID, e.TargetID, e.IP, e.Outcome, e.Service, e.Details, e.Time}
+	canonical, _ := json.Marshal(fields)
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,118 @@
+package audit
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"chatapp/events"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := newRepository(db, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func appendEvents(t *testing.T, repo *Repository, n int) []Entry {
+	t.Helper()
+	var entries []Entry
+	for i := 0; i < n; i++ {
+		event := events.NewAudit(events.AuditLoginSucceeded, events.AuditSuccess, "userservice")
+		event.ActorID = "user1"
+		event.Details = map[string]string{"n": string(rune('a' + i))}
+		entry, err := repo.Append(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAppendChainsEntries(t *testing.T) {
+	repo := newTestRepository(t)
+	entries := appendEvents(t, repo, 3)
+
+	if entries[0].PrevHash != "" {
+		t.Errorf("first entry has prev hash %q", entries[0].PrevHash)
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i].PrevHash != entries[i-1].Hash {
+			t.Errorf("entry %d doesn't chain to entry %d", entries[i].Seq, entries[i-1].Seq)
+		}
+	}
+
+	count, broken, err := repo.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || broken != 0 {
+		t.Errorf("Verify = %d entries, broken at %d; want 3 entries, unbroken", count, broken)
+	}
+}
+
+func TestAuditLogIsAppendOnly(t *testing.T) {
+	repo := newTestRepository(t)
+	appendEvents(t, repo, 1)
+
+	if _, err := repo.db.Exec("UPDATE audit_log SET actor_id = 'someone else'"); err == nil {
+		t.Error("an audit entry was updated")
+	}
+	if _, err := repo.db.Exec("DELETE FROM audit_log"); err == nil {
+		t.Error("an audit entry was deleted")
+	}
+}
+
+func TestVerifyFindsTampering(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper string
+		broken int64
+	}{
+		{"changed field", "UPDATE audit_log SET actor_id = 'someone else' WHERE seq = 2", 2},
+		{"rehashed entry", "UPDATE audit_log SET hash = 'x' WHERE seq = 2", 2},
+		{"removed entry", "DELETE FROM audit_log WHERE seq = 2", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newTestRepository(t)
+			appendEvents(t, repo, 4)
+			for _, stmt := range []string{"DROP TRIGGER audit_log_no_update", "DROP TRIGGER audit_log_no_delete", tt.tamper} {
+				if _, err := repo.db.Exec(stmt); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, broken, err := repo.Verify()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if broken != tt.broken {
+				t.Errorf("Verify broken at %d, want %d", broken, tt.broken)
+			}
+		})
+	}
+}
+
+func TestStoreEventRejectsUnknownTypes(t *testing.T) {
+	repo := newTestRepository(t)
+	data, _ := json.Marshal(events.NewAudit("login.succeded", events.AuditSuccess, "userservice"))
+	if err := repo.StoreEvent(data); err == nil {
+		t.Fatal("StoreEvent accepted an unknown type")
+	}
+	if count, _, _ := repo.Verify(); count != 0 {
+		t.Errorf("%d entries stored, want 0", count)
+	}
+}
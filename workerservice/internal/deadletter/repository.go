@@ -0,0 +1,128 @@
+// Package deadletter keeps the messages from the dead-letter topic so that they
+// can be looked at and replayed.
+package deadletter
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"workerservice/config"
+	"workerservice/internal/kafka"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type Entry struct {
+	ID         int64        `db:"id"`
+	EventID    string       `db:"event_id"`
+	EventType  string       `db:"event_type"`
+	Version    int          `db:"schema_version"`
+	Topic      string       `db:"topic"`
+	Handler    string       `db:"handler"`
+	Key        string       `db:"msg_key"`
+	Payload    string       `db:"payload"`
+	Error      string       `db:"error"`
+	Attempts   int          `db:"attempts"`
+	Partition  int          `db:"msg_partition"`
+	Offset     int64        `db:"msg_offset"`
+	FailedAt   time.Time    `db:"failed_at"`
+	ReplayedAt sql.NullTime `db:"replayed_at"`
+}
+
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository() (*Repository, error) {
+	db, err := sqlx.Connect("sqlite3", config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS dead_letters (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			topic TEXT NOT NULL,
+			handler TEXT NOT NULL,
+			msg_key TEXT NOT NULL DEFAULT '',
+			payload TEXT NOT NULL,
+			error TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			msg_partition INTEGER NOT NULL,
+			msg_offset INTEGER NOT NULL,
+			failed_at TIMESTAMP NOT NULL,
+			replayed_at TIMESTAMP,
+			event_id TEXT NOT NULL DEFAULT '',
+			event_type TEXT NOT NULL DEFAULT '',
+			schema_version INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_dead_letters_topic ON dead_letters (topic)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create dead_letters table: %w", err)
+		}
+	}
+	return &Repository{db: db}, nil
+}
+
+// Store handles messages from the dead-letter topic.
+func (r *Repository) Store(data []byte) error {
+	var letter kafka.DeadLetter
+	if err := json.Unmarshal(data, &letter); err != nil {
+		return fmt.Errorf("failed to unmarshal dead letter: %w", err)
+	}
+	_, err := r.db.Exec(`INSERT INTO dead_letters (event_id, event_type, schema_version, topic, handler, msg_key, payload, error,
+		attempts, msg_partition, msg_offset, failed_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		letter.EventID, letter.EventType, letter.SchemaVersion, letter.Topic, letter.Handler, letter.Key, letter.Payload, letter.Error,
+		letter.Attempts, letter.Partition, letter.Offset, letter.FailedAt)
+	if err != nil {
+		return fmt.Errorf("failed to store dead letter: %w", err)
+	}
+	return nil
+}
+
+// List returns the newest dead letters first, of one topic when topic isn't empty.
+func (r *Repository) List(topic string, includeReplayed bool, limit int) ([]Entry, error) {
+	query, args := "SELECT * FROM dead_letters WHERE 1 = 1", []interface{}{}
+	if topic != "" {
+		query += " AND topic = ?"
+		args = append(args, topic)
+	}
+	if !includeReplayed {
+		query += " AND replayed_at IS NULL"
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, limit)
+
+	entries := []Entry{}
+	if err := r.db.Select(&entries, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	return entries, nil
+}
+
+// Get returns nil when the dead letter doesn't exist.
+func (r *Repository) Get(id int64) (*Entry, error) {
+	var e Entry
+	err := r.db.Get(&e, "SELECT * FROM dead_letters WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	return &e, nil
+}
+
+func (r *Repository) MarkReplayed(id int64) error {
+	_, err := r.db.Exec("UPDATE dead_letters SET replayed_at = ? WHERE id = ?", time.Now().UTC(), id)
+	return err
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
@@ -0,0 +1,92 @@
+// Package dedup remembers which handlers have processed which events, so that
+// redelivered and replayed messages are handled only once.
+package dedup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"workerservice/config"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+	"go.uber.org/zap"
+)
+
+// Store keeps processed events for config.DedupWindow. An event seen again
+// after that is handled again.
+type Store struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewStore(logger *zap.Logger) (*Store, error) {
+	db, err := sqlx.Connect("sqlite3", config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS processed_events (
+		event_id TEXT NOT NULL,
+		handler TEXT NOT NULL,
+		processed_at INTEGER NOT NULL,
+		PRIMARY KEY (event_id, handler)
+	)`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create processed_events table: %w", err)
+	}
+	return &Store{db: db, logger: logger}, nil
+}
+
+// Processed reports whether the handler has processed the event within the window.
+func (s *Store) Processed(eventID string, handler string) (bool, error) {
+	var n int
+	err := s.db.Get(&n, "SELECT COUNT(*) FROM processed_events WHERE event_id = ? AND handler = ? AND processed_at > ?",
+		eventID, handler, time.Now().Add(-config.DedupWindow).Unix())
+	if err != nil {
+		return false, fmt.Errorf("failed to look up processed event: %w", err)
+	}
+	return n > 0, nil
+}
+
+func (s *Store) MarkProcessed(eventID string, handler string) error {
+	_, err := s.db.Exec("INSERT OR REPLACE INTO processed_events (event_id, handler, processed_at) VALUES (?, ?, ?)",
+		eventID, handler, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("failed to record processed event: %w", err)
+	}
+	return nil
+}
+
+// Run removes events older than the window until ctx is canceled.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(config.DedupPruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := s.db.Exec("DELETE FROM processed_events WHERE processed_at <= ?", time.Now().Add(-config.DedupWindow).Unix())
+			if err != nil {
+				s.logger.Error("Failed to prune processed events", zap.Error(err))
+				continue
+			}
+			if n, _ := res.RowsAffected(); n > 0 {
+				s.logger.Info("Pruned processed events", zap.Int64("count", n))
+			}
+		}
+	}
+}
+
+// Ping checks that the database file can be read.
+func (s *Store) Ping(ctx context.Context) error {
+	var n int
+	return s.db.GetContext(ctx, &n, "SELECT COUNT(*) FROM sqlite_master")
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"chatapp/events"
 	"workerservice/config"
+	"workerservice/internal/kafka"
 
 	"go.uber.org/zap"
 	"gopkg.in/gomail.v2"
@@ -14,26 +16,35 @@ type EmailSender struct {
 	logger *zap.Logger
 }
 
-type RegistrationEmail struct {
-	UserID  string `json:"userID"`
-	EmailID string `json:"emailID"`
-}
-
 func NewEmailSender(logger *zap.Logger) *EmailSender {
 	return &EmailSender{
 		logger: logger,
 	}
 }
 
-func (s *EmailSender) SendRegistrationEmail(data []byte) error {
-	var email RegistrationEmail
+// HandleEmail sends the email described by an email topic event.
+func (s *EmailSender) HandleEmail(data []byte) error {
+	var email events.Email
 	if err := json.Unmarshal(data, &email); err != nil {
-		return fmt.Errorf("failed to unmarshal email data: %w", err)
+		return kafka.Permanent(fmt.Errorf("failed to unmarshal email data: %w", err))
 	}
 
+	switch email.Type {
+	case events.EmailRegistration:
+		return s.SendRegistrationEmail(email)
+	case events.EmailPasswordReset:
+		return s.SendPasswordResetEmail(email)
+	case events.EmailVerification:
+		return s.SendVerificationEmail(email)
+	default:
+		return kafka.Permanent(fmt.Errorf("unknown email type %q", email.Type))
+	}
+}
+
+func (s *EmailSender) SendRegistrationEmail(email events.Email) error {
 	m := gomail.NewMessage()
 	m.SetHeader("From", config.FromEmail)
-	m.SetHeader("To", email.EmailID)
+	m.SetHeader("To", email.To)
 	m.SetHeader("Subject", "Welcome to Our Service")
 	m.SetBody("text/html", fmt.Sprintf(`
         <p>Dear User,</p>
@@ -42,18 +53,70 @@ func (s *EmailSender) SendRegistrationEmail(data []byte) error {
         <p>Best regards,<br>Bikram</p>
     `, email.UserID))
 
+	s.logger.Info("Sending registration email", zap.String("email", email.To))
+	if err := s.send(m); err != nil {
+		return err
+	}
+
+	s.logger.Info("Registration email sent successfully", zap.String("email", email.To))
+	return nil
+}
+
+func (s *EmailSender) SendPasswordResetEmail(email events.Email) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", config.FromEmail)
+	m.SetHeader("To", email.To)
+	m.SetHeader("Subject", "Reset your password")
+	m.SetBody("text/html", fmt.Sprintf(`
+        <p>Dear User,</p>
+        <p>A password reset was requested for user ID %s.</p>
+        <p>Use the following token with POST /api/v1/reset-password to choose a new password. It can be used once and expires shortly.</p>
+        <p><code>%s</code></p>
+        <p>If you did not request this, you can ignore this email.</p>
+        <p>Best regards,<br>Bikram</p>
+    `, email.UserID, email.Token))
+
+	s.logger.Info("Sending password reset email", zap.String("email", email.To))
+	if err := s.send(m); err != nil {
+		return err
+	}
+
+	s.logger.Info("Password reset email sent successfully", zap.String("email", email.To))
+	return nil
+}
+
+func (s *EmailSender) SendVerificationEmail(email events.Email) error {
+	m := gomail.NewMessage()
+	m.SetHeader("From", config.FromEmail)
+	m.SetHeader("To", email.To)
+	m.SetHeader("Subject", "Confirm your new email address")
+	m.SetBody("text/html", fmt.Sprintf(`
+        <p>Dear User,</p>
+        <p>This address was entered as the new email for user ID %s.</p>
+        <p>Use the following token with POST /api/v1/verify-email to confirm the change.</p>
+        <p><code>%s</code></p>
+        <p>If you did not request this, you can ignore this email.</p>
+        <p>Best regards,<br>Bikram</p>
+    `, email.UserID, email.Token))
+
+	s.logger.Info("Sending email verification", zap.String("email", email.To))
+	if err := s.send(m); err != nil {
+		return err
+	}
+
+	s.logger.Info("Email verification sent successfully", zap.String("email", email.To))
+	return nil
+}
+
+func (s *EmailSender) send(m *gomail.Message) error {
 	d := gomail.NewDialer(
 		config.SMTPHost,
 		config.SMTPPort,
 		config.SMTPUser,
 		config.SMTPPassword,
 	)
-
-	s.logger.Info("Sending registration email", zap.String("email", email.EmailID))
 	if err := d.DialAndSend(m); err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
-
-	s.logger.Info("Registration email sent successfully", zap.String("email", email.EmailID))
 	return nil
 }
@@ -0,0 +1,63 @@
+// Package health serves the worker's Prometheus metrics and its liveness and
+// readiness checks over HTTP.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"workerservice/config"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// Check returns an error when a dependency can't be reached.
+type Check func(ctx context.Context) error
+
+type status struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// NewServer returns a server on addr for /metrics, /healthz, which runs the
+// liveness checks, and /readyz, which runs the liveness and readiness checks.
+// Both answer 503 when a check fails.
+func NewServer(addr string, liveness map[string]Check, readiness map[string]Check, logger *zap.Logger) *http.Server {
+	ready := make(map[string]Check, len(liveness)+len(readiness))
+	for name, check := range liveness {
+		ready[name] = check
+	}
+	for name, check := range readiness {
+		ready[name] = check
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.Handle("GET /healthz", checkHandler(liveness, logger))
+	mux.Handle("GET /readyz", checkHandler(ready, logger))
+	return &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: config.HealthCheckTimeout}
+}
+
+func checkHandler(checks map[string]Check, logger *zap.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), config.HealthCheckTimeout)
+		defer cancel()
+
+		res, code := status{Status: "ok", Checks: make(map[string]string, len(checks))}, http.StatusOK
+		for name, check := range checks {
+			if err := check(ctx); err != nil {
+				logger.Warn("Health check failed", zap.String("check", name), zap.String("path", r.URL.Path), zap.Error(err))
+				res.Checks[name] = err.Error()
+				res.Status, code = "unavailable", http.StatusServiceUnavailable
+				continue
+			}
+			res.Checks[name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(res)
+	}
+}
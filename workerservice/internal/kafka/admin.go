@@ -2,33 +2,158 @@ package kafka
 
 import (
 	"context"
+	"fmt"
+	"slices"
+	"time"
 
+	"chatapp/broker"
 	"workerservice/config"
 
-	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
-func CreateTopics(logger *zap.Logger) error {
-	topics := []string{config.EmailTopic, config.LogsTopic, config.MessageTopic}
-	for _, topic := range topics {
-		conn, err := kafka.DialLeader(context.Background(), "tcp", config.KafkaBrokers, topic, 0)
-		if err != nil {
-			logger.Fatal("Error connecting to Kafka leader", zap.String("topic", topic), zap.Error(err))
+// TopicConfigs returns the configuration of every topic in Topics, from
+// config.TopicSpecs.
+func TopicConfigs() []broker.TopicConfig {
+	topics := make([]broker.TopicConfig, 0, len(Topics()))
+	for _, topic := range Topics() {
+		topics = append(topics, TopicConfig(topic))
+	}
+	return topics
+}
+
+// TopicConfig returns the configuration of a topic from its spec, or from the
+// spec of its topic for a retry topic.
+func TopicConfig(topic string) broker.TopicConfig {
+	spec, ok := config.TopicSpecs[topic]
+	if original, retry := RetryOf(topic); !ok && retry {
+		spec, ok = config.TopicSpecs[original]
+	}
+	if !ok {
+		spec = config.DefaultTopicSpec
+	}
+	return broker.TopicConfig{
+		Topic:             topic,
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		Retention:         spec.Retention,
+		Compact:           spec.Compact,
+	}
+}
+
+// ReconcileTopics creates the topics in Topics that don't exist and alters the
+// ones whose partitions, retention or compaction fall short of their spec. A
+// topic that can't be altered is logged and left as it is; the error is only
+// for topics that couldn't be described or created.
+func ReconcileTopics(b broker.Broker, logger *zap.Logger) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	existing, err := b.DescribeTopics(ctx, Topics()...)
+	if err != nil {
+		return fmt.Errorf("failed to describe topics: %w", err)
+	}
+	current := make(map[string]broker.TopicInfo, len(existing))
+	for _, t := range existing {
+		current[t.Topic] = t
+	}
+
+	var missing []broker.TopicConfig
+	var names []string
+	for _, want := range TopicConfigs() {
+		have, ok := current[want.Topic]
+		if !ok {
+			missing = append(missing, want)
+			names = append(names, want.Topic)
+			continue
+		}
+		target, changes, kept := topicChanges(want, have)
+		for _, difference := range kept {
+			logger.Warn("Topic differs from its spec in a setting that can't be changed", zap.String("topic", want.Topic),
+				zap.String("difference", difference))
+		}
+		if len(changes) == 0 {
 			continue
 		}
-		defer conn.Close()
-
-		err = conn.CreateTopics(kafka.TopicConfig{
-			Topic:             topic,
-			NumPartitions:     3,
-			ReplicationFactor: 1,
-		})
-		if err != nil {
-			logger.Error("Error creating topic", zap.String("topic", topic), zap.Error(err))
+		if err := b.AlterTopic(ctx, target); err != nil {
+			logger.Error("Failed to alter topic", zap.String("topic", want.Topic), zap.Strings("changes", changes), zap.Error(err))
+			continue
+		}
+		logger.Info("Topic altered", zap.String("topic", want.Topic), zap.Strings("changes", changes))
+	}
+
+	if len(missing) > 0 {
+		if err := b.CreateTopics(ctx, missing...); err != nil {
+			return fmt.Errorf("failed to create topics: %w", err)
+		}
+		logger.Info("Topics created", zap.Strings("topics", names))
+	}
+	return nil
+}
+
+// topicChanges returns the configuration that brings have to want as far as
+// it can be changed, a description of each change, and of each difference
+// that can't be changed.
+func topicChanges(want broker.TopicConfig, have broker.TopicInfo) (broker.TopicConfig, []string, []string) {
+	target := have.TopicConfig
+	var changes, kept []string
+	// brokers without partitions report none
+	if have.NumPartitions > 0 && want.NumPartitions != have.NumPartitions {
+		if want.NumPartitions > have.NumPartitions {
+			target.NumPartitions = want.NumPartitions
+			changes = append(changes, fmt.Sprintf("partitions %d -> %d", have.NumPartitions, want.NumPartitions))
 		} else {
-			logger.Info("Topic created successfully", zap.String("topic", topic))
+			kept = append(kept, fmt.Sprintf("partitions %d, spec %d; partitions can't be removed", have.NumPartitions, want.NumPartitions))
 		}
 	}
+	if want.ReplicationFactor != 0 && want.ReplicationFactor != have.ReplicationFactor {
+		kept = append(kept, fmt.Sprintf("replication factor %d, spec %d; reassign its partitions to change it",
+			have.ReplicationFactor, want.ReplicationFactor))
+	}
+	if want.Retention != 0 && want.Retention != have.Retention {
+		target.Retention = want.Retention
+		changes = append(changes, fmt.Sprintf("retention %s -> %s", FormatRetention(have.Retention), FormatRetention(want.Retention)))
+	}
+	if want.Compact != have.Compact {
+		target.Compact = want.Compact
+		changes = append(changes, fmt.Sprintf("compact %t -> %t", have.Compact, want.Compact))
+	}
+	return target, changes, kept
+}
+
+// Settings of a topic that an alter can change besides its partitions.
+const (
+	SettingRetention  = "retention"
+	SettingCompaction = "compaction"
+)
+
+// CheckSpecOwned returns an error naming the first of the settings that
+// config.TopicSpecs sets for topic. ReconcileTopics would set such a setting
+// back to the spec at the next start of the worker, so it is changed there.
+// A spec without a retention leaves the retention to the broker.
+func CheckSpecOwned(topic string, settings []string) error {
+	if !slices.Contains(Topics(), topic) {
+		return nil
+	}
+	spec := TopicConfig(topic)
+	for _, setting := range settings {
+		if setting == SettingRetention && spec.Retention == 0 {
+			continue
+		}
+		return fmt.Errorf("%s: the %s of this topic comes from config.TopicSpecs; change it there and run apply", topic, setting)
+	}
 	return nil
 }
+
+// FormatRetention formats a topic retention, which is negative for forever.
+func FormatRetention(d time.Duration) string {
+	switch {
+	case d < 0:
+		return "forever"
+	case d == 0:
+		return "default"
+	case d%(24*time.Hour) == 0:
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
@@ -0,0 +1,80 @@
+package kafka
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"chatapp/broker"
+	"workerservice/config"
+)
+
+func TestTopicChanges(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	want := broker.TopicConfig{Topic: "t", NumPartitions: 6, ReplicationFactor: 2, Retention: week}
+	have := func(partitions int, replication int, retention time.Duration, compact bool) broker.TopicInfo {
+		return broker.TopicInfo{TopicConfig: broker.TopicConfig{Topic: "t", NumPartitions: partitions,
+			ReplicationFactor: replication, Retention: retention, Compact: compact}}
+	}
+	for _, c := range []struct {
+		name    string
+		want    broker.TopicConfig
+		have    broker.TopicInfo
+		target  broker.TopicConfig
+		changes []string
+		kept    []string
+	}{
+		{"as specified", want, have(6, 2, week, false), have(6, 2, week, false).TopicConfig, nil, nil},
+		{"missing partitions", want, have(3, 2, week, false),
+			have(6, 2, week, false).TopicConfig, []string{"partitions 3 -> 6"}, nil},
+		{"extra partitions", want, have(9, 2, week, false),
+			have(9, 2, week, false).TopicConfig, nil, []string{"partitions 9, spec 6; partitions can't be removed"}},
+		// brokers without partitions report none
+		{"no partitions", want, have(0, 2, week, false), have(0, 2, week, false).TopicConfig, nil, nil},
+		{"replication factor", want, have(6, 1, week, false),
+			have(6, 1, week, false).TopicConfig, nil, []string{"replication factor 1, spec 2; reassign its partitions to change it"}},
+		{"retention", want, have(6, 2, 24*time.Hour, false),
+			have(6, 2, week, false).TopicConfig, []string{"retention 1d -> 7d"}, nil},
+		// a spec without a retention leaves the broker's
+		{"default retention", broker.TopicConfig{Topic: "t", NumPartitions: 6}, have(6, 2, 24*time.Hour, false),
+			have(6, 2, 24*time.Hour, false).TopicConfig, nil, nil},
+		{"compaction", want, have(6, 2, week, true),
+			have(6, 2, week, false).TopicConfig, []string{"compact true -> false"}, nil},
+	} {
+		target, changes, kept := topicChanges(c.want, c.have)
+		if target != c.target || !slices.Equal(changes, c.changes) || !slices.Equal(kept, c.kept) {
+			t.Errorf("%s: topicChanges = %+v, %q, %q; want %+v, %q, %q", c.name, target, changes, kept, c.target, c.changes, c.kept)
+		}
+	}
+}
+
+func TestCheckSpecOwned(t *testing.T) {
+	const noRetention = "spec-without-retention"
+	config.ConsumedTopics = append(slices.Clone(config.ConsumedTopics), noRetention)
+	config.TopicSpecs[noRetention] = config.TopicSpec{Partitions: 3}
+	t.Cleanup(func() {
+		config.ConsumedTopics = slices.DeleteFunc(config.ConsumedTopics, func(topic string) bool { return topic == noRetention })
+		delete(config.TopicSpecs, noRetention)
+	})
+
+	for _, c := range []struct {
+		topic    string
+		settings []string
+		ok       bool
+	}{
+		{config.LogsTopic, nil, true},
+		{config.LogsTopic, []string{SettingRetention}, false},
+		{config.LogsTopic, []string{SettingCompaction}, false},
+		// retry topics get the spec of their topic
+		{RetryTopics(config.LogsTopic)[0], []string{SettingRetention}, false},
+		{config.DeadLetterTopic, []string{SettingCompaction}, false},
+		{noRetention, []string{SettingRetention}, true},
+		{noRetention, []string{SettingRetention, SettingCompaction}, false},
+		// topics the worker doesn't read have no spec
+		{"other", []string{SettingRetention, SettingCompaction}, true},
+	} {
+		if err := CheckSpecOwned(c.topic, c.settings); (err == nil) != c.ok {
+			t.Errorf("CheckSpecOwned(%s, %v) = %v", c.topic, c.settings, err)
+		}
+	}
+}
@@ -2,97 +2,424 @@ package kafka
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
 	"sync"
+	"time"
 
+	"chatapp/broker"
+	"chatapp/events"
 	"workerservice/config"
 
-	"github.com/segmentio/kafka-go"
 	"go.uber.org/zap"
 )
 
 // MessageProcessor is a function type that processes Kafka messages
 type MessageProcessor func(message []byte) error
 
+type handler struct {
+	name    string
+	process MessageProcessor
+}
+
+// Deduplicator remembers which handlers have processed an event. Handler names
+// are qualified with the topic.
+type Deduplicator interface {
+	Processed(eventID string, handler string) (bool, error)
+	MarkProcessed(eventID string, handler string) error
+}
+
+// job is a fetched message and the subscription to commit it on.
+type job struct {
+	sub        broker.Subscription
+	msg        broker.Message
+	generation int
+}
+
+// Consumer reads the consumed topics and their retry topics. A message that a
+// handler fails on is published to the retry topic of its backoff and handled
+// again by that handler alone once the backoff is over, as set by
+// config.RetryPolicies. When the attempts run out it is published to the
+// dead-letter topic, without secrets such as email tokens.
+//
+// Offsets are committed only once a message is handled, retried or
+// dead-lettered, so messages that were fetched but not finished when the
+// worker stops are read again after a restart. All messages with the same key
+// go to the same worker and are handled in order; messages with different keys
+// are handled in parallel.
+//
+// Since a message can be read more than once, a handler skips messages whose
+// event id it has already processed.
 type Consumer struct {
-	readers    []*kafka.Reader
-	handlers   map[string]MessageProcessor
+	subs       []broker.Subscription
+	handlers   map[string][]handler
+	producer   *Producer
+	dedup      Deduplicator
 	numWorkers int
 	wg         sync.WaitGroup
-	jobs       chan kafka.Message
+	subsWg     sync.WaitGroup
+	jobs       []chan job
+	offsets    *offsetTracker
+	metrics    *consumerMetrics
+	lag        *lagCollector
+	ctx        context.Context
 	logger     *zap.Logger
 }
 
-func NewConsumer(logger *zap.Logger) (*Consumer, error) {
-	topics := []string{config.EmailTopic, config.LogsTopic, config.MessageTopic}
-	readers := make([]*kafka.Reader, len(topics))
-	for i, topic := range topics {
-		readers[i] = kafka.NewReader(kafka.ReaderConfig{
-			Brokers: []string{config.KafkaBrokers},
-			GroupID: config.KafkaGroupID,
-			Topic:   topic,
-		})
+// NewConsumer subscribes to every topic in Topics on b and registers the
+// consumer's metrics.
+func NewConsumer(b broker.Broker, producer *Producer, dedup Deduplicator, logger *zap.Logger) (*Consumer, error) {
+	var subs []broker.Subscription
+	for _, topic := range Topics() {
+		sub, err := b.Subscribe(topic, config.KafkaGroupID)
+		if err != nil {
+			for _, s := range subs {
+				s.Close()
+			}
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+		}
+		subs = append(subs, sub)
 	}
 
-	return &Consumer{
-		readers:    readers,
-		handlers:   make(map[string]MessageProcessor),
+	jobs := make([]chan job, config.KafkaWorkers)
+	for i := range jobs {
+		jobs[i] = make(chan job, config.KafkaWorkerQueue)
+	}
+	c := &Consumer{
+		subs:       subs,
+		handlers:   make(map[string][]handler),
+		producer:   producer,
+		dedup:      dedup,
 		numWorkers: config.KafkaWorkers,
-		jobs:       make(chan kafka.Message, 1000),
+		jobs:       jobs,
+		offsets:    newOffsetTracker(logger),
+		metrics:    newConsumerMetrics(),
+		lag:        newLagCollector(),
 		logger:     logger,
-	}, nil
+	}
+	c.registerMetrics()
+	return c, nil
 }
 
-func (c *Consumer) RegisterHandler(topic string, handler MessageProcessor) {
-	c.handlers[topic] = handler
+// RegisterHandler adds a handler for the topic. A topic can have several
+// handlers; each one gets every message. The name identifies the handler in
+// retries and dead letters, so it must be unique per topic and stay the same
+// across releases.
+func (c *Consumer) RegisterHandler(topic string, name string, process MessageProcessor) {
+	c.handlers[topic] = append(c.handlers[topic], handler{name: name, process: process})
 }
 
+// Start reads until ctx is canceled. Cancel ctx before calling Close.
 func (c *Consumer) Start(ctx context.Context) {
+	c.ctx = ctx
 	for i := 0; i < c.numWorkers; i++ {
 		c.wg.Add(1)
-		go c.worker(i, c.jobs)
+		go c.worker(i, c.jobs[i])
 	}
 
-	for _, reader := range c.readers {
-		go func(reader *kafka.Reader) {
+	for _, sub := range c.subs {
+		c.subsWg.Add(1)
+		go func(sub broker.Subscription) {
+			defer c.subsWg.Done()
 			for {
-				m, err := reader.ReadMessage(ctx)
+				m, err := sub.Fetch(ctx)
 				if err != nil {
-					if err == context.Canceled || err == context.DeadlineExceeded || err == kafka.ErrGroupClosed {
-						c.logger.Info("Kafka reader context canceled or closed", zap.Error(err))
+					if ctx.Err() != nil || errors.Is(err, broker.ErrClosed) {
+						c.logger.Info("Subscription context canceled or closed", zap.Error(err))
 						return
 					}
 					//	c.logger.Error("Error reading message", zap.Error(err))
 					continue
 				}
-				c.jobs <- m
+				// every message on a retry topic waits the same delay, so the
+				// ones behind this one in its partition aren't due before it
+				if wait := time.Until(retryAt(m)); wait > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(wait):
+					}
+				}
+				generation := c.offsets.fetched(sub, m)
+				select {
+				case <-ctx.Done():
+					return
+				case c.jobs[c.workerFor(m)] <- job{sub: sub, msg: m, generation: generation}:
+				}
 			}
-		}(reader)
+		}(sub)
 	}
 
-	c.logger.Info("Kafka consumer started")
+	c.subsWg.Add(1)
+	go func() {
+		defer c.subsWg.Done()
+		c.pollLag(ctx)
+	}()
+
+	c.logger.Info("Consumer started")
+}
+
+// workerFor picks the worker of the message's key. Retries go to the same
+// worker as new messages with that key. Messages without a key are spread by
+// partition.
+func (c *Consumer) workerFor(m broker.Message) int {
+	topic := m.Topic
+	if original := m.Header(HeaderTopic); original != "" {
+		topic = original
+	}
+	h := fnv.New32a()
+	h.Write([]byte(topic))
+	if len(m.Key) > 0 {
+		h.Write([]byte{0})
+		h.Write(m.Key)
+	} else {
+		h.Write([]byte{1, byte(m.Partition >> 8), byte(m.Partition)})
+	}
+	return int(h.Sum32() % uint32(c.numWorkers))
 }
 
-func (c *Consumer) worker(id int, jobs <-chan kafka.Message) {
+func (c *Consumer) worker(id int, jobs <-chan job) {
 	defer c.wg.Done()
 	c.logger.Info("Worker started", zap.Int("worker_id", id))
 
-	for msg := range jobs {
-		c.logger.Info("Processing message", zap.Int("worker_id", id), zap.String("topic", msg.Topic), zap.String("value", string(msg.Value)))
-		if handler, ok := c.handlers[msg.Topic]; ok {
-			if err := handler(msg.Value); err != nil {
-				c.logger.Error("Error processing message", zap.Int("worker_id", id), zap.Error(err))
-			}
+	for j := range jobs {
+		// once stopped, queued messages are left uncommitted and read again
+		// after a restart
+		if c.ctx.Err() != nil {
+			continue
+		}
+		msg := j.msg
+		c.metrics.consumed.WithLabelValues(msg.Topic).Inc()
+		c.logger.Info("Processing message", append(messageFields(msg), zap.Int("worker_id", id))...)
+		if c.process(id, msg) {
+			c.offsets.finished(j.sub, msg, j.generation)
 		}
 	}
 
 	c.logger.Info("Worker stopped", zap.Int("worker_id", id))
 }
 
+// process runs the handlers of a message. A retry only goes to the handler
+// that failed it. It reports whether the message is done with and can be
+// committed, which is only false when a retry couldn't be published before
+// the consumer stopped.
+func (c *Consumer) process(workerID int, msg broker.Message) bool {
+	topic, only, attempts := msg.Topic, "", 0
+	if original, ok := RetryOf(msg.Topic); ok && original == msg.Header(HeaderTopic) {
+		topic, only = original, msg.Header(HeaderHandler)
+		attempts, _ = strconv.Atoi(msg.Header(HeaderAttempts))
+	}
+
+	msg, err := c.upgrade(topic, msg)
+	if err != nil {
+		c.logger.Error("Rejecting message with an unsupported schema", zap.String("topic", topic), zap.Error(err))
+		return c.deadLetter(topic, only, msg, attempts, err.Error())
+	}
+
+	done, found := true, false
+	for _, h := range c.handlers[topic] {
+		if only != "" && h.name != only {
+			continue
+		}
+		found = true
+		eventID := msg.Header(HeaderEventID)
+		if eventID != "" && c.processed(eventID, topic, h.name) {
+			c.logger.Info("Skipping message already processed", zap.String("topic", topic), zap.String("handler", h.name),
+				zap.String("eventId", eventID))
+			continue
+		}
+		start := time.Now()
+		err := h.process(msg.Value)
+		c.metrics.duration.WithLabelValues(topic, h.name).Observe(time.Since(start).Seconds())
+		if err != nil {
+			c.metrics.failed.WithLabelValues(topic, h.name).Inc()
+			c.logger.Error("Error processing message", zap.Int("worker_id", workerID), zap.String("topic", topic),
+				zap.String("handler", h.name), zap.Int("attempt", attempts+1), zap.Error(err))
+			if errors.As(err, &permanentError{}) {
+				done = c.deadLetter(topic, h.name, msg, attempts+1, err.Error()) && done
+			} else {
+				done = c.fail(topic, h.name, msg, attempts+1, err.Error()) && done
+			}
+		} else if eventID != "" {
+			if err := c.dedup.MarkProcessed(eventID, topic+"/"+h.name); err != nil {
+				c.logger.Error("Failed to record processed message", zap.String("eventId", eventID), zap.Error(err))
+			}
+		}
+	}
+	if only != "" && !found {
+		c.logger.Error("No handler for retried message", zap.String("topic", topic), zap.String("handler", only))
+		return c.deadLetter(topic, only, msg, attempts, "no handler named "+only)
+	}
+	return done
+}
+
+// upgrade brings the payload of a message from a topic with a schema to the
+// current version of its event type and checks it.
+func (c *Consumer) upgrade(topic string, msg broker.Message) (broker.Message, error) {
+	eventType := msg.Header(events.HeaderType)
+	if eventType == "" {
+		eventType = topicTypes[topic]
+	}
+	if eventType == "" {
+		return msg, nil
+	}
+	version := 0
+	if v := msg.Header(events.HeaderVersion); v != "" {
+		var err error
+		if version, err = strconv.Atoi(v); err != nil {
+			return msg, fmt.Errorf("invalid schema version %q", v)
+		}
+	}
+
+	value, err := events.Upgrade(eventType, version, msg.Value)
+	if err != nil {
+		return msg, err
+	}
+	upgraded := msg
+	upgraded.Value = value
+	upgraded.Headers = withHeader(msg.Headers, events.HeaderType, eventType)
+	upgraded.Headers = withHeader(upgraded.Headers, events.HeaderVersion, strconv.Itoa(events.CurrentVersion(eventType)))
+	return upgraded, nil
+}
+
+// processed reports whether the handler has processed the event. When the
+// store can't tell, the message is handled again.
+func (c *Consumer) processed(eventID string, topic string, handler string) bool {
+	seen, err := c.dedup.Processed(eventID, topic+"/"+handler)
+	if err != nil {
+		c.logger.Error("Failed to check for processed message", zap.String("eventId", eventID), zap.Error(err))
+		return false
+	}
+	return seen
+}
+
+// fail publishes a failed message to its retry topic, or to the dead-letter
+// topic once the topic's attempts are used up.
+func (c *Consumer) fail(topic string, handler string, msg broker.Message, attempts int, reason string) bool {
+	if topic == config.DeadLetterTopic {
+		// there is nowhere left to send it
+		return true
+	}
+	policy := config.RetryPolicies[topic]
+	if attempts >= policy.MaxAttempts {
+		return c.deadLetter(topic, handler, msg, attempts, reason)
+	}
+
+	partition, offset := origin(msg)
+	delay := retryDelay(policy, attempts)
+	at := time.Now().Add(delay)
+	retry := broker.Message{
+		Topic: RetryTopic(topic, delay),
+		Key:   msg.Key,
+		Value: msg.Value,
+		Headers: []broker.Header{
+			{Key: HeaderEventID, Value: []byte(msg.Header(HeaderEventID))},
+			{Key: events.HeaderType, Value: []byte(msg.Header(events.HeaderType))},
+			{Key: events.HeaderVersion, Value: []byte(msg.Header(events.HeaderVersion))},
+			{Key: HeaderTopic, Value: []byte(topic)},
+			{Key: HeaderHandler, Value: []byte(handler)},
+			{Key: HeaderAttempts, Value: []byte(strconv.Itoa(attempts))},
+			{Key: HeaderRetryAt, Value: []byte(strconv.FormatInt(at.UnixMilli(), 10))},
+			{Key: HeaderError, Value: []byte(reason)},
+			{Key: HeaderPartition, Value: []byte(strconv.Itoa(partition))},
+			{Key: HeaderOffset, Value: []byte(strconv.FormatInt(offset, 10))},
+		},
+	}
+	if !c.publish(retry) {
+		return false
+	}
+	c.metrics.retried.WithLabelValues(topic, handler).Inc()
+	c.logger.Warn("Message will be retried", zap.String("topic", topic), zap.String("handler", handler),
+		zap.Int("attempt", attempts+1), zap.Time("retryAt", at))
+	return true
+}
+
+func (c *Consumer) deadLetter(topic string, handler string, msg broker.Message, attempts int, reason string) bool {
+	letter := DeadLetter{
+		EventID:  msg.Header(HeaderEventID),
+		Topic:    topic,
+		Handler:  handler,
+		Key:      string(msg.Key),
+		Error:    reason,
+		Attempts: attempts,
+		FailedAt: time.Now().UTC(),
+	}
+	letter.Partition, letter.Offset = origin(msg)
+	letter.EventType = msg.Header(events.HeaderType)
+	if letter.EventType == "" {
+		letter.EventType = topicTypes[topic]
+	}
+	letter.Payload = string(redact(letter.EventType, msg.Value))
+	letter.SchemaVersion, _ = strconv.Atoi(msg.Header(events.HeaderVersion))
+	value, err := json.Marshal(letter)
+	if err != nil {
+		c.logger.Error("Failed to marshal dead letter, message dropped",
+			append(messageFields(msg), zap.String("handler", handler), zap.Error(err))...)
+		return true
+	}
+	if !c.publish(broker.Message{Topic: config.DeadLetterTopic, Key: msg.Key, Value: value}) {
+		return false
+	}
+	c.metrics.deadLetters.WithLabelValues(topic, handler).Inc()
+	c.logger.Warn("Message moved to the dead-letter topic", zap.String("topic", topic), zap.String("handler", handler),
+		zap.Int("attempts", attempts))
+	return true
+}
+
+// messageFields identify a message in the logs. Its value is left out since
+// emails carry password reset and verification tokens.
+func messageFields(msg broker.Message) []zap.Field {
+	return []zap.Field{
+		zap.String("topic", msg.Topic),
+		zap.Int("partition", msg.Partition),
+		zap.Int64("offset", msg.Offset),
+		zap.String("event_id", msg.Header(HeaderEventID)),
+	}
+}
+
+// publish writes msg, trying again until it succeeds or the consumer is stopped.
+func (c *Consumer) publish(msg broker.Message) bool {
+	wait := time.Second
+	for {
+		err := c.producer.Write(msg)
+		if err == nil {
+			return true
+		}
+		c.logger.Error("Failed to publish message, trying again", zap.String("topic", msg.Topic), zap.Duration("in", wait), zap.Error(err))
+		select {
+		case <-c.ctx.Done():
+			return false
+		case <-time.After(wait):
+		}
+		if wait < 30*time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// origin returns the partition and offset the message was first read from.
+func origin(msg broker.Message) (int, int64) {
+	if p := msg.Header(HeaderPartition); p != "" {
+		partition, _ := strconv.Atoi(p)
+		offset, _ := strconv.ParseInt(msg.Header(HeaderOffset), 10, 64)
+		return partition, offset
+	}
+	return msg.Partition, msg.Offset
+}
+
+// Close waits for the subscriptions to stop fetching and the workers to finish
+// the messages they are handling, then closes the subscriptions.
 func (c *Consumer) Close() error {
-	close(c.jobs)
+	c.subsWg.Wait()
+	for _, jobs := range c.jobs {
+		close(jobs)
+	}
 	c.wg.Wait()
-	for _, reader := range c.readers {
-		if err := reader.Close(); err != nil {
+	for _, sub := range c.subs {
+		if err := sub.Close(); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"chatapp/broker"
+	"workerservice/config"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+// consumerMetrics are exported on /metrics. Handler metrics are labelled with
+// the topic the message was first published to, so retries count towards it.
+type consumerMetrics struct {
+	consumed    *prometheus.CounterVec
+	failed      *prometheus.CounterVec
+	retried     *prometheus.CounterVec
+	deadLetters *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+}
+
+var serviceLabel = prometheus.Labels{"service": "workerservice"}
+
+func newConsumerMetrics() *consumerMetrics {
+	return &consumerMetrics{
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "consumer_messages_total",
+			Help:        "Messages handled, by the topic they were read from.",
+			ConstLabels: serviceLabel,
+		}, []string{"topic"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "consumer_handler_failures_total",
+			Help:        "Messages a handler returned an error for.",
+			ConstLabels: serviceLabel,
+		}, []string{"topic", "handler"}),
+		retried: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "consumer_retries_total",
+			Help:        "Failed messages published to the retry topic.",
+			ConstLabels: serviceLabel,
+		}, []string{"topic", "handler"}),
+		deadLetters: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "consumer_dead_letters_total",
+			Help:        "Messages published to the dead-letter topic.",
+			ConstLabels: serviceLabel,
+		}, []string{"topic", "handler"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "consumer_handler_duration_seconds",
+			Help:        "Time a handler took to process a message.",
+			ConstLabels: serviceLabel,
+			Buckets:     prometheus.DefBuckets,
+		}, []string{"topic", "handler"}),
+	}
+}
+
+// registerMetrics exports the consumer's metrics, the depth of its job queues
+// and the lag of its consumer group.
+func (c *Consumer) registerMetrics() {
+	collectors := []prometheus.Collector{
+		c.metrics.consumed,
+		c.metrics.failed,
+		c.metrics.retried,
+		c.metrics.deadLetters,
+		c.metrics.duration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "consumer_jobs_queued",
+			Help:        "Fetched messages waiting for a worker.",
+			ConstLabels: serviceLabel,
+		}, func() float64 {
+			n := 0
+			for _, jobs := range c.jobs {
+				n += len(jobs)
+			}
+			return float64(n)
+		}),
+		c.lag,
+	}
+	for _, collector := range collectors {
+		if err := prometheus.Register(collector); err != nil {
+			c.logger.Error("Failed to register consumer metric", zap.Error(err))
+		}
+	}
+}
+
+// lagCollector reports the lag the broker gave for the consumer group last.
+type lagCollector struct {
+	desc *prometheus.Desc
+
+	mu   sync.Mutex
+	lags []broker.PartitionLag
+}
+
+func newLagCollector() *lagCollector {
+	return &lagCollector{desc: prometheus.NewDesc("consumer_group_lag",
+		"Messages of a partition published after the last one the consumer group committed.",
+		[]string{"topic", "partition"}, serviceLabel)}
+}
+
+func (l *lagCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- l.desc
+}
+
+func (l *lagCollector) Collect(ch chan<- prometheus.Metric) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, p := range l.lags {
+		ch <- prometheus.MustNewConstMetric(l.desc, prometheus.GaugeValue, float64(p.Lag), p.Topic, strconv.Itoa(p.Partition))
+	}
+}
+
+// pollLag asks the broker for the lag of every subscription each
+// config.LagInterval until ctx is canceled. The partitions of a topic whose
+// lag can't be had are left out rather than reported with an old value.
+func (c *Consumer) pollLag(ctx context.Context) {
+	ticker := time.NewTicker(config.LagInterval)
+	defer ticker.Stop()
+	for {
+		var lags []broker.PartitionLag
+		for _, sub := range c.subs {
+			reqCtx, cancel := context.WithTimeout(ctx, config.LagInterval)
+			l, err := sub.Lag(reqCtx)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				c.logger.Warn("Failed to get consumer group lag", zap.Error(err))
+				continue
+			}
+			lags = append(lags, l...)
+		}
+		c.lag.mu.Lock()
+		c.lag.lags = lags
+		c.lag.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
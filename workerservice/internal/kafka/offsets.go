@@ -0,0 +1,117 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"chatapp/broker"
+
+	"go.uber.org/zap"
+)
+
+// offsetTracker commits the offsets of a partition in order although its
+// messages can finish out of order on different workers. An offset is only
+// committed once every earlier message of the partition is finished.
+type offsetTracker struct {
+	mu         sync.Mutex
+	partitions map[partitionKey]*partitionOffsets
+	logger     *zap.Logger
+}
+
+type partitionKey struct {
+	sub       broker.Subscription
+	partition int
+}
+
+type partitionOffsets struct {
+	mu sync.Mutex
+	// generation changes when the partition is rewound
+	generation int
+	// last is the offset fetched last, -1 before the first
+	last int64
+	// fetched holds the unfinished offsets in the order they were fetched
+	fetched  []int64
+	finished map[int64]broker.Message
+
+	// commitMu is held while committing, without mu, so that fetching and
+	// finishing go on meanwhile. committed is the offset committed last in
+	// commitGeneration.
+	commitMu         sync.Mutex
+	commitGeneration int
+	committed        int64
+}
+
+func newOffsetTracker(logger *zap.Logger) *offsetTracker {
+	return &offsetTracker{partitions: map[partitionKey]*partitionOffsets{}, logger: logger}
+}
+
+func (t *offsetTracker) get(sub broker.Subscription, partition int) *partitionOffsets {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	key := partitionKey{sub: sub, partition: partition}
+	p, ok := t.partitions[key]
+	if !ok {
+		p = &partitionOffsets{finished: map[int64]broker.Message{}, last: -1, committed: -1}
+		t.partitions[key] = p
+	}
+	return p
+}
+
+// fetched records a message handed to a worker and returns the generation to
+// pass to finished. Reading an offset again means the partition was rewound,
+// after a rebalance for instance, so what was tracked before is dropped.
+func (t *offsetTracker) fetched(sub broker.Subscription, msg broker.Message) int {
+	p := t.get(sub, msg.Partition)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if msg.Offset <= p.last {
+		p.fetched, p.finished = nil, map[int64]broker.Message{}
+		p.generation++
+	}
+	p.last = msg.Offset
+	p.fetched = append(p.fetched, msg.Offset)
+	return p.generation
+}
+
+// finished records a handled message and commits the partition up to the
+// last message with no unfinished message before it.
+func (t *offsetTracker) finished(sub broker.Subscription, msg broker.Message, generation int) {
+	p := t.get(sub, msg.Partition)
+	p.mu.Lock()
+	if generation != p.generation {
+		p.mu.Unlock()
+		return
+	}
+	p.finished[msg.Offset] = msg
+	var last *broker.Message
+	for len(p.fetched) > 0 {
+		m, ok := p.finished[p.fetched[0]]
+		if !ok {
+			break
+		}
+		delete(p.finished, p.fetched[0])
+		p.fetched = p.fetched[1:]
+		last = &m
+	}
+	p.mu.Unlock()
+	if last == nil {
+		return
+	}
+
+	p.commitMu.Lock()
+	defer p.commitMu.Unlock()
+	// a worker that finished a later message may have committed it first;
+	// committing this one after it would move the group back
+	if generation < p.commitGeneration || (generation == p.commitGeneration && last.Offset <= p.committed) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := sub.Commit(ctx, *last); err != nil {
+		t.logger.Error("Failed to commit message", zap.String("topic", last.Topic), zap.Int("partition", last.Partition),
+			zap.Int64("offset", last.Offset), zap.Error(err))
+		return
+	}
+	p.commitGeneration, p.committed = generation, last.Offset
+}
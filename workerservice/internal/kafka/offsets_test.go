@@ -0,0 +1,134 @@
+package kafka
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+
+	"chatapp/broker"
+
+	"go.uber.org/zap"
+)
+
+// commitRecorder is a subscription that records the offsets committed on it.
+type commitRecorder struct {
+	mu      sync.Mutex
+	commits []int64
+}
+
+func (r *commitRecorder) Fetch(ctx context.Context) (broker.Message, error) {
+	<-ctx.Done()
+	return broker.Message{}, ctx.Err()
+}
+
+func (r *commitRecorder) Commit(ctx context.Context, msg broker.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commits = append(r.commits, msg.Offset)
+	return nil
+}
+
+func (r *commitRecorder) Lag(ctx context.Context) ([]broker.PartitionLag, error) {
+	return nil, nil
+}
+
+func (r *commitRecorder) Close() error {
+	return nil
+}
+
+func (r *commitRecorder) committed() []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.commits)
+}
+
+func offsetMessage(partition int, offset int64) broker.Message {
+	return broker.Message{Topic: "t", Partition: partition, Offset: offset}
+}
+
+func TestOffsetTrackerCommitsInOrder(t *testing.T) {
+	sub := &commitRecorder{}
+	tracker := newOffsetTracker(zap.NewNop())
+	var generations []int
+	for offset := int64(0); offset < 4; offset++ {
+		generations = append(generations, tracker.fetched(sub, offsetMessage(0, offset)))
+	}
+
+	// 1 and 2 wait for 0, then 3 for itself
+	tracker.finished(sub, offsetMessage(0, 2), generations[2])
+	tracker.finished(sub, offsetMessage(0, 1), generations[1])
+	if got := sub.committed(); len(got) != 0 {
+		t.Fatalf("committed %v before the first message finished", got)
+	}
+	tracker.finished(sub, offsetMessage(0, 0), generations[0])
+	tracker.finished(sub, offsetMessage(0, 3), generations[3])
+	if got, want := sub.committed(), []int64{2, 3}; !slices.Equal(got, want) {
+		t.Fatalf("committed %v, want %v", got, want)
+	}
+}
+
+func TestOffsetTrackerPartitionsAreIndependent(t *testing.T) {
+	sub := &commitRecorder{}
+	tracker := newOffsetTracker(zap.NewNop())
+	blocked := tracker.fetched(sub, offsetMessage(0, 0))
+	free := tracker.fetched(sub, offsetMessage(1, 0))
+
+	tracker.finished(sub, offsetMessage(1, 0), free)
+	if got, want := sub.committed(), []int64{0}; !slices.Equal(got, want) {
+		t.Fatalf("committed %v, want %v", got, want)
+	}
+	tracker.finished(sub, offsetMessage(0, 0), blocked)
+	if got := sub.committed(); len(got) != 2 {
+		t.Fatalf("committed %v, want both partitions", got)
+	}
+}
+
+func TestOffsetTrackerRewind(t *testing.T) {
+	sub := &commitRecorder{}
+	tracker := newOffsetTracker(zap.NewNop())
+	for offset := int64(0); offset < 3; offset++ {
+		tracker.fetched(sub, offsetMessage(0, offset))
+	}
+	tracker.finished(sub, offsetMessage(0, 0), 0)
+
+	// the partition is read again from 1, after a rebalance
+	rewound := tracker.fetched(sub, offsetMessage(0, 1))
+	if rewound == 0 {
+		t.Fatal("reading an offset again didn't start a new generation")
+	}
+	// a message of the old generation is ignored
+	tracker.finished(sub, offsetMessage(0, 2), 0)
+	if got, want := sub.committed(), []int64{0}; !slices.Equal(got, want) {
+		t.Fatalf("committed %v, want %v", got, want)
+	}
+	tracker.finished(sub, offsetMessage(0, 1), rewound)
+	if got, want := sub.committed(), []int64{0, 1}; !slices.Equal(got, want) {
+		t.Fatalf("committed %v, want %v", got, want)
+	}
+}
+
+func TestOffsetTrackerConcurrentFinish(t *testing.T) {
+	sub := &commitRecorder{}
+	tracker := newOffsetTracker(zap.NewNop())
+	const n = 200
+	generations := make([]int, n)
+	for offset := range generations {
+		generations[offset] = tracker.fetched(sub, offsetMessage(0, int64(offset)))
+	}
+
+	var wg sync.WaitGroup
+	for offset := range generations {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			tracker.finished(sub, offsetMessage(0, int64(offset)), generations[offset])
+		}(offset)
+	}
+	wg.Wait()
+
+	got := sub.committed()
+	if !slices.IsSorted(got) || len(got) == 0 || got[len(got)-1] != n-1 {
+		t.Fatalf("commits went back or stopped short: %v", got)
+	}
+}
@@ -0,0 +1,184 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"chatapp/broker"
+	"chatapp/events"
+	"workerservice/config"
+
+	"go.uber.org/zap"
+)
+
+// pipeline runs the consumer over an in-memory broker, with messages published
+// the way userservice and notificationservice publish them to Kafka.
+type pipeline struct {
+	broker   broker.Broker
+	consumer *Consumer
+	cancel   context.CancelFunc
+}
+
+// startPipeline starts a consumer with the handlers register adds and stops it
+// when the test ends.
+func startPipeline(t *testing.T, register func(c *Consumer)) *pipeline {
+	t.Helper()
+	b := broker.NewMemory()
+	consumer, err := NewConsumer(b, NewProducer(b, zap.NewNop()), &memoryDedup{seen: map[string]bool{}}, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	register(consumer)
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer.Start(ctx)
+	p := &pipeline{broker: b, consumer: consumer, cancel: cancel}
+	t.Cleanup(func() {
+		p.cancel()
+		if err := p.consumer.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return p
+}
+
+// publish sends a payload with the event ID, type and version headers.
+func (p *pipeline) publish(t *testing.T, topic string, eventID string, eventType string, version int, payload string) {
+	t.Helper()
+	err := p.broker.Publish(context.Background(), broker.Message{
+		Topic: topic,
+		Key:   []byte("alice"),
+		Value: []byte(payload),
+		Headers: []broker.Header{
+			{Key: events.HeaderEventID, Value: []byte(eventID)},
+			{Key: events.HeaderType, Value: []byte(eventType)},
+			{Key: events.HeaderVersion, Value: []byte(strconv.Itoa(version))},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type memoryDedup struct {
+	mu   sync.Mutex
+	seen map[string]bool
+}
+
+func (d *memoryDedup) Processed(eventID string, handler string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.seen[eventID+"/"+handler], nil
+}
+
+func (d *memoryDedup) MarkProcessed(eventID string, handler string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seen[eventID+"/"+handler] = true
+	return nil
+}
+
+// receive waits for a value from ch.
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	var zero T
+	return zero
+}
+
+func TestPipelineUpgradesAndDeduplicates(t *testing.T) {
+	emails := make(chan events.Email, 10)
+	p := startPipeline(t, func(c *Consumer) {
+		c.RegisterHandler(config.EmailTopic, "send", func(message []byte) error {
+			var email events.Email
+			if err := json.Unmarshal(message, &email); err != nil {
+				return Permanent(err)
+			}
+			emails <- email
+			return nil
+		})
+	})
+
+	v1 := `{"type":"password_reset","userID":"alice","emailID":"a@b.c","token":"t"}`
+	p.publish(t, config.EmailTopic, "e1", events.TypeEmail, 1, v1)
+	// the same event again, as after a producer retry, is skipped
+	p.publish(t, config.EmailTopic, "e1", events.TypeEmail, 1, v1)
+	p.publish(t, config.EmailTopic, "e2", events.TypeEmail, events.EmailVersion, `{"type":"registration","userId":"bob","to":"b@c.d"}`)
+
+	want := []events.Email{
+		{Type: events.EmailPasswordReset, UserID: "alice", To: "a@b.c", Token: "t"},
+		{Type: events.EmailRegistration, UserID: "bob", To: "b@c.d"},
+	}
+	for _, w := range want {
+		if got := receive(t, emails); got != w {
+			t.Fatalf("handled %+v, want %+v", got, w)
+		}
+	}
+	select {
+	case e := <-emails:
+		t.Fatalf("duplicate handled: %+v", e)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestPipelineRetriesAndDeadLetters(t *testing.T) {
+	saved := config.RetryPolicies[config.LogsTopic]
+	config.RetryPolicies[config.LogsTopic] = config.RetryPolicy{MaxAttempts: 3, BackoffBase: 10 * time.Millisecond, BackoffMax: 20 * time.Millisecond}
+	t.Cleanup(func() { config.RetryPolicies[config.LogsTopic] = saved })
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	handled := make(chan string, 10)
+	letters := make(chan DeadLetter, 10)
+	p := startPipeline(t, func(c *Consumer) {
+		c.RegisterHandler(config.LogsTopic, "store", func(message []byte) error {
+			var log events.Log
+			if err := json.Unmarshal(message, &log); err != nil {
+				return Permanent(err)
+			}
+			mu.Lock()
+			attempts[log.Message]++
+			n := attempts[log.Message]
+			mu.Unlock()
+			// "flaky" succeeds on its second attempt, "broken" never does
+			if log.Message == "broken" || n < 2 {
+				return errors.New("database locked")
+			}
+			handled <- log.Message
+			return nil
+		})
+		c.RegisterHandler(config.DeadLetterTopic, "store", func(message []byte) error {
+			var letter DeadLetter
+			if err := json.Unmarshal(message, &letter); err != nil {
+				return err
+			}
+			letters <- letter
+			return nil
+		})
+	})
+
+	p.publish(t, config.LogsTopic, "l1", events.TypeLog, events.LogVersion, `{"message":"flaky","time":"2026-01-01T00:00:00Z"}`)
+	p.publish(t, config.LogsTopic, "l2", events.TypeLog, events.LogVersion, `{"message":"broken","time":"2026-01-01T00:00:00Z"}`)
+
+	if got := receive(t, handled); got != "flaky" {
+		t.Fatalf("handled %s", got)
+	}
+	letter := receive(t, letters)
+	if letter.EventID != "l2" || letter.Topic != config.LogsTopic || letter.Handler != "store" || letter.Attempts != 3 {
+		t.Fatalf("dead letter = %+v", letter)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["broken"] != 3 {
+		t.Fatalf("broken was tried %d times, want 3", attempts["broken"])
+	}
+}
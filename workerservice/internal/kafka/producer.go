@@ -0,0 +1,78 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"chatapp/broker"
+	"chatapp/events"
+	"workerservice/config"
+
+	"go.uber.org/zap"
+)
+
+// Producer publishes retries and dead letters. Each message names its topic.
+type Producer struct {
+	broker broker.Broker
+	logger *zap.Logger
+}
+
+// Connect opens the message broker selected by config.BrokerDriver, or by the
+// environment variables of broker.FromEnv.
+func Connect() (broker.Broker, error) {
+	return broker.Open(broker.FromEnv(broker.Config{
+		Driver:       config.BrokerDriver,
+		KafkaBrokers: config.KafkaBrokers,
+		NATSURL:      config.NATSURL,
+	}))
+}
+
+// NewProducer publishes to b, which Close closes. Messages keep their key, and
+// with it their partition.
+func NewProducer(b broker.Broker, logger *zap.Logger) *Producer {
+	return &Producer{broker: b, logger: logger}
+}
+
+func (p *Producer) Write(msgs ...broker.Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return p.broker.Publish(ctx, msgs...)
+}
+
+// Replay publishes a dead letter's payload to the shortest retry topic of its
+// original topic, to be handled again right away by the handler that failed
+// it, or by every handler of the topic when it was rejected before being handled.
+func (p *Producer) Replay(letter DeadLetter) error {
+	retryTopics := RetryTopics(letter.Topic)
+	if len(retryTopics) == 0 {
+		return fmt.Errorf("topic %s has no retry topic", letter.Topic)
+	}
+	msg := broker.Message{
+		Topic: retryTopics[0],
+		Value: []byte(letter.Payload),
+		Headers: []broker.Header{
+			{Key: HeaderTopic, Value: []byte(letter.Topic)},
+			{Key: HeaderHandler, Value: []byte(letter.Handler)},
+			{Key: HeaderAttempts, Value: []byte("0")},
+		},
+	}
+	if letter.Key != "" {
+		msg.Key = []byte(letter.Key)
+	}
+	if letter.EventID != "" {
+		msg.Headers = append(msg.Headers, broker.Header{Key: HeaderEventID, Value: []byte(letter.EventID)})
+	}
+	if letter.EventType != "" {
+		msg.Headers = append(msg.Headers, broker.Header{Key: events.HeaderType, Value: []byte(letter.EventType)})
+	}
+	if letter.SchemaVersion != 0 {
+		msg.Headers = append(msg.Headers, broker.Header{Key: events.HeaderVersion, Value: []byte(strconv.Itoa(letter.SchemaVersion))})
+	}
+	return p.Write(msg)
+}
+
+func (p *Producer) Close() error {
+	return p.broker.Close()
+}
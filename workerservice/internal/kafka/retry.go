@@ -0,0 +1,172 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"chatapp/broker"
+	"chatapp/events"
+	"workerservice/config"
+)
+
+// Headers of messages on a retry topic. The value is the original payload.
+const (
+	// HeaderEventID is set by the producers and kept on retries
+	HeaderEventID = events.HeaderEventID
+	HeaderTopic   = "x-original-topic"
+	HeaderHandler = "x-handler"
+	// HeaderAttempts is the number of failed attempts so far
+	HeaderAttempts  = "x-attempts"
+	HeaderRetryAt   = "x-retry-at"
+	HeaderError     = "x-error"
+	HeaderPartition = "x-original-partition"
+	HeaderOffset    = "x-original-offset"
+)
+
+// DeadLetter is the payload of the dead-letter topic: the original message and
+// why it was given up on.
+type DeadLetter struct {
+	EventID string `json:"eventId,omitempty"`
+	// EventType and SchemaVersion describe the payload, for topics with a schema
+	EventType     string    `json:"eventType,omitempty"`
+	SchemaVersion int       `json:"schemaVersion,omitempty"`
+	Topic         string    `json:"topic"`
+	Handler       string    `json:"handler"`
+	Key           string    `json:"key,omitempty"`
+	Payload       string    `json:"payload"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	Partition     int       `json:"partition"`
+	Offset        int64     `json:"offset"`
+	FailedAt      time.Time `json:"failedAt"`
+}
+
+// topicTypes are the event types of topics whose messages predate the
+// x-event-type header.
+var topicTypes = map[string]string{
+	config.EmailTopic:   events.TypeEmail,
+	config.LogsTopic:    events.TypeLog,
+	config.MessageTopic: events.TypeChatMessage,
+	config.AuditTopic:   events.TypeAudit,
+	// user events had neither header nor type before events.UserEvent
+	config.UserEventsTopic: events.TypeUser,
+}
+
+// RetryTopic returns the topic that holds the retries of topic that wait for
+// delay, such as email.retry.2m. Each delay of a topic's policy has its own
+// retry topic, so the messages on one are due in the order they were published.
+func RetryTopic(topic string, delay time.Duration) string {
+	var tier string
+	switch {
+	case delay%time.Hour == 0:
+		tier = fmt.Sprintf("%dh", delay/time.Hour)
+	case delay%time.Minute == 0:
+		tier = fmt.Sprintf("%dm", delay/time.Minute)
+	case delay%time.Second == 0:
+		tier = fmt.Sprintf("%ds", delay/time.Second)
+	default:
+		tier = fmt.Sprintf("%dms", delay/time.Millisecond)
+	}
+	return topic + ".retry." + tier
+}
+
+// RetryTopics returns the retry topics of topic, shortest delay first.
+func RetryTopics(topic string) []string {
+	policy := config.RetryPolicies[topic]
+	var topics []string
+	for attempts := 1; attempts < policy.MaxAttempts; attempts++ {
+		retry := RetryTopic(topic, retryDelay(policy, attempts))
+		if len(topics) == 0 || topics[len(topics)-1] != retry {
+			topics = append(topics, retry)
+		}
+	}
+	return topics
+}
+
+// RetryOf returns the topic whose retries a retry topic holds.
+func RetryOf(retryTopic string) (string, bool) {
+	topic, _, ok := strings.Cut(retryTopic, ".retry.")
+	return topic, ok
+}
+
+// Topics returns every topic the worker reads: the consumed topics, their
+// retry topics and the dead-letter topic.
+func Topics() []string {
+	topics := append([]string(nil), config.ConsumedTopics...)
+	for _, topic := range config.ConsumedTopics {
+		topics = append(topics, RetryTopics(topic)...)
+	}
+	return append(topics, config.DeadLetterTopic)
+}
+
+// retryDelay returns the wait before the next attempt after the given number
+// of failed attempts.
+func retryDelay(policy config.RetryPolicy, attempts int) time.Duration {
+	wait := policy.BackoffBase
+	for i := 1; i < attempts && wait < policy.BackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > policy.BackoffMax {
+		wait = policy.BackoffMax
+	}
+	return wait
+}
+
+// withHeader returns a copy of headers with key set to value.
+func withHeader(headers []broker.Header, key string, value string) []broker.Header {
+	out := make([]broker.Header, 0, len(headers)+1)
+	for _, h := range headers {
+		if h.Key != key {
+			out = append(out, h)
+		}
+	}
+	return append(out, broker.Header{Key: key, Value: []byte(value)})
+}
+
+// retryAt returns when a message on a retry topic is due, or the zero time.
+func retryAt(msg broker.Message) time.Time {
+	ms, err := strconv.ParseInt(msg.Header(HeaderRetryAt), 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms)
+}
+
+// redact removes secrets from a payload before it is dead-lettered, since dead
+// letters are kept in logs.db and shown by the deadletters tool. Emails lose
+// their token, in every schema version; one that can't be read is dropped.
+func redact(eventType string, payload []byte) []byte {
+	if eventType != events.TypeEmail {
+		return payload
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &fields); err != nil {
+		return nil
+	}
+	if _, ok := fields["token"]; !ok {
+		return payload
+	}
+	delete(fields, "token")
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks a handler error that retrying can't fix, such as a malformed
+// payload. The message goes to the dead-letter topic right away.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"chatapp/events"
+	"workerservice/config"
+)
+
+func TestRetryTopics(t *testing.T) {
+	// email: 30s doubling up to 15m, 6 attempts, so 5 retries
+	want := []string{"email.retry.30s", "email.retry.1m", "email.retry.2m", "email.retry.4m", "email.retry.8m"}
+	if got := RetryTopics(config.EmailTopic); !slices.Equal(got, want) {
+		t.Fatalf("RetryTopics(email) = %v, want %v", got, want)
+	}
+	if got := RetryTopic("audit", 2*time.Hour); got != "audit.retry.2h" {
+		t.Fatalf("RetryTopic = %s", got)
+	}
+	for _, retry := range RetryTopics(config.AuditTopic) {
+		if topic, ok := RetryOf(retry); !ok || topic != config.AuditTopic {
+			t.Fatalf("RetryOf(%s) = %s, %v", retry, topic, ok)
+		}
+	}
+	if _, ok := RetryOf(config.AuditTopic); ok {
+		t.Fatal("a consumed topic is not a retry topic")
+	}
+}
+
+func TestRedact(t *testing.T) {
+	payload := []byte(`{"type":"password_reset","userId":"u1","to":"a@b.c","token":"secret"}`)
+	var email events.Email
+	if err := json.Unmarshal(redact(events.TypeEmail, payload), &email); err != nil {
+		t.Fatal(err)
+	}
+	if email.Token != "" || email.To != "a@b.c" {
+		t.Fatalf("redacted email = %+v", email)
+	}
+	if got := redact(events.TypeEmail, []byte("not json secret")); got != nil {
+		t.Fatalf("unreadable email kept: %s", got)
+	}
+	chat := []byte(`{"token":"kept"}`)
+	if got := redact(events.TypeChatMessage, chat); string(got) != string(chat) {
+		t.Fatalf("chat message changed: %s", got)
+	}
+}
@@ -23,7 +23,7 @@ type LogEntry struct {
 }
 
 func NewLogRepository(logger *zap.Logger) (*LogRepository, error) {
-	db, err := sqlx.Connect("sqlite3", config.DatabasePath)
+	db, err := sqlx.Connect("sqlite3", config.DatabaseDSN)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
@@ -1,9 +1,11 @@
 package message
 
 import (
+	"chatapp/events"
 	"encoding/json"
 	"fmt"
 	"workerservice/grpcclient"
+	"workerservice/internal/kafka"
 
 	"go.uber.org/zap"
 )
@@ -13,12 +15,6 @@ type MessageHandler struct {
 	grpcClient *grpcclient.Client
 }
 
-type ChatMessage struct {
-	Sender     string   `json:"sender"`
-	Recipients []string `json:"recipients"`
-	Message    string   `json:"message"`
-}
-
 func NewMessageHandler(logger *zap.Logger, addr string) *MessageHandler {
 	grpcClient, err := grpcclient.NewClient(addr)
 	if err != nil {
@@ -28,9 +24,9 @@ func NewMessageHandler(logger *zap.Logger, addr string) *MessageHandler {
 }
 
 func (h *MessageHandler) HandleMessage(data []byte) error {
-	var msg ChatMessage
+	var msg events.ChatMessage
 	if err := json.Unmarshal(data, &msg); err != nil {
-		return fmt.Errorf("failed to unmarshal message: %w", err)
+		return kafka.Permanent(fmt.Errorf("failed to unmarshal message: %w", err))
 	}
 	_, err := h.grpcClient.PushMessage(msg.Sender, msg.Recipients, msg.Message)
 	if err != nil {
@@ -0,0 +1,189 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"workerservice/config"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	KindMessage  = "message"
+	KindReminder = "reminder"
+
+	StatusPending  = "pending"
+	StatusSent     = "sent"
+	StatusCanceled = "canceled"
+	StatusFailed   = "failed"
+)
+
+type Schedule struct {
+	ID         int64     `db:"id"`
+	Owner      string    `db:"owner"`
+	Kind       string    `db:"kind"`
+	Sender     string    `db:"sender"`
+	Recipients string    `db:"recipients"`
+	Message    string    `db:"message"`
+	SendAt     int64     `db:"send_at"`
+	Status     string    `db:"status"`
+	Attempts   int       `db:"attempts"`
+	LastError  string    `db:"last_error"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+func (s Schedule) RecipientList() []string {
+	return strings.Split(s.Recipients, ",")
+}
+
+// Repository keeps schedules in logs.db so that they survive restarts.
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository() (*Repository, error) {
+	db, err := sqlx.Connect("sqlite3", config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	return newRepository(db)
+}
+
+func newRepository(db *sqlx.DB) (*Repository, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS scheduled_messages (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			owner TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			sender TEXT NOT NULL,
+			recipients TEXT NOT NULL,
+			message TEXT NOT NULL,
+			send_at INTEGER NOT NULL,
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (status, send_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_scheduled_messages_owner ON scheduled_messages (owner, status)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create scheduled_messages table: %w", err)
+		}
+	}
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Create(s Schedule) (Schedule, error) {
+	res, err := r.db.Exec(`INSERT INTO scheduled_messages (owner, kind, sender, recipients, message, send_at, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, s.Owner, s.Kind, s.Sender, s.Recipients, s.Message, s.SendAt, StatusPending)
+	if err != nil {
+		return s, fmt.Errorf("failed to insert schedule: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return s, err
+	}
+	var created Schedule
+	if err := r.db.Get(&created, "SELECT * FROM scheduled_messages WHERE id = ?", id); err != nil {
+		return s, fmt.Errorf("failed to load schedule: %w", err)
+	}
+	return created, nil
+}
+
+// CountPending returns how many schedules of the owner are still to be sent.
+func (r *Repository) CountPending(owner string) (int, error) {
+	var n int
+	err := r.db.Get(&n, "SELECT COUNT(*) FROM scheduled_messages WHERE owner = ? AND status = ?", owner, StatusPending)
+	return n, err
+}
+
+// Pending returns the pending schedules of the owner, soonest first.
+func (r *Repository) Pending(owner string) ([]Schedule, error) {
+	var schedules []Schedule
+	err := r.db.Select(&schedules, "SELECT * FROM scheduled_messages WHERE owner = ? AND status = ? ORDER BY send_at, id", owner, StatusPending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// Cancel reports whether a pending schedule of the owner was canceled.
+func (r *Repository) Cancel(id int64, owner string) (bool, error) {
+	res, err := r.db.Exec("UPDATE scheduled_messages SET status = ? WHERE id = ? AND owner = ? AND status = ?",
+		StatusCanceled, id, owner, StatusPending)
+	if err != nil {
+		return false, fmt.Errorf("failed to cancel schedule: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Due returns pending schedules whose time has come.
+func (r *Repository) Due(now time.Time, limit int) ([]Schedule, error) {
+	var schedules []Schedule
+	err := r.db.Select(&schedules, "SELECT * FROM scheduled_messages WHERE status = ? AND send_at <= ? ORDER BY send_at LIMIT ?",
+		StatusPending, now.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find due schedules: %w", err)
+	}
+	return schedules, nil
+}
+
+// Claim starts a delivery attempt of a due schedule. It counts the attempt and
+// moves the schedule to retryAt, so that a delivery whose outcome is never
+// recorded, because the service stopped or the database failed, is tried again
+// then rather than at every poll. It returns false if the schedule was canceled
+// or claimed since it was loaded.
+func (r *Repository) Claim(s *Schedule, retryAt time.Time) (bool, error) {
+	res, err := r.db.Exec(`UPDATE scheduled_messages SET send_at = ?, attempts = attempts + 1
+		WHERE id = ? AND status = ? AND send_at = ? AND attempts = ?`, retryAt.Unix(), s.ID, StatusPending, s.SendAt, s.Attempts)
+	if err != nil {
+		return false, fmt.Errorf("failed to claim schedule: %w", err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	s.Attempts++
+	return true, nil
+}
+
+func (r *Repository) MarkSent(id int64) error {
+	_, err := r.db.Exec("UPDATE scheduled_messages SET status = ?, last_error = '' WHERE id = ?", StatusSent, id)
+	return err
+}
+
+// MarkFailed records the failure of a claimed attempt. The schedule is retried
+// at retryAt, or given up on when retryAt is zero.
+func (r *Repository) MarkFailed(id int64, errMsg string, retryAt time.Time) error {
+	if retryAt.IsZero() {
+		_, err := r.db.Exec("UPDATE scheduled_messages SET status = ?, last_error = ? WHERE id = ?", StatusFailed, errMsg, id)
+		return err
+	}
+	_, err := r.db.Exec("UPDATE scheduled_messages SET send_at = ?, last_error = ? WHERE id = ?", retryAt.Unix(), errMsg, id)
+	return err
+}
+
+// CancelOwner cancels every pending schedule of the owner and returns how many there were.
+func (r *Repository) CancelOwner(owner string) (int64, error) {
+	res, err := r.db.Exec("UPDATE scheduled_messages SET status = ? WHERE owner = ? AND status = ?", StatusCanceled, owner, StatusPending)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel schedules: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// DeleteOwner removes every schedule of a deleted user.
+func (r *Repository) DeleteOwner(owner string) error {
+	_, err := r.db.Exec("DELETE FROM scheduled_messages WHERE owner = ?", owner)
+	return err
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}
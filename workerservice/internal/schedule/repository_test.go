@@ -0,0 +1,101 @@
+package schedule
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+func newTestRepository(t *testing.T) *Repository {
+	t.Helper()
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := newRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+func createDue(t *testing.T, repo *Repository, now time.Time) Schedule {
+	t.Helper()
+	sched, err := repo.Create(Schedule{Owner: "alice", Kind: KindMessage, Sender: "alice", Recipients: "bob",
+		Message: "hi", SendAt: now.Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sched
+}
+
+func TestClaimHoldsScheduleBack(t *testing.T) {
+	repo := newTestRepository(t)
+	now := time.Now()
+	sched := createDue(t, repo, now)
+
+	stale := sched
+	claimed, err := repo.Claim(&sched, now.Add(time.Minute))
+	if err != nil || !claimed {
+		t.Fatalf("Claim = %v, %v", claimed, err)
+	}
+	if sched.Attempts != 1 {
+		t.Fatalf("attempts after a claim = %d, want 1", sched.Attempts)
+	}
+	// a copy loaded before the claim can't claim it again
+	if claimed, err := repo.Claim(&stale, now.Add(time.Minute)); err != nil || claimed {
+		t.Fatalf("Claim of a stale copy = %v, %v", claimed, err)
+	}
+
+	// the outcome was never recorded, as when MarkSent fails: the schedule
+	// isn't due again until the claim runs out
+	if due, err := repo.Due(now, 10); err != nil || len(due) != 0 {
+		t.Fatalf("Due during the claim = %v, %v", due, err)
+	}
+	due, err := repo.Due(now.Add(time.Minute), 10)
+	if err != nil || len(due) != 1 || due[0].Attempts != 1 {
+		t.Fatalf("Due after the claim = %+v, %v", due, err)
+	}
+}
+
+func TestClaimSkipsCanceled(t *testing.T) {
+	repo := newTestRepository(t)
+	sched := createDue(t, repo, time.Now())
+	if ok, err := repo.Cancel(sched.ID, "alice"); err != nil || !ok {
+		t.Fatalf("Cancel = %v, %v", ok, err)
+	}
+	if claimed, err := repo.Claim(&sched, time.Now().Add(time.Minute)); err != nil || claimed {
+		t.Fatalf("Claim of a canceled schedule = %v, %v", claimed, err)
+	}
+}
+
+func TestMarkAfterClaim(t *testing.T) {
+	repo := newTestRepository(t)
+	now := time.Now()
+	sched := createDue(t, repo, now)
+	repo.Claim(&sched, now.Add(time.Minute))
+
+	if err := repo.MarkFailed(sched.ID, "unavailable", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	due, err := repo.Due(now.Add(time.Hour), 10)
+	if err != nil || len(due) != 1 || due[0].LastError != "unavailable" || due[0].Attempts != 1 {
+		t.Fatalf("Due after a failure = %+v, %v", due, err)
+	}
+
+	sched = due[0]
+	repo.Claim(&sched, now.Add(2*time.Hour))
+	if err := repo.MarkSent(sched.ID); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := repo.Due(now.Add(3*time.Hour), 10); err != nil || len(due) != 0 {
+		t.Fatalf("Due after sending = %+v, %v", due, err)
+	}
+	pending, err := repo.CountPending("alice")
+	if err != nil || pending != 0 {
+		t.Fatalf("CountPending = %d, %v", pending, err)
+	}
+}
@@ -0,0 +1,127 @@
+// Package schedule delivers scheduled messages and reminders at their time.
+// Schedules are stored in logs.db, so ones that come due while the service is
+// down are sent as soon as it is back.
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"chatapp/events"
+	"workerservice/config"
+	"workerservice/grpcclient"
+	"workerservice/internal/kafka"
+
+	"go.uber.org/zap"
+)
+
+type Scheduler struct {
+	repo       *Repository
+	grpcClient *grpcclient.Client
+	logger     *zap.Logger
+}
+
+func NewScheduler(repo *Repository, addr string, logger *zap.Logger) *Scheduler {
+	grpcClient, err := grpcclient.NewClient(addr)
+	if err != nil {
+		logger.Fatal("failed to create grpc client", zap.Error(err))
+	}
+	return &Scheduler{repo: repo, grpcClient: grpcClient, logger: logger}
+}
+
+// Run sends due schedules until ctx is canceled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(config.SchedulePollInterval)
+	defer ticker.Stop()
+
+	s.logger.Info("Scheduler started")
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Info("Scheduler stopped")
+			return
+		case <-ticker.C:
+			s.sendDue()
+		}
+	}
+}
+
+func (s *Scheduler) sendDue() {
+	due, err := s.repo.Due(time.Now(), config.ScheduleBatchSize)
+	if err != nil {
+		s.logger.Error("Failed to load due schedules", zap.Error(err))
+		return
+	}
+	for _, sched := range due {
+		s.send(sched)
+	}
+}
+
+func (s *Scheduler) send(sched Schedule) {
+	// an attempt whose outcome wasn't recorded may have delivered the message
+	if sched.Attempts >= config.ScheduleMaxAttempts {
+		s.logger.Warn("Giving up on scheduled message", zap.Int64("scheduleId", sched.ID), zap.Int("attempts", sched.Attempts))
+		if err := s.repo.MarkFailed(sched.ID, "attempts exhausted", time.Time{}); err != nil {
+			s.logger.Error("Failed to record schedule failure", zap.Int64("scheduleId", sched.ID), zap.Error(err))
+		}
+		return
+	}
+	if claimed, err := s.repo.Claim(&sched, time.Now().Add(config.ScheduleRetryDelay)); err != nil {
+		s.logger.Error("Failed to claim schedule", zap.Int64("scheduleId", sched.ID), zap.Error(err))
+		return
+	} else if !claimed {
+		return
+	}
+
+	message := sched.Message
+	if sched.Kind == KindReminder {
+		message = "Reminder: " + message
+	}
+
+	res, err := s.grpcClient.PushMessage(sched.Sender, sched.RecipientList(), message)
+	if err == nil && !res.GetSuccess() {
+		err = fmt.Errorf("PushMessage RPC call unsuccessful")
+	}
+	if err == nil {
+		// if this fails, the claim holds the schedule back until ScheduleRetryDelay
+		if err := s.repo.MarkSent(sched.ID); err != nil {
+			s.logger.Error("Failed to mark schedule sent", zap.Int64("scheduleId", sched.ID), zap.Error(err))
+		}
+		s.logger.Info("Scheduled message sent", zap.Int64("scheduleId", sched.ID), zap.String("owner", sched.Owner))
+		return
+	}
+
+	var retryAt time.Time
+	if sched.Attempts < config.ScheduleMaxAttempts {
+		retryAt = time.Now().Add(config.ScheduleRetryDelay)
+	}
+	s.logger.Warn("Failed to send scheduled message", zap.Int64("scheduleId", sched.ID), zap.Int("attempt", sched.Attempts), zap.Error(err))
+	if err := s.repo.MarkFailed(sched.ID, err.Error(), retryAt); err != nil {
+		s.logger.Error("Failed to record schedule failure", zap.Int64("scheduleId", sched.ID), zap.Error(err))
+	}
+}
+
+// HandleUserEvent drops the schedules of deleted users and cancels the pending
+// ones of suspended users.
+func (s *Scheduler) HandleUserEvent(data []byte) error {
+	var event events.UserEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return kafka.Permanent(fmt.Errorf("failed to unmarshal user event: %w", err))
+	}
+	if event.UserID == "" {
+		return nil
+	}
+	switch event.Type {
+	case events.UserDeleted:
+		return s.repo.DeleteOwner(event.UserID)
+	case events.UserSuspended:
+		n, err := s.repo.CancelOwner(event.UserID)
+		if err != nil {
+			return err
+		}
+		s.logger.Info("Canceled schedules of suspended user", zap.String("userId", event.UserID), zap.Int64("count", n))
+	}
+	return nil
+}
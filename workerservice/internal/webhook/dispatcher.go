@@ -0,0 +1,246 @@
+// Package webhook delivers platform events to subscribed HTTP endpoints.
+//
+// Events from Kafka are written to the delivery log as pending deliveries, one
+// per subscribed webhook, and the dispatcher POSTs them in the background. Each
+// request is signed with the webhook's secret by outbound.Sign:
+//
+//	X-ChatApp-Signature: sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+//
+// where timestamp is the X-ChatApp-Timestamp header in unix seconds.
+//
+// Endpoints are reached through an outbound client, which refuses internal
+// addresses however the URL resolves.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"chatapp/events"
+	"chatapp/outbound"
+	"workerservice/config"
+	"workerservice/internal/kafka"
+
+	"go.uber.org/zap"
+)
+
+const (
+	EventMessageCreated = "message.created"
+	EventUserRegistered = events.UserRegistered
+	EventUserLoggedOut  = events.UserLoggedOut
+	EventUserDeleted    = events.UserDeleted
+)
+
+// EventTypes lists the events a webhook can subscribe to.
+var EventTypes = map[string]bool{
+	EventMessageCreated: true,
+	EventUserRegistered: true,
+	EventUserLoggedOut:  true,
+	EventUserDeleted:    true,
+}
+
+// Payload is the body of every delivery.
+type Payload struct {
+	ID   string      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+type Dispatcher struct {
+	repo   *Repository
+	client *http.Client
+	logger *zap.Logger
+}
+
+func NewDispatcher(repo *Repository, logger *zap.Logger) *Dispatcher {
+	return &Dispatcher{
+		repo:   repo,
+		client: outbound.NewClient(config.WebhookTimeout),
+		logger: logger,
+	}
+}
+
+// HandleMessage turns chat messages into message.created events.
+func (d *Dispatcher) HandleMessage(data []byte) error {
+	var msg events.ChatMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return kafka.Permanent(fmt.Errorf("failed to unmarshal message: %w", err))
+	}
+	return d.Publish(EventMessageCreated, time.Now().UTC(), msg)
+}
+
+// HandleUserEvent forwards account lifecycle events from the users topic.
+func (d *Dispatcher) HandleUserEvent(data []byte) error {
+	var event events.UserEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return kafka.Permanent(fmt.Errorf("failed to unmarshal user event: %w", err))
+	}
+	if !EventTypes[event.Type] {
+		return nil
+	}
+	return d.Publish(event.Type, event.Time, map[string]string{"userId": event.UserID})
+}
+
+// Publish enqueues a delivery of the event to every enabled webhook subscribed to it.
+func (d *Dispatcher) Publish(eventType string, at time.Time, data interface{}) error {
+	webhooks, err := d.repo.SubscribedTo(eventType)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	id, err := events.NewEventID()
+	if err != nil {
+		return err
+	}
+	id = "evt_" + id
+	body, err := json.Marshal(Payload{ID: id, Type: eventType, Time: at, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+	ids := make([]int64, len(webhooks))
+	for i, w := range webhooks {
+		ids[i] = w.ID
+	}
+	return d.repo.Enqueue(ids, id, eventType, body)
+}
+
+// Run delivers due deliveries and prunes old ones until ctx is canceled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(config.WebhookPollInterval)
+	defer ticker.Stop()
+	prune := time.NewTicker(config.WebhookPruneInterval)
+	defer prune.Stop()
+
+	d.logger.Info("Webhook dispatcher started")
+	for {
+		select {
+		case <-ctx.Done():
+			d.logger.Info("Webhook dispatcher stopped")
+			return
+		case <-ticker.C:
+			d.dispatchDue(ctx)
+		case <-prune.C:
+			n, err := d.repo.Prune(time.Now().Add(-config.WebhookDeliveryRetention))
+			if err != nil {
+				d.logger.Error("Failed to prune webhook deliveries", zap.Error(err))
+			} else if n > 0 {
+				d.logger.Info("Pruned webhook deliveries", zap.Int64("count", n))
+			}
+		}
+	}
+}
+
+func (d *Dispatcher) dispatchDue(ctx context.Context) {
+	deliveries, err := d.repo.Due(config.WebhookBatchSize)
+	if err != nil {
+		d.logger.Error("Failed to load due webhook deliveries", zap.Error(err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		webhook, err := d.repo.Get(delivery.WebhookID)
+		if err != nil || webhook == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(w Webhook, delivery Delivery) {
+			defer wg.Done()
+			d.deliver(ctx, w, delivery)
+		}(*webhook, delivery)
+	}
+	wg.Wait()
+}
+
+func (d *Dispatcher) deliver(ctx context.Context, w Webhook, delivery Delivery) {
+	statusCode, err := d.send(ctx, w, delivery)
+	if err == nil {
+		if err := d.repo.RecordSuccess(delivery, statusCode); err != nil {
+			d.logger.Error("Failed to record webhook delivery", zap.Int64("deliveryId", delivery.ID), zap.Error(err))
+		}
+		return
+	}
+
+	var retryAt time.Time
+	if delivery.Attempts+1 < config.WebhookMaxAttempts {
+		retryAt = time.Now().Add(backoff(delivery.Attempts))
+	}
+	d.logger.Warn("Webhook delivery failed", zap.Int64("webhookId", w.ID), zap.Int64("deliveryId", delivery.ID),
+		zap.Int("attempt", delivery.Attempts+1), zap.Error(err))
+
+	failures, recErr := d.repo.RecordFailure(delivery, statusCode, err.Error(), retryAt)
+	if recErr != nil {
+		d.logger.Error("Failed to record webhook delivery", zap.Int64("deliveryId", delivery.ID), zap.Error(recErr))
+		return
+	}
+	if failures >= config.WebhookDisableAfter {
+		reason := fmt.Sprintf("disabled after %d consecutive failed deliveries", failures)
+		if err := d.repo.Disable(w.ID, reason); err != nil {
+			d.logger.Error("Failed to disable webhook", zap.Int64("webhookId", w.ID), zap.Error(err))
+			return
+		}
+		d.logger.Warn("Webhook disabled", zap.Int64("webhookId", w.ID), zap.String("url", w.URL), zap.Int("failures", failures))
+	}
+}
+
+// send POSTs the signed payload and returns the response status code. Anything
+// other than a 2xx response is an error.
+func (d *Dispatcher) send(ctx context.Context, w Webhook, delivery Delivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ChatApp-Webhooks/1.0")
+	req.Header.Set("X-ChatApp-Event", delivery.EventType)
+	req.Header.Set("X-ChatApp-Delivery", delivery.EventID)
+	req.Header.Set(outbound.TimestampHeader, timestamp)
+	req.Header.Set(outbound.SignatureHeader, outbound.Sign(w.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the wait before the next attempt after the given number of
+// earlier attempts.
+func backoff(attempts int) time.Duration {
+	wait := config.WebhookBackoffBase
+	for i := 0; i < attempts && wait < config.WebhookBackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > config.WebhookBackoffMax {
+		wait = config.WebhookBackoffMax
+	}
+	return wait
+}
+
+// NewSecret returns a random signing secret for a webhook.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,70 @@
+package webhook
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+func newTestDispatcher(t *testing.T) *Dispatcher {
+	t.Helper()
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "logs.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	repo, err := newRepository(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return &Dispatcher{repo: repo, logger: zap.NewNop()}
+}
+
+func countDeliveries(t *testing.T, d *Dispatcher) int {
+	t.Helper()
+	var n int
+	if err := d.repo.db.Get(&n, "SELECT COUNT(*) FROM webhook_deliveries"); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestPublishEnqueuesAllOrNothing(t *testing.T) {
+	d := newTestDispatcher(t)
+	var second Webhook
+	for i := 0; i < 2; i++ {
+		w, err := d.repo.Create(Webhook{URL: "https://example.com/hook", Secret: "secret", Events: EventUserDeleted})
+		if err != nil {
+			t.Fatal(err)
+		}
+		second = w
+	}
+	_, err := d.repo.db.Exec(fmt.Sprintf(`CREATE TRIGGER fail_delivery BEFORE INSERT ON webhook_deliveries
+		WHEN NEW.webhook_id = %d BEGIN SELECT RAISE(ABORT, 'disk full'); END`, second.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Publish(EventUserDeleted, time.Now(), nil); err == nil {
+		t.Fatal("Publish succeeded with a failing insert")
+	}
+	// the retry of the event mustn't find the first webhook's delivery already there
+	if n := countDeliveries(t, d); n != 0 {
+		t.Fatalf("%d deliveries stored by a failed Publish", n)
+	}
+
+	if _, err := d.repo.db.Exec("DROP TRIGGER fail_delivery"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Publish(EventUserDeleted, time.Now(), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := countDeliveries(t, d); n != 2 {
+		t.Fatalf("%d deliveries after the retry, want 2", n)
+	}
+}
@@ -0,0 +1,354 @@
+package webhook
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"workerservice/config"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+type Webhook struct {
+	ID  int64  `db:"id"`
+	URL string `db:"url"`
+	// Secret is stored encrypted with config.WebhookSecretKey
+	Secret              string    `db:"secret"`
+	Events              string    `db:"events"`
+	Enabled             bool      `db:"enabled"`
+	ConsecutiveFailures int       `db:"consecutive_failures"`
+	DisabledReason      string    `db:"disabled_reason"`
+	CreatedAt           time.Time `db:"created_at"`
+}
+
+// EventTypes returns the subscribed event types.
+func (w Webhook) EventTypes() []string {
+	return strings.Split(w.Events, ",")
+}
+
+type Delivery struct {
+	ID        int64  `db:"id"`
+	WebhookID int64  `db:"webhook_id"`
+	EventID   string `db:"event_id"`
+	EventType string `db:"event_type"`
+	// Payload is the body to send, emptied once the delivery succeeds or fails
+	// for good. PayloadSHA256 is kept to match against what the endpoint got.
+	Payload        string    `db:"payload"`
+	PayloadSHA256  string    `db:"payload_sha256"`
+	Status         string    `db:"status"`
+	Attempts       int       `db:"attempts"`
+	NextAttemptAt  int64     `db:"next_attempt_at"`
+	LastStatusCode int       `db:"last_status_code"`
+	LastError      string    `db:"last_error"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+}
+
+// Repository stores webhook subscriptions and the delivery log. Pending
+// deliveries are kept here too, so retries survive a restart.
+type Repository struct {
+	db *sqlx.DB
+}
+
+func NewRepository() (*Repository, error) {
+	db, err := sqlx.Connect("sqlite3", config.DatabaseDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	// the dispatcher records results concurrently; sqlite only takes one writer
+	db.SetMaxOpenConns(1)
+	return newRepository(db)
+}
+
+func newRepository(db *sqlx.DB) (*Repository, error) {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			url TEXT NOT NULL,
+			secret TEXT NOT NULL,
+			events TEXT NOT NULL,
+			enabled INTEGER NOT NULL DEFAULT 1,
+			consecutive_failures INTEGER NOT NULL DEFAULT 0,
+			disabled_reason TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event_id TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			payload TEXT NOT NULL,
+			payload_sha256 TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt_at INTEGER NOT NULL,
+			last_status_code INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_updated ON webhook_deliveries (updated_at)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create webhook tables: %w", err)
+		}
+	}
+	r := &Repository{db: db}
+	if err := r.sealPlaintextSecrets(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// sealPlaintextSecrets encrypts the secrets of webhooks created before secrets
+// were encrypted.
+func (r *Repository) sealPlaintextSecrets() error {
+	var webhooks []Webhook
+	if err := r.db.Select(&webhooks, "SELECT * FROM webhooks WHERE secret NOT LIKE ?", sealedPrefix+"%"); err != nil {
+		return fmt.Errorf("failed to find webhook secrets: %w", err)
+	}
+	for _, w := range webhooks {
+		sealed, err := sealSecret(w.Secret)
+		if err != nil {
+			return err
+		}
+		if _, err := r.db.Exec("UPDATE webhooks SET secret = ? WHERE id = ?", sealed, w.ID); err != nil {
+			return fmt.Errorf("failed to encrypt webhook secret: %w", err)
+		}
+	}
+	return nil
+}
+
+func (r *Repository) Create(w Webhook) (Webhook, error) {
+	sealed, err := sealSecret(w.Secret)
+	if err != nil {
+		return w, err
+	}
+	res, err := r.db.Exec("INSERT INTO webhooks (url, secret, events) VALUES (?, ?, ?)", w.URL, sealed, w.Events)
+	if err != nil {
+		return w, fmt.Errorf("failed to insert webhook: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return w, err
+	}
+	created, err := r.Get(id)
+	if err != nil {
+		return w, err
+	}
+	return *created, nil
+}
+
+// Get returns nil when the webhook doesn't exist.
+func (r *Repository) Get(id int64) (*Webhook, error) {
+	var w Webhook
+	err := r.db.Get(&w, "SELECT * FROM webhooks WHERE id = ?", id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	if w.Secret, err = openSecret(w.Secret); err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret of webhook %d: %w", w.ID, err)
+	}
+	return &w, nil
+}
+
+// List returns every webhook without its secret.
+func (r *Repository) List() ([]Webhook, error) {
+	webhooks := []Webhook{}
+	if err := r.db.Select(&webhooks, "SELECT * FROM webhooks ORDER BY id"); err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// SetEnabled turns a webhook on or off. Enabling it again clears its failure count.
+func (r *Repository) SetEnabled(id int64, enabled bool, reason string) (bool, error) {
+	res, err := r.db.Exec("UPDATE webhooks SET enabled = ?, consecutive_failures = 0, disabled_reason = ? WHERE id = ?", enabled, reason, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Delete removes the webhook and its delivery log.
+func (r *Repository) Delete(id int64) (bool, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM webhook_deliveries WHERE webhook_id = ?", id); err != nil {
+		return false, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	res, err := tx.Exec("DELETE FROM webhooks WHERE id = ?", id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, tx.Commit()
+}
+
+// SubscribedTo returns the enabled webhooks subscribed to the event type,
+// without their secrets.
+func (r *Repository) SubscribedTo(eventType string) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := r.db.Select(&webhooks, `SELECT * FROM webhooks WHERE enabled = 1
+		AND (',' || events || ',') LIKE ?`, "%,"+eventType+",%")
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhooks: %w", err)
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	return webhooks, nil
+}
+
+// Enqueue stores a pending delivery of the event to each of the webhooks, due
+// immediately. The deliveries are stored in one transaction, so an event that
+// is retried after a failure isn't delivered twice to the webhooks stored before it.
+func (r *Repository) Enqueue(webhookIDs []int64, eventID string, eventType string, payload []byte) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	sum := sha256.Sum256(payload)
+	now := time.Now().Unix()
+	for _, webhookID := range webhookIDs {
+		_, err := tx.Exec(`INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, payload_sha256, status, next_attempt_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`, webhookID, eventID, eventType, string(payload), hex.EncodeToString(sum[:]), StatusPending, now)
+		if err != nil {
+			return fmt.Errorf("failed to enqueue webhook delivery: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to enqueue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// Due returns pending deliveries whose next attempt is due, for enabled webhooks.
+func (r *Repository) Due(limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := r.db.Select(&deliveries, `SELECT d.* FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+		WHERE d.status = ? AND d.next_attempt_at <= ? AND w.enabled = 1 ORDER BY d.next_attempt_at LIMIT ?`,
+		StatusPending, time.Now().Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find due deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordSuccess marks the delivery succeeded and resets the webhook's failure count.
+func (r *Repository) RecordSuccess(d Delivery, statusCode int) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, last_status_code = ?,
+		last_error = '', payload = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?`, StatusSucceeded, statusCode, d.ID); err != nil {
+		return fmt.Errorf("failed to update delivery: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE webhooks SET consecutive_failures = 0 WHERE id = ?", d.WebhookID); err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RecordFailure schedules the next attempt at retryAt, or marks the delivery
+// failed when retryAt is zero. It returns the webhook's consecutive failure count.
+func (r *Repository) RecordFailure(d Delivery, statusCode int, errMsg string, retryAt time.Time) (int, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	status, next, payload := StatusPending, retryAt.Unix(), d.Payload
+	if retryAt.IsZero() {
+		status, next, payload = StatusFailed, d.NextAttemptAt, ""
+	}
+	if _, err := tx.Exec(`UPDATE webhook_deliveries SET status = ?, attempts = attempts + 1, next_attempt_at = ?,
+		last_status_code = ?, last_error = ?, payload = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+		status, next, statusCode, errMsg, payload, d.ID); err != nil {
+		return 0, fmt.Errorf("failed to update delivery: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE webhooks SET consecutive_failures = consecutive_failures + 1 WHERE id = ?", d.WebhookID); err != nil {
+		return 0, fmt.Errorf("failed to update webhook: %w", err)
+	}
+	var failures int
+	if err := tx.Get(&failures, "SELECT consecutive_failures FROM webhooks WHERE id = ?", d.WebhookID); err != nil {
+		return 0, err
+	}
+	return failures, tx.Commit()
+}
+
+// Disable turns off a webhook that keeps failing and gives up on its pending deliveries.
+func (r *Repository) Disable(id int64, reason string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE webhooks SET enabled = 0, disabled_reason = ? WHERE id = ?", reason, id); err != nil {
+		return fmt.Errorf("failed to disable webhook: %w", err)
+	}
+	if _, err := tx.Exec(`UPDATE webhook_deliveries SET status = ?, last_error = ?, payload = '', updated_at = CURRENT_TIMESTAMP
+		WHERE webhook_id = ? AND status = ?`, StatusFailed, "webhook disabled", id, StatusPending); err != nil {
+		return fmt.Errorf("failed to cancel deliveries: %w", err)
+	}
+	return tx.Commit()
+}
+
+// Deliveries returns the most recent deliveries of a webhook, newest first.
+func (r *Repository) Deliveries(webhookID int64, limit int) ([]Delivery, error) {
+	deliveries := []Delivery{}
+	err := r.db.Select(&deliveries, "SELECT * FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?", webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// Prune deletes finished deliveries whose last attempt is older than the cutoff
+// and returns how many were deleted.
+func (r *Repository) Prune(before time.Time) (int64, error) {
+	res, err := r.db.Exec("DELETE FROM webhook_deliveries WHERE status <> ? AND updated_at < ?",
+		StatusPending, before.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune webhook deliveries: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (r *Repository) Close() error {
+	return r.db.Close()
+}